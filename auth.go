@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const SCOPE_UPLOAD = "upload"
+
+const SCOPE_CONFIG_WRITE = "config:write"
+
+const SCOPE_CONFIG_READ = "config:read"
+
+const SCOPE_METRICS = "metrics"
+
+const API_KEY_HEADER = "X-API-Key"
+
+const JWT_ALG_HS256 = "HS256"
+
+const JWT_ALG_RS256 = "RS256"
+
+// allowed clock skew for exp and nbf
+const JWT_LEEWAY = time.Minute
+
+var ErrUnauthorized = errors.New("missing or invalid credentials")
+
+var ErrForbidden = errors.New("insufficient scope")
+
+type principalContextKey struct{}
+
+type APIKeyConfig struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+	// binds the key to a tenant, empty keys use the default tenant and "*"
+	// keys pick one with X-Tenant-ID
+	Tenant string `json:"tenant"`
+}
+
+type JWTConfig struct {
+	HS256Secret        string `json:"hs256_secret"`
+	RS256PublicKeyFile string `json:"rs256_public_key_file"`
+	Issuer             string `json:"issuer"`
+	Audience           string `json:"audience"`
+}
+
+// AuthConfig enables authentication when present, requests then need an
+// API key or bearer token carrying the scope of the route.
+type AuthConfig struct {
+	APIKeys    []*APIKeyConfig `json:"api_keys"`
+	JWT        *JWTConfig      `json:"jwt"`
+	PublicRead bool            `json:"public_read"`
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name   string
+	Scopes map[string]bool
+	Tenant string
+	// set for admin API keys, they choose the tenant per request
+	AnyTenant bool
+}
+
+func NewPrincipal(name string, scopes []string) *Principal {
+	principal := &Principal{
+		Name:   name,
+		Scopes: make(map[string]bool),
+	}
+	for _, scope := range scopes {
+		principal.Scopes[scope] = true
+	}
+
+	return principal
+}
+
+func (this *Principal) HasScope(scope string) bool {
+	return this != nil && this.Scopes[scope]
+}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+func GetPrincipal(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+
+	return principal
+}
+
+type Authenticator struct {
+	conf   *AuthConfig
+	rsaKey *rsa.PublicKey
+}
+
+func NewAuthenticator(conf *AuthConfig) (*Authenticator, error) {
+	auth := &Authenticator{conf: conf}
+	if conf.JWT != nil && len(conf.JWT.RS256PublicKeyFile) > 0 {
+		data, err := ioutil.ReadFile(conf.JWT.RS256PublicKeyFile)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		auth.rsaKey, err = ParseRSAPublicKey(data)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	return auth, nil
+}
+
+// ParseRSAPublicKey reads a PEM encoded PKIX or PKCS#1 public key or the
+// key of a certificate.
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in public key")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("public key is not an RSA key")
+}
+
+// Authenticate returns the caller of request, nil without error when no
+// credentials were sent.
+func (this *Authenticator) Authenticate(request *http.Request) (*Principal, error) {
+	if key := request.Header.Get(API_KEY_HEADER); len(key) > 0 {
+		return this.verifyAPIKey(key)
+	}
+
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) <= 0 {
+		return nil, nil
+	}
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return nil, ErrUnauthorized
+	}
+
+	return this.VerifyJWT(strings.TrimSpace(parts[1]))
+}
+
+func (this *Authenticator) verifyAPIKey(key string) (*Principal, error) {
+	for _, apiKey := range this.conf.APIKeys {
+		if apiKey == nil || len(apiKey.Key) <= 0 {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey.Key), []byte(key)) == 1 {
+			principal := NewPrincipal(apiKey.Name, apiKey.Scopes)
+			if apiKey.Tenant == TENANT_ANY {
+				principal.AnyTenant = true
+			} else {
+				principal.Tenant = apiKey.Tenant
+			}
+			return principal, nil
+		}
+	}
+
+	return nil, ErrUnauthorized
+}
+
+// stringListClaim decodes a claim given either as a space separated string
+// or as an array, as done for aud and scope.
+type stringListClaim []string
+
+func (this *stringListClaim) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*this = strings.Fields(text)
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	*this = list
+
+	return nil
+}
+
+func (this stringListClaim) contains(value string) bool {
+	for _, item := range this {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  stringListClaim `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     stringListClaim `json:"scope"`
+	Scopes    stringListClaim `json:"scp"`
+	Tenant    string          `json:"tenant"`
+}
+
+func decodeJWTPart(part string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+// VerifyJWT checks the signature and claims of a HS256 or RS256 token, an
+// algorithm is only accepted when its key is configured.
+func (this *Authenticator) VerifyJWT(token string) (*Principal, error) {
+	if this.conf.JWT == nil {
+		return nil, ErrUnauthorized
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized
+	}
+	header := new(jwtHeader)
+	if decodeJWTPart(parts[0], header) != nil {
+		return nil, ErrUnauthorized
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == JWT_ALG_HS256 && len(this.conf.JWT.HS256Secret) > 0:
+		mac := hmac.New(sha256.New, []byte(this.conf.JWT.HS256Secret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrUnauthorized
+		}
+	case header.Alg == JWT_ALG_RS256 && this.rsaKey != nil:
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(this.rsaKey, crypto.SHA256, sum[:], signature) != nil {
+			return nil, ErrUnauthorized
+		}
+	default:
+		return nil, ErrUnauthorized
+	}
+
+	claims := new(jwtClaims)
+	if decodeJWTPart(parts[1], claims) != nil {
+		return nil, ErrUnauthorized
+	}
+	err = this.verifyClaims(claims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	principal := NewPrincipal(claims.Subject, append(claims.Scope, claims.Scopes...))
+	principal.Tenant = claims.Tenant
+
+	return principal, nil
+}
+
+func (this *Authenticator) verifyClaims(claims *jwtClaims, now time.Time) error {
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: token has no expiry", ErrUnauthorized)
+	}
+	unix := float64(now.Unix())
+	leeway := JWT_LEEWAY.Seconds()
+	if unix > *claims.ExpiresAt+leeway {
+		return fmt.Errorf("%w: token expired", ErrUnauthorized)
+	}
+	if claims.NotBefore != nil && unix < *claims.NotBefore-leeway {
+		return fmt.Errorf("%w: token not valid yet", ErrUnauthorized)
+	}
+	if len(this.conf.JWT.Issuer) > 0 && claims.Issuer != this.conf.JWT.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrUnauthorized)
+	}
+	if len(this.conf.JWT.Audience) > 0 && !claims.Audience.contains(this.conf.JWT.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrUnauthorized)
+	}
+
+	return nil
+}
+
+// Authenticate is the router middleware resolving the caller of each
+// request, invalid credentials are rejected whatever the route.
+func (this *HTTPService) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// OSS signs its callback with an Authorization header of its own,
+		// orchestrators probe /healthz and /readyz without credentials
+		if this.config.Auth == nil || request.URL.Path == OSS_CALLBACK_PATH || isHealthPath(request.URL.Path) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if this.authErr != nil {
+			this.ResponseError(errors.New("authentication is misconfigured"), writer, http.StatusInternalServerError)
+			return
+		}
+
+		principal, err := this.auth.Authenticate(request)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			this.ResponseError(err, writer, http.StatusUnauthorized)
+			return
+		}
+		if principal != nil {
+			request = request.WithContext(WithPrincipal(request.Context(), principal))
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// RequireScope only lets callers holding scope through, config reads stay
+// public when public_read is set.
+func (this *HTTPService) RequireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		auth := this.config.Auth
+		if auth == nil || (scope == SCOPE_CONFIG_READ && auth.PublicRead) {
+			handler(writer, request)
+			return
+		}
+
+		principal := GetPrincipal(request.Context())
+		if principal == nil {
+			writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer scope="%s"`, scope))
+			this.ResponseError(ErrUnauthorized, writer, http.StatusUnauthorized)
+			return
+		}
+		if !principal.HasScope(scope) {
+			writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			this.ResponseError(ErrForbidden, writer, http.StatusForbidden)
+			return
+		}
+
+		handler(writer, request)
+	}
+}
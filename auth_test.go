@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg string, claims map[string]interface{}, sign func([]byte) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256Signer(secret string) func([]byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+func TestAuthenticator_VerifyJWT(t *testing.T) {
+	auth, err := NewAuthenticator(&AuthConfig{
+		JWT: &JWTConfig{HS256Secret: "secret", Audience: "spin360"},
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		token string
+		valid bool
+	}{
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"sub": "cms", "exp": exp, "aud": "spin360", "scope": "upload config:write"}, hs256Signer("secret")), true},
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"exp": exp, "aud": []string{"other", "spin360"}}, hs256Signer("secret")), true},
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"exp": exp, "aud": "spin360"}, hs256Signer("wrong")), false},
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix(), "aud": "spin360"}, hs256Signer("secret")), false},
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"aud": "spin360"}, hs256Signer("secret")), false},
+		{signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{"exp": exp, "aud": "other"}, hs256Signer("secret")), false},
+		{signTestJWT(t, "none", map[string]interface{}{"exp": exp, "aud": "spin360"}, func([]byte) []byte { return nil }), false},
+		// RS256 is not configured
+		{signTestJWT(t, JWT_ALG_RS256, map[string]interface{}{"exp": exp, "aud": "spin360"}, hs256Signer("secret")), false},
+		{"not.a.token", false},
+	}
+
+	for i, c := range cases {
+		principal, err := auth.VerifyJWT(c.token)
+		if c.valid != (err == nil) {
+			t.Errorf("case %d: unexpected result %v", i, err)
+		}
+		if !c.valid && !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("case %d: expected ErrUnauthorized, got %v", i, err)
+		}
+		if i == 0 && (!principal.HasScope(SCOPE_UPLOAD) || !principal.HasScope(SCOPE_CONFIG_WRITE) || principal.HasScope(SCOPE_CONFIG_READ)) {
+			t.Errorf("unexpected scopes %v", principal.Scopes)
+		}
+	}
+}
+
+func TestAuthenticator_VerifyJWT_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	keyFile, err := ioutil.TempFile("", "jwt-*.pem")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.Remove(keyFile.Name())
+	pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keyFile.Close()
+
+	auth, err := NewAuthenticator(&AuthConfig{
+		JWT: &JWTConfig{RS256PublicKeyFile: keyFile.Name(), Issuer: "https://auth.example.com"},
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	token := signTestJWT(t, JWT_ALG_RS256, map[string]interface{}{
+		"iss": "https://auth.example.com", "exp": time.Now().Add(time.Hour).Unix(), "scp": []string{SCOPE_CONFIG_READ},
+	}, func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return signature
+	})
+	principal, err := auth.VerifyJWT(token)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if !principal.HasScope(SCOPE_CONFIG_READ) {
+		t.Errorf("unexpected scopes %v", principal.Scopes)
+	}
+
+	// HS256 with the public key as secret must not be accepted
+	_, err = auth.VerifyJWT(signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{
+		"iss": "https://auth.example.com", "exp": time.Now().Add(time.Hour).Unix(),
+	}, hs256Signer(string(der))))
+	if err == nil {
+		t.Error("HS256 token accepted without a secret")
+	}
+}
+
+func TestHTTPService_RequireScope(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		Auth: &AuthConfig{
+			APIKeys: []*APIKeyConfig{
+				&APIKeyConfig{Name: "uploader", Key: "upload-key", Scopes: []string{SCOPE_UPLOAD}},
+				&APIKeyConfig{Name: "prometheus", Key: "metrics-key", Scopes: []string{SCOPE_METRICS}},
+				&APIKeyConfig{Name: "empty", Key: ""},
+			},
+			PublicRead: true,
+		},
+	})
+	handler := httpServer.getHTTPHandler()
+
+	cases := []struct {
+		method string
+		target string
+		key    string
+		status int
+	}{
+		{http.MethodGet, "/task?id=missing", "", http.StatusUnauthorized},
+		{http.MethodGet, "/task?id=missing", "wrong-key", http.StatusUnauthorized},
+		// reaches the handler, which fails the unknown task
+		{http.MethodGet, "/task?id=missing", "upload-key", http.StatusInternalServerError},
+		{http.MethodPost, "/config", "upload-key", http.StatusForbidden},
+		{http.MethodPost, "/config", "", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", "", http.StatusUnauthorized},
+		{http.MethodGet, "/metrics", "upload-key", http.StatusForbidden},
+		{http.MethodGet, "/metrics", "metrics-key", http.StatusOK},
+		{http.MethodGet, HEALTHZ_PATH, "", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		if len(c.key) > 0 {
+			req.Header.Set(API_KEY_HEADER, c.key)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		if writer.Code != c.status {
+			t.Errorf("case %d: expected %d, got %d", i, c.status, writer.Code)
+		}
+	}
+
+	called := false
+	read := httpServer.RequireScope(SCOPE_CONFIG_READ, func(http.ResponseWriter, *http.Request) {
+		called = true
+	})
+	read(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/config/abc", nil))
+	if !called {
+		t.Error("public read was rejected")
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const BUNDLE_FORMAT_VERSION = 1
+
+const BUNDLE_TYPE_SPIN360 = "spin360"
+
+const BUNDLE_TYPE_VR360 = "vr360"
+
+const BUNDLE_MANIFEST_FILE = "manifest.json"
+
+const BUNDLE_CONFIG_FILE = "config.json"
+
+const BUNDLE_ASSETS_DIR = "assets"
+
+// multires tiles keep their layout below this directory of the assets
+const BUNDLE_TILES_DIR = "tiles"
+
+// maximum uncompressed size of manifest.json and config.json
+const BUNDLE_JSON_MAX_SIZE = 16 << 20
+
+// BundleError reports a bundle that can not be imported.
+type BundleError struct {
+	Message string
+}
+
+func (this *BundleError) Error() string {
+	return fmt.Sprintf("invalid bundle: %s", this.Message)
+}
+
+func newBundleError(format string, args ...interface{}) *BundleError {
+	return &BundleError{Message: fmt.Sprintf(format, args...)}
+}
+
+// swagger:model BundleAsset
+type BundleAsset struct {
+	// 资源在 zip 包内的路径
+	Path string `json:"path"`
+	// 导出时的资源URL
+	URL string `json:"url"`
+	// 文件大小
+	Size int64 `json:"size"`
+}
+
+// swagger:model BundleManifest
+type BundleManifest struct {
+	// 包格式版本
+	Version int `json:"version"`
+	// 配置类型, 可能值 "spin360", "vr360"
+	//
+	// enum:
+	//	- spin360
+	//	- vr360
+	Type string `json:"type"`
+	// 导出时的配置hash
+	Hash string `json:"hash"`
+	// 导出时间
+	Exported time.Time `json:"exported"`
+	// 包内资源列表
+	Assets []*BundleAsset `json:"assets"`
+}
+
+// swagger:model BundleImportResult
+type BundleImportResult struct {
+	// 配置类型
+	Type string `json:"type"`
+	// 导入后的配置hash
+	Hash string `json:"hash"`
+	// 导入后的配置URL
+	URL string `json:"url"`
+	// 导入后的配置版本
+	Version *ConfigVersion `json:"version"`
+}
+
+func isRemoteAsset(URL string) bool {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+func hotSpotAssetRefs(images []*HotSpotImage, video *HotSpotVideo, product *HotSpotProduct) []*string {
+	refs := make([]*string, 0)
+	for _, image := range images {
+		if image != nil {
+			refs = append(refs, &image.URL)
+		}
+	}
+	if video != nil {
+		refs = append(refs, &video.URL, &video.Poster)
+	}
+	if product != nil {
+		refs = append(refs, &product.Image)
+	}
+
+	return refs
+}
+
+// assetRefs lists the fields of the config holding media URLs, embed and
+// link URLs point to web pages and are left alone.
+func (this *Spin360Config) assetRefs() []*string {
+	refs := make([]*string, 0)
+	for _, page := range this.Pages {
+		if page != nil {
+			refs = append(refs, &page.ImageURL)
+		}
+	}
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot != nil {
+			refs = append(refs, hotSpotAssetRefs(hotSpot.Images, hotSpot.Video, hotSpot.Product)...)
+		}
+	}
+
+	return refs
+}
+
+func (this *PannellumConfig) assetRefs() []*string {
+	refs := []*string{&this.URL, &this.Preview, &this.Thumbnail, &this.Placeholder}
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot != nil {
+			refs = append(refs, hotSpotAssetRefs(hotSpot.Images, hotSpot.Video, hotSpot.Product)...)
+		}
+	}
+
+	return refs
+}
+
+// Tiles lists the tile and fallback files of the pyramid relative to
+// BasePath, following the layout written by GeneratingTiles.
+func (this *MultiResConfig) Tiles() []string {
+	tiles := make([]string, 0)
+	if this.TileResolution <= 0 || this.CubeResolution <= 0 {
+		return tiles
+	}
+
+	extension := fmt.Sprintf(".%s", this.Extension)
+	count := int(math.Ceil(float64(this.CubeResolution) / float64(this.TileResolution)))
+	for _, face := range faceLetters {
+		size := this.CubeResolution
+		for level := this.MaxLevel; level > 0; level-- {
+			for i := 0; i < count && i*this.TileResolution < size; i++ {
+				for j := 0; j < count && j*this.TileResolution < size; j++ {
+					tiles = append(tiles, fmt.Sprintf("%d/%s%d_%d%s", level, face, i, j, extension))
+				}
+			}
+			size = int(size / 2)
+		}
+	}
+	if len(this.FallbackPath) > 0 {
+		for _, face := range faceLetters {
+			tiles = append(tiles, fmt.Sprintf("fallback/%s%s", face, extension))
+		}
+	}
+
+	return tiles
+}
+
+func bundleAssetName(index int, URL string) string {
+	name := "asset"
+	if u, err := url.Parse(URL); err == nil && len(path.Base(u.Path)) > 1 {
+		name = path.Base(u.Path)
+	}
+
+	return fmt.Sprintf("%d-%s", index, name)
+}
+
+// newBundleAssets maps each distinct remote URL of refs, and the multires
+// tiles below basePath, to a path inside the bundle.
+func newBundleAssets(refs []*string, multiRes *MultiResConfig) []*BundleAsset {
+	assets := make([]*BundleAsset, 0)
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if !isRemoteAsset(*ref) || seen[*ref] {
+			continue
+		}
+		seen[*ref] = true
+		assets = append(assets, &BundleAsset{
+			Path: path.Join(BUNDLE_ASSETS_DIR, bundleAssetName(len(assets), *ref)),
+			URL:  *ref,
+		})
+	}
+
+	if multiRes != nil && isRemoteAsset(multiRes.BasePath) {
+		basePath := strings.TrimRight(multiRes.BasePath, "/")
+		for _, tile := range multiRes.Tiles() {
+			assets = append(assets, &BundleAsset{
+				Path: path.Join(BUNDLE_ASSETS_DIR, BUNDLE_TILES_DIR, tile),
+				URL:  basePath + "/" + tile,
+			})
+		}
+	}
+
+	return assets
+}
+
+func (this *Worker) downloadAsset(asset *BundleAsset, localPath string) error {
+	err := os.MkdirAll(filepath.Dir(localPath), os.ModePerm)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	reader, err := this.DownloadRemoteFile(asset.URL)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer file.Close()
+
+	asset.Size, err = io.Copy(file, reader)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// downloadAssets fetches the assets into dir, two files at a time.
+func (this *Worker) downloadAssets(ctx context.Context, assets []*BundleAsset, dir string) error {
+	queue := make(chan error, 0)
+	maxTask := make(chan bool, 2)
+	defer close(queue)
+	defer close(maxTask)
+
+	for _, asset := range assets {
+		go func(asset *BundleAsset) {
+			maxTask <- true
+			var err error
+			defer func() {
+				<-maxTask
+				queue <- err
+			}()
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			log.Infof("%s => %s", asset.URL, asset.Path)
+
+			err = this.downloadAsset(asset, filepath.Join(dir, filepath.FromSlash(asset.Path)))
+		}(asset)
+	}
+
+	var err error
+	for range assets {
+		downloadErr := <-queue
+		if err == nil {
+			err = downloadErr
+		}
+	}
+
+	return err
+}
+
+func writeJSONFile(localPath string, src interface{}) error {
+	data, err := json.MarshalIndent(src, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	err = ioutil.WriteFile(localPath, data, 0644)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// exportBundle downloads every asset of conf and zips them with the config
+// and a manifest, the zip is removed once ctx is done.
+func (this *Worker) exportBundle(ctx context.Context, manifest *BundleManifest, conf interface{}) (io.Reader, error) {
+	var zipPath string
+
+	err := this.TempDir(func(tempDir string) error {
+		err := this.downloadAssets(ctx, manifest.Assets, tempDir)
+		if err != nil {
+			return err
+		}
+
+		err = writeJSONFile(filepath.Join(tempDir, BUNDLE_CONFIG_FILE), conf)
+		if err != nil {
+			return err
+		}
+		err = writeJSONFile(filepath.Join(tempDir, BUNDLE_MANIFEST_FILE), manifest)
+		if err != nil {
+			return err
+		}
+
+		zipPath = tempDir + ".zip"
+		err = ZipFolder(tempDir, zipPath)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	zipFile, err := os.Open(zipPath)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		zipFile.Close()
+		os.Remove(zipPath)
+	}()
+
+	return zipFile, nil
+}
+
+func newBundleManifest(bundleType string, hash string) *BundleManifest {
+	return &BundleManifest{
+		Version:  BUNDLE_FORMAT_VERSION,
+		Type:     bundleType,
+		Hash:     hash,
+		Exported: time.Now().UTC(),
+	}
+}
+
+// ExportConfig packages a spin360 config and the images it references.
+func (this *Worker) ExportConfig(ctx context.Context, hash string, version int) (io.Reader, error) {
+	conf, _, err := this.GetConfigWithETag(hash, version)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := newBundleManifest(BUNDLE_TYPE_SPIN360, hash)
+	manifest.Assets = newBundleAssets(conf.assetRefs(), nil)
+
+	return this.exportBundle(ctx, manifest, conf)
+}
+
+// ExportVR360Config packages a vr360 config with its panorama, previews and
+// multires tiles.
+func (this *Worker) ExportVR360Config(ctx context.Context, hash string, version int) (io.Reader, error) {
+	conf, _, err := this.GetVR360ConfigWithETag(hash, version)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := newBundleManifest(BUNDLE_TYPE_VR360, hash)
+	manifest.Assets = newBundleAssets(conf.assetRefs(), conf.Config)
+
+	return this.exportBundle(ctx, manifest, conf)
+}
+
+// bundleEntryPath rejects zip entries that would escape the extract dir.
+func bundleEntryPath(name string) (string, error) {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", newBundleError("illegal path %q", name)
+	}
+
+	return clean, nil
+}
+
+func readBundleFile(file *zip.File, dst interface{}) error {
+	if file.UncompressedSize64 > BUNDLE_JSON_MAX_SIZE {
+		return newBundleError("%s: larger than %d bytes", file.Name, BUNDLE_JSON_MAX_SIZE)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return newBundleError("%s: %s", file.Name, err)
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(io.LimitReader(reader, BUNDLE_JSON_MAX_SIZE)).Decode(dst)
+	if err != nil {
+		return newBundleError("%s: %s", file.Name, err)
+	}
+
+	return nil
+}
+
+// extractBundleFile writes file to localPath and takes its uncompressed size
+// from remain, the sizes in the zip headers are not trusted.
+func extractBundleFile(file *zip.File, localPath string, remain *int64) error {
+	if file.UncompressedSize64 > uint64(*remain) {
+		return newBundleError("%s: uncompressed assets exceed %d bytes", file.Name, *remain)
+	}
+	err := os.MkdirAll(filepath.Dir(localPath), os.ModePerm)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return newBundleError("%s: %s", file.Name, err)
+	}
+	defer reader.Close()
+
+	local, err := os.Create(localPath)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer local.Close()
+
+	n, err := io.Copy(local, io.LimitReader(reader, *remain+1))
+	if err != nil {
+		return newBundleError("%s: %s", file.Name, err)
+	}
+	if n > *remain {
+		return newBundleError("%s: uncompressed assets exceed the size limit", file.Name)
+	}
+	*remain -= n
+
+	return nil
+}
+
+// bundleConfig decodes config.json into the config type of the bundle.
+func bundleConfig(manifest *BundleManifest, data []byte) (EditableConfig, func() []*string, error) {
+	switch manifest.Type {
+	case BUNDLE_TYPE_SPIN360:
+		conf := new(Spin360Config)
+		err := json.Unmarshal(data, conf)
+		if err != nil {
+			return nil, nil, newBundleError("%s: %s", BUNDLE_CONFIG_FILE, err)
+		}
+		return conf, conf.assetRefs, nil
+	case BUNDLE_TYPE_VR360:
+		conf, err := DecodeVR360Config(data)
+		if err != nil {
+			return nil, nil, newBundleError("%s: %s", BUNDLE_CONFIG_FILE, err)
+		}
+		return conf, conf.assetRefs, nil
+	}
+
+	return nil, nil, newBundleError("unknown type %q", manifest.Type)
+}
+
+// importBundle uploads the bundle assets to {hash}/ on storage, points the
+// config at the uploaded copies and saves it as a new version of hash when
+// the current one still matches ifMatch.
+func (this *Worker) importBundle(storage IStorage, bundle *zip.Reader, hash string, ifMatch string) (*BundleImportResult, error) {
+	files := make(map[string]*zip.File)
+	for _, file := range bundle.File {
+		name, err := bundleEntryPath(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !file.FileInfo().IsDir() {
+			files[name] = file
+		}
+	}
+
+	manifestFile, ok := files[BUNDLE_MANIFEST_FILE]
+	if !ok {
+		return nil, newBundleError("missing %s", BUNDLE_MANIFEST_FILE)
+	}
+	manifest := new(BundleManifest)
+	err := readBundleFile(manifestFile, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version > BUNDLE_FORMAT_VERSION {
+		return nil, newBundleError("unsupported version %d", manifest.Version)
+	}
+
+	if len(hash) <= 0 {
+		hash = manifest.Hash
+	}
+	if len(hash) <= 0 {
+		hash = uuid.NewV4().String()
+	}
+	if ValidateConfigHash(hash) != nil {
+		return nil, newBundleError("illegal hash %q", hash)
+	}
+
+	configFile, ok := files[BUNDLE_CONFIG_FILE]
+	if !ok {
+		return nil, newBundleError("missing %s", BUNDLE_CONFIG_FILE)
+	}
+	var data json.RawMessage
+	err = readBundleFile(configFile, &data)
+	if err != nil {
+		return nil, err
+	}
+	conf, assetRefs, err := bundleConfig(manifest, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// fail before the assets of hash are overwritten
+	store := NewConfigStore(storage)
+	if len(ifMatch) > 0 {
+		current, err := store.LoadRaw(hash, 0)
+		if err == ErrObjectNotFound || err == nil && !MatchETag(ifMatch, ConfigETag(current)) {
+			return nil, ErrPreconditionFailed
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the whole bundle may not unpack to more than a download
+	remain := NewDownloadPolicy(this.Conf.Download).MaxSize()
+	result := &BundleImportResult{Type: manifest.Type, Hash: hash}
+	err = this.TempDir(func(tempDir string) error {
+		urls := make(map[string]string)
+		hasTiles := false
+		for _, asset := range manifest.Assets {
+			name, err := bundleEntryPath(asset.Path)
+			if err != nil {
+				return err
+			}
+			file, ok := files[name]
+			if !ok || !strings.HasPrefix(name, BUNDLE_ASSETS_DIR+"/") {
+				return newBundleError("missing asset %s", asset.Path)
+			}
+			key := strings.TrimPrefix(name, BUNDLE_ASSETS_DIR+"/")
+			err = extractBundleFile(file, filepath.Join(tempDir, filepath.FromSlash(key)), &remain)
+			if err != nil {
+				return err
+			}
+			urls[asset.URL] = storage.URL(path.Join(hash, key))
+			hasTiles = hasTiles || strings.HasPrefix(key, BUNDLE_TILES_DIR+"/")
+		}
+
+		err := this.UploadDir(storage, tempDir, hash)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range assetRefs() {
+			if newURL, ok := urls[*ref]; ok {
+				*ref = newURL
+			}
+		}
+		if vr360, ok := conf.(*PannellumConfig); ok && hasTiles && vr360.Config != nil {
+			vr360.Config.BasePath = storage.URL(path.Join(hash, BUNDLE_TILES_DIR))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conf.EnsureHotSpotIds()
+	if errs := conf.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	result.URL, result.Version, err = store.SaveIfMatch(hash, conf, ifMatch)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (this *Worker) bundleStorage(bundle *zip.Reader) (IStorage, error) {
+	manifest := new(BundleManifest)
+	for _, file := range bundle.File {
+		if path.Clean(file.Name) == BUNDLE_MANIFEST_FILE {
+			err := readBundleFile(file, manifest)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch manifest.Type {
+	case BUNDLE_TYPE_SPIN360:
+		return NewS3Storage(this.Conf.S3)
+	case BUNDLE_TYPE_VR360:
+		return NewS3Storage(this.GetVR360S3Config())
+	}
+
+	return nil, newBundleError("unknown type %q", manifest.Type)
+}
+
+// ImportBundle imports an exported zip into the configured storage, an
+// empty hash keeps the hash the config was exported with.
+func (this *Worker) ImportBundle(src io.ReaderAt, size int64, hash string, ifMatch string) (*BundleImportResult, error) {
+	bundle, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, newBundleError("%s", err)
+	}
+
+	storage, err := this.bundleStorage(bundle)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return this.importBundle(storage, bundle, hash, ifMatch)
+}
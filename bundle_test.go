@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMultiResConfig_Tiles(t *testing.T) {
+	conf := &MultiResConfig{
+		FallbackPath:   "/fallback/%s",
+		Extension:      "jpg",
+		TileResolution: 512,
+		MaxLevel:       2,
+		CubeResolution: 1024,
+	}
+
+	tiles := conf.Tiles()
+	// level 2: 2x2 tiles, level 1: 1 tile, for 6 faces plus 6 fallback faces
+	if len(tiles) != 6*5+6 {
+		t.Errorf("unexpected tile count %d", len(tiles))
+	}
+	if tiles[0] != "2/f0_0.jpg" || tiles[4] != "1/f0_0.jpg" || tiles[len(tiles)-1] != "fallback/r.jpg" {
+		t.Errorf("unexpected tiles %v", tiles)
+	}
+}
+
+func TestWorker_ExportImportBundle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("content of " + request.URL.Path))
+	}))
+	defer server.Close()
+
+	tempPath, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	conf := &Spin360Config{
+		Pages: []*SpinPage{
+			&SpinPage{ImageURL: server.URL + "/spin/0.jpg"},
+			&SpinPage{ImageURL: server.URL + "/spin/1.jpg"},
+		},
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{Id: "a", Type: HOTSPOT_TYPE_IMAGE, Images: []*HotSpotImage{
+				&HotSpotImage{URL: server.URL + "/spin/0.jpg"},
+			}, Coordinates: []*HotSpotCoordinates{&HotSpotCoordinates{X: 0.5, Y: 0.5}}},
+			&PageHotSpot{Id: "b", Type: HOTSPOT_TYPE_LINK, URL: server.URL + "/page.html",
+				Coordinates: []*HotSpotCoordinates{&HotSpotCoordinates{X: 0.5, Y: 0.5}}},
+		},
+	}
+	manifest := newBundleManifest(BUNDLE_TYPE_SPIN360, "abc")
+	manifest.Assets = newBundleAssets(conf.assetRefs(), nil)
+	if len(manifest.Assets) != 2 {
+		t.Errorf("expected duplicate urls to share an asset, got %d", len(manifest.Assets))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := NewWorker(&Config{
+		TempPath: tempPath,
+		Download: &DownloadConfig{AllowCIDRs: []string{"127.0.0.0/8", "::1/128"}},
+	})
+	reader, err := worker.exportBundle(ctx, manifest, conf)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	bundle, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	storage := newMemoryStorage()
+	result, err := worker.importBundle(storage, bundle, "", "")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if result.Hash != "abc" || result.Version.Version != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if storage.objects["abc/0-0.jpg"] != "content of /spin/0.jpg" {
+		t.Errorf("asset not uploaded: %v", storage.objects)
+	}
+
+	imported := new(Spin360Config)
+	_, err = NewConfigStore(storage).Load("abc", 0, imported)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if imported.Pages[1].ImageURL != storage.URL("abc/1-1.jpg") ||
+		imported.HotSpot[0].Images[0].URL != storage.URL("abc/0-0.jpg") ||
+		imported.HotSpot[1].URL != server.URL+"/page.html" {
+		t.Errorf("urls not rewritten: %+v %+v", imported.Pages[1], imported.HotSpot)
+	}
+
+	// overwriting needs the current etag when If-Match is sent
+	_, err = worker.importBundle(storage, bundle, "", `"stale"`)
+	if err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+	result, err = worker.importBundle(storage, bundle, "", result.Version.ETag)
+	if err != nil || result.Version.Version != 2 {
+		t.Errorf("unexpected result %+v %v", result, err)
+	}
+
+	// assets may not unpack to more than download.max_size
+	worker.Conf.Download.MaxSize = 10
+	_, err = worker.importBundle(newMemoryStorage(), bundle, "", "")
+	if _, ok := err.(*BundleError); !ok {
+		t.Errorf("expected BundleError, got %v", err)
+	}
+}
+
+func TestWorker_ImportBundle_IllegalPath(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := zip.NewWriter(buffer)
+	file, _ := writer.Create("../manifest.json")
+	file.Write([]byte(`{"type":"spin360"}`))
+	writer.Close()
+
+	bundle, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	worker := NewWorker(&Config{})
+	_, err = worker.importBundle(newMemoryStorage(), bundle, "", "")
+	if _, ok := err.(*BundleError); !ok {
+		t.Errorf("expected BundleError, got %v", err)
+	}
+}
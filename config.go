@@ -12,38 +12,137 @@ const HOTSPOT_TYPE_LINK = "link"
 
 const HOTSPOT_TYPE_TEXT = "text"
 
+const HOTSPOT_TYPE_IMAGE = "image"
+
+const HOTSPOT_TYPE_VIDEO = "video"
+
+const HOTSPOT_TYPE_PRODUCT = "product"
+
+const HOTSPOT_TYPE_SCENE = "scene"
+
+const HOTSPOT_TARGET_SPIN360 = "spin360"
+
+const HOTSPOT_TARGET_VR360 = "vr360"
+
+type HotSpotImage struct {
+	// 图片URL
+	//
+	// required: true
+	URL string `json:"url"`
+	// 图片说明, 可为字符串或 locale 到文字的映射
+	Caption LocalizedText `json:"caption,omitempty"`
+}
+
+type HotSpotVideo struct {
+	// 视频URL
+	//
+	// required: true
+	URL string `json:"url"`
+	// 视频 MIME 类型, 例如 video/mp4, application/x-mpegURL
+	Type string `json:"type,omitempty"`
+	// 封面图URL
+	Poster string `json:"poster,omitempty"`
+	// 是否自动播放
+	Autoplay bool `json:"autoplay,omitempty"`
+}
+
+type HotSpotProduct struct {
+	// 商品名称, 可为字符串或 locale 到文字的映射
+	//
+	// required: true
+	Title LocalizedText `json:"title"`
+	// 价格
+	Price float64 `json:"price,omitempty"`
+	// ISO 4217 货币代码, 例如 USD, CNY
+	Currency string `json:"currency,omitempty"`
+	// 商品 SKU
+	//
+	// required: true
+	SKU string `json:"sku"`
+	// 购买按钮链接
+	//
+	// required: true
+	CTAURL string `json:"ctaUrl"`
+	// 购买按钮文字, 可为字符串或 locale 到文字的映射
+	CTAText LocalizedText `json:"ctaText,omitempty"`
+	// 商品图片URL
+	Image string `json:"image,omitempty"`
+}
+
+type HotSpotTarget struct {
+	// 目标类型, 可能值 "spin360", "vr360"
+	//
+	// enum:
+	//	- spin360
+	//	- vr360
+	// required: true
+	Type string `json:"type"`
+	// 目标配置hash
+	//
+	// required: true
+	Hash string `json:"hash"`
+	// spin360 目标的初始图片索引
+	PageIndex int `json:"index,omitempty"`
+	// vr360 目标的初始视角
+	Pitch *float64 `json:"pitch,omitempty"`
+	// vr360 目标的初始视角
+	Yaw *float64 `json:"yaw,omitempty"`
+}
+
 type HotSpotCoordinates struct {
 	// 所在图片索引
 	//
 	// required: true
 	PageIndex int `json:"index"`
-	// X 坐标位置
+	// X 坐标位置, unit 为 normalized 时为相对图片宽度的 0-1 小数
 	//
 	// required: true
-	X string `json:"x"`
-	// Y 坐标位置
+	X float64 `json:"x"`
+	// Y 坐标位置, unit 为 normalized 时为相对图片高度的 0-1 小数
 	//
 	// required: true
-	Y string `json:"y"`
+	Y float64 `json:"y"`
+	// 坐标单位, 可能值 "normalized", "px", 默认 normalized
+	//
+	// enum:
+	//	- normalized
+	//	- px
+	Unit string `json:"unit"`
+	// unit of each axis decoded from a legacy string value
+	axisUnits [2]string
 }
 
 type PageHotSpot struct {
-	// 热点类型, 可能值 "embed", "link", "text"
+	// 热点ID, 未提供时自动生成
+	Id string `json:"id"`
+	// 热点类型, 可能值 "embed", "link", "text", "image", "video", "product", "scene"
 	//
 	// enum:
 	//	- embed
 	//	- link
 	//	- text
+	//	- image
+	//	- video
+	//	- product
+	//	- scene
 	// required: true
 	Type string `json:"type"`
 	// Embed/Link 类型 URL
 	//
 	// required: true
 	URL string `json:"url"`
-	// Text 类型文字说明
+	// Text 类型文字说明, 可为字符串或 locale 到文字的映射, 例如 {"en": "Hi", "zh-Hant": "你好"}
 	//
 	// required: true
-	Text string `json:"text"`
+	Text LocalizedText `json:"text"`
+	// Image 类型图集
+	Images []*HotSpotImage `json:"images,omitempty"`
+	// Video 类型视频
+	Video *HotSpotVideo `json:"video,omitempty"`
+	// Product 类型商品卡片
+	Product *HotSpotProduct `json:"product,omitempty"`
+	// Scene 类型跳转目标
+	Target *HotSpotTarget `json:"target,omitempty"`
 	// 热点坐标位置描述
 	//
 	// required: true
@@ -76,8 +175,12 @@ type Spin360Config struct {
 	//
 	// required: true
 	HotSpot []*PageHotSpot `json:"hotspot"`
+	// 默认语言, 热点文字包含多语言时必填, 例如 en, zh-Hant
+	DefaultLocale string `json:"defaultLocale,omitempty"`
 }
 
+// VRHotSpot is the legacy VR360 hotspot shape, it is only accepted on input
+// and converted to the canonical PannellumHotSpot.
 type VRHotSpot struct {
 	//required: true
 	Id string `json:"id"`
@@ -137,13 +240,22 @@ type S3Config struct {
 }
 
 type Config struct {
-	Listen         string        `json:"listen"`
-	FFMpegConf     *FFMPEGConfig `json:"ffmpeg"`
-	S3             *S3Config     `json:"s3"`
-	OSS            *OSSConfig    `json:"aliyun-oss"`
-	WebRoot        string        `json:"web_root"`
-	TempPath       string        `json:"temp"`
-	MaxVideoHeight int           `json:"max_video_height"`
+	Listen         string                   `json:"listen"`
+	FFMpegConf     *FFMPEGConfig            `json:"ffmpeg"`
+	S3             *S3Config                `json:"s3"`
+	OSS            *OSSConfig               `json:"aliyun-oss"`
+	WebRoot        string                   `json:"web_root"`
+	TempPath       string                   `json:"temp"`
+	MaxVideoHeight int                      `json:"max_video_height"`
+	Auth           *AuthConfig              `json:"auth,omitempty"`
+	Tenants        map[string]*TenantConfig `json:"tenants,omitempty"`
+	RateLimit      *RateLimitConfig         `json:"rate_limit,omitempty"`
+	Download       *DownloadConfig          `json:"download,omitempty"`
+	Uploads        *UploadConfig            `json:"uploads,omitempty"`
+	SourceStorage  string                   `json:"source_storage,omitempty"`
+	OSSUpload      *OSSUploadConfig         `json:"oss_upload,omitempty"`
+	S3Upload       *S3UploadConfig          `json:"s3_upload,omitempty"`
+	Health         *HealthConfig            `json:"health,omitempty"`
 	sava_file      string
 }
 
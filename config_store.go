@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const CONFIG_VERSIONS_PREFIX = "versions"
+
+var ErrPreconditionFailed = errors.New("config was modified, reload and retry")
+
+var ErrInvalidConfigHash = errors.New("invalid config hash")
+
+// hashes become object keys, anything else could reach another tenant's prefix
+var configHashPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// serializes read-modify-write of the version index within this process
+var configStoreLock = make(chan bool, 1)
+
+// swagger:model ConfigVersion
+type ConfigVersion struct {
+	// 版本号，从 1 开始递增
+	Version int `json:"version"`
+	// 保存时间
+	Created time.Time `json:"created"`
+	// 该版本的不可变配置文件URL
+	URL string `json:"url"`
+	// 如为回滚产生的版本，记录来源版本号
+	RollbackOf int `json:"rollbackOf,omitempty"`
+	// 该版本内容的 ETag
+	ETag string `json:"etag,omitempty"`
+}
+
+// ConfigETag is the strong entity tag of a stored config document.
+func ConfigETag(data []byte) string {
+	sum := sha1.Sum(data)
+
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:]))
+}
+
+// representationETag is the etag of a response converted from the stored
+// config, e.g. localized, writes keep matching the etag of the stored one.
+func representationETag(body interface{}) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	return ConfigETag(data), nil
+}
+
+// MatchETag checks an If-Match / If-None-Match header value against an etag,
+// weak validators are compared by their opaque part.
+func MatchETag(header string, etag string) bool {
+	for _, item := range strings.Split(header, ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+		if item == "*" || item == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ConfigHistory struct {
+	Versions []*ConfigVersion `json:"versions"`
+}
+
+func (this *ConfigHistory) Latest() int {
+	if len(this.Versions) <= 0 {
+		return 0
+	}
+
+	return this.Versions[len(this.Versions)-1].Version
+}
+
+// ConfigStore keeps {hash}.json as the current config and writes every save
+// as an immutable versions/{hash}/{n}.json object plus an index.
+type ConfigStore struct {
+	storage IStorage
+}
+
+func NewConfigStore(storage IStorage) *ConfigStore {
+	return &ConfigStore{
+		storage: storage,
+	}
+}
+
+func ValidateConfigHash(hash string) error {
+	if !configHashPattern.MatchString(hash) {
+		return fmt.Errorf("%w %q", ErrInvalidConfigHash, hash)
+	}
+
+	return nil
+}
+
+func (this *ConfigStore) currentKey(hash string) string {
+	return fmt.Sprintf("%s.json", hash)
+}
+
+func (this *ConfigStore) versionKey(hash string, version int) string {
+	return fmt.Sprintf("%s/%s/%d.json", CONFIG_VERSIONS_PREFIX, hash, version)
+}
+
+func (this *ConfigStore) historyKey(hash string) string {
+	return fmt.Sprintf("%s/%s/index.json", CONFIG_VERSIONS_PREFIX, hash)
+}
+
+// LoadRaw returns the stored JSON of a version, version 0 is the current config.
+func (this *ConfigStore) LoadRaw(hash string, version int) ([]byte, error) {
+	err := ValidateConfigHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	key := this.currentKey(hash)
+	if version > 0 {
+		key = this.versionKey(hash, version)
+	}
+
+	reader, err := this.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+// Load decodes a version into conf and returns its ETag.
+func (this *ConfigStore) Load(hash string, version int, conf interface{}) (string, error) {
+	data, err := this.LoadRaw(hash, version)
+	if err != nil {
+		return "", err
+	}
+
+	err = json.Unmarshal(data, conf)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	return ConfigETag(data), nil
+}
+
+func (this *ConfigStore) History(hash string) (*ConfigHistory, error) {
+	err := ValidateConfigHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	history := &ConfigHistory{
+		Versions: make([]*ConfigVersion, 0),
+	}
+
+	reader, err := this.storage.Get(this.historyKey(hash))
+	if err == ErrObjectNotFound {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	err = decoder.Decode(history)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (this *ConfigStore) Versions(hash string) ([]*ConfigVersion, error) {
+	history, err := this.History(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return history.Versions, nil
+}
+
+func (this *ConfigStore) Save(hash string, conf interface{}) (string, *ConfigVersion, error) {
+	return this.SaveIfMatch(hash, conf, "")
+}
+
+// SaveIfMatch only writes when the current config still matches ifMatch, an
+// empty ifMatch saves unconditionally.
+func (this *ConfigStore) SaveIfMatch(hash string, conf interface{}, ifMatch string) (string, *ConfigVersion, error) {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	err := encoder.Encode(conf)
+	if err != nil {
+		log.Error(err)
+		return "", nil, err
+	}
+
+	return this.SaveRaw(hash, buf.Bytes(), 0, ifMatch)
+}
+
+func (this *ConfigStore) SaveRaw(hash string, data []byte, rollbackOf int, ifMatch string) (string, *ConfigVersion, error) {
+	err := ValidateConfigHash(hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	configStoreLock <- true
+	defer func() {
+		<-configStoreLock
+	}()
+
+	current, err := this.LoadRaw(hash, 0)
+	if err != nil && err != ErrObjectNotFound {
+		return "", nil, err
+	}
+	exists := err == nil
+	if len(ifMatch) > 0 && (!exists || !MatchETag(ifMatch, ConfigETag(current))) {
+		return "", nil, ErrPreconditionFailed
+	}
+
+	history, err := this.History(hash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// configs saved before versioning existed keep their content as version 1
+	if len(history.Versions) <= 0 {
+		if exists {
+			version, err := this.putVersion(hash, current, 1, 0)
+			if err != nil {
+				return "", nil, err
+			}
+			history.Versions = append(history.Versions, version)
+		}
+	}
+
+	version, err := this.putVersion(hash, data, history.Latest()+1, rollbackOf)
+	if err != nil {
+		return "", nil, err
+	}
+	history.Versions = append(history.Versions, version)
+
+	_, url, err := this.storage.PutContent(string(data), this.currentKey(hash), &UploadOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		log.Error(err)
+		return "", nil, err
+	}
+
+	index, err := json.Marshal(history)
+	if err != nil {
+		log.Error(err)
+		return "", nil, err
+	}
+	_, _, err = this.storage.PutContent(string(index), this.historyKey(hash), &UploadOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		log.Error(err)
+		return "", nil, err
+	}
+
+	return url, version, nil
+}
+
+func (this *ConfigStore) putVersion(hash string, data []byte, n int, rollbackOf int) (*ConfigVersion, error) {
+	_, url, err := this.storage.PutContent(string(data), this.versionKey(hash, n), &UploadOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return &ConfigVersion{
+		Version:    n,
+		Created:    time.Now().UTC(),
+		URL:        url,
+		RollbackOf: rollbackOf,
+		ETag:       ConfigETag(data),
+	}, nil
+}
+
+// Rollback saves the content of an earlier version as a new version, the
+// history itself is never rewritten.
+func (this *ConfigStore) Rollback(hash string, version int, ifMatch string) (string, *ConfigVersion, error) {
+	if version <= 0 {
+		return "", nil, fmt.Errorf("invalid version %d", version)
+	}
+
+	data, err := this.LoadRaw(hash, version)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return this.SaveRaw(hash, data, version, ifMatch)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStorage is an in-memory IStorage used by the unit tests.
+type memoryStorage struct {
+	lock    sync.Mutex
+	objects map[string]string
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		objects: make(map[string]string),
+	}
+}
+
+func (this *memoryStorage) Upload(localPath string, key string) (string, string, error) {
+	data, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return this.PutContent(string(data), key, nil)
+}
+
+func (this *memoryStorage) PutContent(content string, key string, opt *UploadOptions) (string, string, error) {
+	this.lock.Lock()
+	this.objects[key] = content
+	this.lock.Unlock()
+
+	return key, this.URL(key), nil
+}
+
+func (this *memoryStorage) Get(key string) (io.Reader, error) {
+	this.lock.Lock()
+	content, ok := this.objects[key]
+	this.lock.Unlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	return bytes.NewBufferString(content), nil
+}
+
+func (this *memoryStorage) Head(key string) error {
+	_, err := this.Get(key)
+
+	return err
+}
+
+func (this *memoryStorage) URL(key string) string {
+	return "https://cdn.example.com/" + key
+}
+
+func TestConfigStore_SaveAndRollback(t *testing.T) {
+	storage := newMemoryStorage()
+	store := NewConfigStore(storage)
+
+	// legacy config written before versioning
+	storage.objects["abc.json"] = `{"v":"legacy"}`
+
+	url, version, err := store.Save("abc", map[string]string{"v": "second"})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if url != storage.URL("abc.json") || version.Version != 2 {
+		t.Errorf("unexpected save result %s %d", url, version.Version)
+		t.Fail()
+		return
+	}
+
+	versions, err := store.Versions("abc")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if len(versions) != 2 || versions[0].Version != 1 {
+		t.Errorf("expected legacy snapshot as version 1, got %d versions", len(versions))
+		t.Fail()
+		return
+	}
+
+	_, version, err = store.Rollback("abc", 1, "")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if version.Version != 3 || version.RollbackOf != 1 {
+		t.Errorf("unexpected rollback version %d of %d", version.Version, version.RollbackOf)
+		t.Fail()
+		return
+	}
+
+	conf := make(map[string]string)
+	_, err = store.Load("abc", 0, &conf)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if conf["v"] != "legacy" {
+		t.Errorf("expected rolled back content, got %q", conf["v"])
+		t.Fail()
+		return
+	}
+
+	_, err = store.Load("abc", 2, &conf)
+	if err != nil || conf["v"] != "second" {
+		t.Errorf("version 2 should stay immutable, got %q %v", conf["v"], err)
+		t.Fail()
+		return
+	}
+
+	_, err = store.LoadRaw("abc", 9)
+	if err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestConfigStore_SaveIfMatch(t *testing.T) {
+	store := NewConfigStore(newMemoryStorage())
+
+	_, first, err := store.Save("abc", map[string]string{"v": "1"})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	_, second, err := store.SaveIfMatch("abc", map[string]string{"v": "2"}, first.ETag)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	// a second editor still holding the first etag must not clobber the save
+	_, _, err = store.SaveIfMatch("abc", map[string]string{"v": "3"}, first.ETag)
+	if err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+		t.Fail()
+		return
+	}
+
+	conf := make(map[string]string)
+	etag, err := store.Load("abc", 0, &conf)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if conf["v"] != "2" || etag != second.ETag {
+		t.Errorf("unexpected current config %q %s", conf["v"], etag)
+		t.Fail()
+		return
+	}
+
+	if !MatchETag("W/"+etag+", \"other\"", etag) || MatchETag(first.ETag, etag) {
+		t.Error("unexpected MatchETag result")
+		t.Fail()
+		return
+	}
+
+	_, _, err = store.SaveIfMatch("missing", map[string]string{"v": "1"}, etag)
+	if err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed for missing config, got %v", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestRepresentationETag(t *testing.T) {
+	store := NewConfigStore(newMemoryStorage())
+	_, saved, err := store.Save("abc", &PannellumConfig{
+		Type:          PANNELLUM_TYPE_EQUIRECTANGULAR,
+		URL:           "https://example.com/pano.jpg",
+		HotSpot:       []*PannellumHotSpot{&PannellumHotSpot{Id: "a", Type: TYPE_PANNELLUM_HOTSPOT_TEXT, Text: LocalizedText{"en": "Hi", "fr": "Salut"}}},
+		DefaultLocale: "en",
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	etags := map[string]bool{saved.ETag: true}
+	for _, lang := range []string{"en", "fr"} {
+		conf := new(PannellumConfig)
+		_, err = store.Load("abc", 0, conf)
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+			return
+		}
+		conf.Localize(lang)
+		for _, body := range []interface{}{conf, NewVR360Config(conf)} {
+			etag, err := representationETag(body)
+			if err != nil || etags[etag] {
+				t.Errorf("%s: etag %s is not unique, %v", lang, etag, err)
+			}
+			etags[etag] = true
+		}
+	}
+}
+
+func TestConfigStore_InvalidHash(t *testing.T) {
+	store := NewConfigStore(newMemoryStorage())
+	for _, hash := range []string{"", "../beta/abc", "abc/def", "abc.json"} {
+		if _, _, err := store.Save(hash, map[string]string{}); !errors.Is(err, ErrInvalidConfigHash) {
+			t.Errorf("save %q: expected ErrInvalidConfigHash, got %v", hash, err)
+		}
+		if _, err := store.LoadRaw(hash, 0); !errors.Is(err, ErrInvalidConfigHash) {
+			t.Errorf("load %q: expected ErrInvalidConfigHash, got %v", hash, err)
+		}
+		if _, err := store.Versions(hash); !errors.Is(err, ErrInvalidConfigHash) {
+			t.Errorf("versions %q: expected ErrInvalidConfigHash, got %v", hash, err)
+		}
+	}
+}
+
+func TestHTTPService_InvalidConfigHash(t *testing.T) {
+	handler := NewHTTP(&Config{S3: &S3Config{}}).getHTTPHandler()
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodPost, "/config?hash=../beta/abc", `{}`},
+		{http.MethodGet, "/config/abc.json", ""},
+		{http.MethodGet, "/config/abc.json/versions", ""},
+		{http.MethodPost, "/config/abc.json/rollback?version=1", ""},
+		{http.MethodPatch, "/config/abc.json", `[]`},
+		{http.MethodGet, "/config/abc.json/hotspots", ""},
+		{http.MethodGet, "/config/abc.json/export", ""},
+		{http.MethodGet, "/vr360/config/abc.json", ""},
+		{http.MethodGet, "/vr360/config/abc.json/versions", ""},
+		{http.MethodGet, "/vr360/config/abc.json/hotspots", ""},
+		{http.MethodGet, "/vr360/tour/abc.json", ""},
+		{http.MethodPost, "/vr360/tour/abc.json", `{}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		if writer.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, got %d %s", c.method, c.target, writer.Code, writer.Body.String())
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	COORDINATE_UNIT_NORMALIZED = "normalized"
+	COORDINATE_UNIT_PIXEL      = "px"
+)
+
+var (
+	coordinateUnits         = []string{COORDINATE_UNIT_NORMALIZED, COORDINATE_UNIT_PIXEL}
+	legacyCoordinatePattern = regexp.MustCompile(`^\s*(-?[0-9]+(?:\.[0-9]+)?)\s*(%|px)?\s*$`)
+)
+
+// parseLegacyCoordinate reads the string coordinates stored before units
+// were explicit: "10%" is normalized, plain numbers and "px" are pixels.
+func parseLegacyCoordinate(value string) (float64, string, error) {
+	matches := legacyCoordinatePattern.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, "", fmt.Errorf("invalid coordinate %q", value)
+	}
+
+	n, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, "", err
+	}
+	if matches[2] == "%" {
+		return n / 100, COORDINATE_UNIT_NORMALIZED, nil
+	}
+
+	return n, COORDINATE_UNIT_PIXEL, nil
+}
+
+// formatLegacyCoordinate is the inverse of parseLegacyCoordinate.
+func formatLegacyCoordinate(value float64, unit string) string {
+	if unit == COORDINATE_UNIT_NORMALIZED {
+		return strconv.FormatFloat(value*100, 'f', -1, 64) + "%"
+	}
+
+	return strconv.FormatFloat(value, 'f', -1, 64) + "px"
+}
+
+// UnmarshalJSON accepts numeric coordinates as well as the legacy string
+// values, empty legacy values are unset and a legacy pair mixing % and
+// pixels keeps the unit of each axis until MigrateConfig normalizes it.
+func (this *HotSpotCoordinates) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		PageIndex int             `json:"index"`
+		X         json.RawMessage `json:"x"`
+		Y         json.RawMessage `json:"y"`
+		Unit      string          `json:"unit"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	this.PageIndex = raw.PageIndex
+	this.X, this.Y = 0, 0
+	this.Unit = raw.Unit
+	this.axisUnits = [2]string{}
+	for i, axis := range []struct {
+		raw   json.RawMessage
+		value *float64
+	}{{raw.X, &this.X}, {raw.Y, &this.Y}} {
+		if len(axis.raw) <= 0 || string(axis.raw) == "null" {
+			continue
+		}
+
+		var legacy string
+		if json.Unmarshal(axis.raw, &legacy) == nil {
+			if len(strings.TrimSpace(legacy)) <= 0 {
+				continue
+			}
+			value, unit, err := parseLegacyCoordinate(legacy)
+			if err != nil {
+				return err
+			}
+			*axis.value = value
+			this.axisUnits[i] = unit
+			continue
+		}
+
+		err = json.Unmarshal(axis.raw, axis.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(this.Unit) <= 0 {
+		for _, unit := range this.axisUnits {
+			if len(unit) > 0 {
+				this.Unit = unit
+				break
+			}
+		}
+	}
+	if len(this.Unit) <= 0 {
+		this.Unit = COORDINATE_UNIT_NORMALIZED
+	}
+
+	return nil
+}
+
+// MarshalJSON writes a mixed legacy pair back as strings so it survives a
+// round trip until it is migrated.
+func (this HotSpotCoordinates) MarshalJSON() ([]byte, error) {
+	type coordinates HotSpotCoordinates
+	if !this.IsMixed() {
+		return json.Marshal(coordinates(this))
+	}
+
+	return json.Marshal(struct {
+		PageIndex int    `json:"index"`
+		X         string `json:"x"`
+		Y         string `json:"y"`
+	}{
+		PageIndex: this.PageIndex,
+		X:         formatLegacyCoordinate(this.X, this.axisUnit(0)),
+		Y:         formatLegacyCoordinate(this.Y, this.axisUnit(1)),
+	})
+}
+
+func (this *HotSpotCoordinates) unit() string {
+	if len(this.Unit) <= 0 {
+		return COORDINATE_UNIT_NORMALIZED
+	}
+
+	return this.Unit
+}
+
+// axisUnit is the unit of the x (0) or y (1) value.
+func (this *HotSpotCoordinates) axisUnit(axis int) string {
+	if len(this.axisUnits[axis]) > 0 {
+		return this.axisUnits[axis]
+	}
+
+	return this.unit()
+}
+
+// IsMixed reports a legacy pair whose axes use different units.
+func (this *HotSpotCoordinates) IsMixed() bool {
+	return this.axisUnit(0) != this.axisUnit(1)
+}
+
+func (this *HotSpotCoordinates) IsPixel() bool {
+	return this.axisUnit(0) == COORDINATE_UNIT_PIXEL || this.axisUnit(1) == COORDINATE_UNIT_PIXEL
+}
+
+// Normalize converts the pixel values of a coordinate to normalized units
+// of an image of the given size.
+func (this *HotSpotCoordinates) Normalize(width int, height int) error {
+	if !this.IsPixel() {
+		this.Unit = COORDINATE_UNIT_NORMALIZED
+		this.axisUnits = [2]string{}
+		return nil
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	if this.axisUnit(0) == COORDINATE_UNIT_PIXEL {
+		this.X /= float64(width)
+	}
+	if this.axisUnit(1) == COORDINATE_UNIT_PIXEL {
+		this.Y /= float64(height)
+	}
+	this.Unit = COORDINATE_UNIT_NORMALIZED
+	this.axisUnits = [2]string{}
+
+	return nil
+}
+
+// imageSize reads the dimensions of a remote image without decoding it.
+func (this *Worker) imageSize(URL string) (int, int, error) {
+	reader, err := this.DownloadRemoteFile(URL, "image/")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer reader.Close()
+
+	conf, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		log.Error(err)
+		return 0, 0, err
+	}
+
+	return conf.Width, conf.Height, nil
+}
+
+// MigrateConfig rewrites a stored spin360 config with numeric normalized
+// coordinates, pixel values are converted with the size of their page.
+func (this *Worker) MigrateConfig(hash string, ifMatch string) (*Spin360Config, string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sizes := make(map[int][2]int)
+	conf := new(Spin360Config)
+	etag, err := this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		for i, hotSpot := range conf.HotSpot {
+			if hotSpot == nil {
+				continue
+			}
+			for j, coordinate := range hotSpot.Coordinates {
+				if coordinate == nil || !coordinate.IsPixel() {
+					if coordinate != nil {
+						coordinate.Unit = COORDINATE_UNIT_NORMALIZED
+					}
+					continue
+				}
+				field := fmt.Sprintf("hotspot[%d].coordinate[%d]", i, j)
+				if coordinate.PageIndex < 0 || coordinate.PageIndex >= len(conf.Pages) {
+					return ValidationErrors{&ValidationError{Field: field + ".index", Message: "page not found"}}
+				}
+
+				size, ok := sizes[coordinate.PageIndex]
+				if !ok {
+					width, height, err := this.imageSize(conf.Pages[coordinate.PageIndex].ImageURL)
+					if err != nil {
+						return err
+					}
+					size = [2]int{width, height}
+					sizes[coordinate.PageIndex] = size
+				}
+
+				err := coordinate.Normalize(size[0], size[1])
+				if err != nil {
+					return ValidationErrors{&ValidationError{Field: field, Message: err.Error()}}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf, etag, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHotSpotCoordinates_UnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		data string
+		x    float64
+		y    float64
+		unit string
+	}{
+		{`{"index":1,"x":"10%","y":"25.5%"}`, 0.1, 0.255, COORDINATE_UNIT_NORMALIZED},
+		{`{"index":1,"x":"120","y":"80px"}`, 120, 80, COORDINATE_UNIT_PIXEL},
+		{`{"index":1,"x":0.3,"y":0.4}`, 0.3, 0.4, COORDINATE_UNIT_NORMALIZED},
+		{`{"index":1,"x":300,"y":40,"unit":"px"}`, 300, 40, COORDINATE_UNIT_PIXEL},
+		// unset legacy values
+		{`{"index":1,"x":"","y":""}`, 0, 0, COORDINATE_UNIT_NORMALIZED},
+		{`{"index":1,"x":null,"y":null}`, 0, 0, COORDINATE_UNIT_NORMALIZED},
+		{`{"index":1,"x":"","y":"40px"}`, 0, 40, COORDINATE_UNIT_PIXEL},
+	}
+	for i, c := range cases {
+		coordinate := new(HotSpotCoordinates)
+		err := json.Unmarshal([]byte(c.data), coordinate)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		if coordinate.PageIndex != 1 || coordinate.X != c.x || coordinate.Y != c.y || coordinate.Unit != c.unit {
+			t.Errorf("case %d: unexpected %+v", i, coordinate)
+		}
+	}
+
+	err := json.Unmarshal([]byte(`{"x":"abc","y":1}`), new(HotSpotCoordinates))
+	if err == nil {
+		t.Error("expected error for an invalid legacy value")
+	}
+}
+
+func TestHotSpotCoordinates_Mixed(t *testing.T) {
+	conf := new(Spin360Config)
+	err := json.Unmarshal([]byte(`{"page":[{"img":"https://example.com/0.jpg"}],"hotspot":[
+		{"type":"text","text":"a","coordinate":[{"x":"","y":""}]},
+		{"type":"text","text":"b","coordinate":[{"x":"50%","y":"120"}]}
+	]}`), conf)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	coordinate := conf.HotSpot[1].Coordinates[0]
+	if !coordinate.IsMixed() || coordinate.X != 0.5 || coordinate.Y != 120 {
+		t.Errorf("unexpected %+v", coordinate)
+	}
+	errs := conf.Validate()
+	if len(errs) != 1 || errs[0].Field != "hotspot[1].coordinate[0]" {
+		t.Errorf("expected the mixed coordinate to fail validation, got %v", errs)
+	}
+
+	// a mixed pair survives a round trip, e.g. through a JSON Patch
+	data, err := json.Marshal(coordinate)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	decoded := new(HotSpotCoordinates)
+	err = json.Unmarshal(data, decoded)
+	if err != nil || !decoded.IsMixed() || decoded.X != 0.5 || decoded.Y != 120 {
+		t.Errorf("unexpected round trip %s: %+v %v", data, decoded, err)
+	}
+
+	err = coordinate.Normalize(1000, 400)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if coordinate.IsMixed() || coordinate.X != 0.5 || coordinate.Y != 0.3 || coordinate.Unit != COORDINATE_UNIT_NORMALIZED {
+		t.Errorf("unexpected normalized %+v", coordinate)
+	}
+}
+
+func TestHotSpotCoordinates_Normalize(t *testing.T) {
+	coordinate := &HotSpotCoordinates{X: 480, Y: 270, Unit: COORDINATE_UNIT_PIXEL}
+	err := coordinate.Normalize(1920, 1080)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if coordinate.X != 0.25 || coordinate.Y != 0.25 || coordinate.Unit != COORDINATE_UNIT_NORMALIZED {
+		t.Errorf("unexpected %+v", coordinate)
+	}
+}
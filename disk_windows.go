@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// diskFree is not implemented on windows, the free space check is skipped.
+func diskFree(path string) (int64, error) {
+	return -1, errors.New("free space is not available on windows")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// default limits of remote downloads
+const DOWNLOAD_MAX_SIZE = 4 << 30
+
+const DOWNLOAD_TIMEOUT = 30 * time.Minute
+
+const DOWNLOAD_MAX_REDIRECTS = 10
+
+// bytes read to sniff the content type
+const DOWNLOAD_SNIFF_SIZE = 512
+
+var ErrDownloadForbidden = errors.New("download address is not allowed")
+
+var ErrDownloadTooLarge = errors.New("download exceeds the maximum size")
+
+var ErrDownloadContentType = errors.New("download has an unexpected content type")
+
+// loopback, private, link-local (cloud metadata), shared, multicast and
+// reserved ranges, refused unless allowed explicitly
+var privateCIDRs = parseCIDRs([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+})
+
+type DownloadConfig struct {
+	// only these hosts may be fetched when set, "*.example.com" matches subdomains
+	AllowHosts []string `json:"allow_hosts"`
+	DenyHosts  []string `json:"deny_hosts"`
+	// addresses allowed even if private, e.g. an internal media server
+	AllowCIDRs []string `json:"allow_cidrs"`
+	DenyCIDRs  []string `json:"deny_cidrs"`
+	// maximum size in bytes
+	MaxSize int64 `json:"max_size"`
+	// timeout in seconds
+	Timeout int `json:"timeout"`
+}
+
+func parseCIDRs(list []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(item))
+		if err != nil {
+			log.Errorf("invalid CIDR %s: %s", item, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DownloadPolicy decides which URLs and addresses downloads may reach.
+type DownloadPolicy struct {
+	conf       *DownloadConfig
+	allowCIDRs []*net.IPNet
+	denyCIDRs  []*net.IPNet
+}
+
+func NewDownloadPolicy(conf *DownloadConfig) *DownloadPolicy {
+	if conf == nil {
+		conf = &DownloadConfig{}
+	}
+
+	return &DownloadPolicy{
+		conf:       conf,
+		allowCIDRs: parseCIDRs(conf.AllowCIDRs),
+		denyCIDRs:  parseCIDRs(conf.DenyCIDRs),
+	}
+}
+
+func (this *DownloadPolicy) MaxSize() int64 {
+	if this.conf.MaxSize > 0 {
+		return this.conf.MaxSize
+	}
+
+	return DOWNLOAD_MAX_SIZE
+}
+
+func (this *DownloadPolicy) Timeout() time.Duration {
+	if this.conf.Timeout > 0 {
+		return time.Duration(this.conf.Timeout) * time.Second
+	}
+
+	return DOWNLOAD_TIMEOUT
+}
+
+func (this *DownloadPolicy) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrDownloadForbidden, u.Scheme)
+	}
+	host := u.Hostname()
+	if len(host) <= 0 {
+		return fmt.Errorf("%w: missing host", ErrDownloadForbidden)
+	}
+	if matchHost(this.conf.DenyHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", ErrDownloadForbidden, host)
+	}
+	if len(this.conf.AllowHosts) > 0 && !matchHost(this.conf.AllowHosts, host) {
+		return fmt.Errorf("%w: host %s is not allowed", ErrDownloadForbidden, host)
+	}
+
+	return nil
+}
+
+// CheckIP is applied to the resolved address of every connection, so a
+// host can not point its DNS at an internal address.
+func (this *DownloadPolicy) CheckIP(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if containsIP(this.denyCIDRs, ip) {
+		return fmt.Errorf("%w: address %s is denied", ErrDownloadForbidden, ip)
+	}
+	if containsIP(this.allowCIDRs, ip) {
+		return nil
+	}
+	if containsIP(privateCIDRs, ip) {
+		return fmt.Errorf("%w: address %s is private", ErrDownloadForbidden, ip)
+	}
+
+	return nil
+}
+
+func (this *DownloadPolicy) control(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: address %s", ErrDownloadForbidden, address)
+	}
+
+	return this.CheckIP(ip)
+}
+
+// Client is an http.Client enforcing the policy on every redirect and
+// connection, environment proxies are ignored as they would bypass it.
+func (this *DownloadPolicy) Client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   this.control,
+	}
+
+	return &http.Client{
+		Timeout: this.Timeout(),
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= DOWNLOAD_MAX_REDIRECTS {
+				return errors.New("too many redirects")
+			}
+			return this.CheckURL(request.URL)
+		},
+	}
+}
+
+// limitedBody fails instead of truncating once more than max bytes are read.
+type limitedBody struct {
+	reader io.Reader
+	closer io.Closer
+	remain int64
+}
+
+func (this *limitedBody) Read(p []byte) (int, error) {
+	if this.remain < 0 {
+		return 0, ErrDownloadTooLarge
+	}
+	if int64(len(p)) > this.remain+1 {
+		p = p[:this.remain+1]
+	}
+	n, err := this.reader.Read(p)
+	this.remain -= int64(n)
+	if this.remain < 0 {
+		return n, ErrDownloadTooLarge
+	}
+
+	return n, err
+}
+
+func (this *limitedBody) Close() error {
+	return this.closer.Close()
+}
+
+func matchContentType(contentType string, accepted []string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, prefix := range accepted {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkContentType accepts the sniffed type, or the declared one when the
+// body can not be sniffed (e.g. QuickTime), but never a sniffed text page.
+func checkContentType(head []byte, declared string, accepted []string) error {
+	if len(accepted) <= 0 {
+		return nil
+	}
+
+	sniffed := http.DetectContentType(head)
+	if matchContentType(sniffed, accepted) {
+		return nil
+	}
+	if strings.HasPrefix(sniffed, "application/octet-stream") && matchContentType(declared, accepted) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrDownloadContentType, sniffed)
+}
+
+// Download fetches URL within the policy, the body is checked against the
+// accepted content type prefixes (e.g. "video/") when any are given.
+func (this *DownloadPolicy) Download(ctx context.Context, URL string, contentTypes ...string) (io.ReadCloser, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+	err = this.CheckURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := this.Client().Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download %s: unexpected status %s", URL, resp.Status)
+	}
+	if resp.ContentLength > this.MaxSize() {
+		resp.Body.Close()
+		return nil, ErrDownloadTooLarge
+	}
+
+	body := &limitedBody{reader: resp.Body, closer: resp.Body, remain: this.MaxSize()}
+	head := make([]byte, DOWNLOAD_SNIFF_SIZE)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		body.Close()
+		return nil, err
+	}
+	head = head[:n]
+
+	err = checkContentType(head, resp.Header.Get("Content-Type"), contentTypes)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return &limitedBody{
+		reader: io.MultiReader(bytes.NewReader(head), body),
+		closer: body,
+		remain: this.MaxSize(),
+	}, nil
+}
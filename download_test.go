@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDownloadPolicy_Check(t *testing.T) {
+	policy := NewDownloadPolicy(&DownloadConfig{
+		AllowHosts: []string{"cdn.example.com", "*.media.example.com"},
+		DenyHosts:  []string{"private.media.example.com"},
+		AllowCIDRs: []string{"10.1.0.0/16"},
+	})
+
+	urls := map[string]bool{
+		"https://cdn.example.com/a.mp4":           true,
+		"https://eu.media.example.com/a.mp4":      true,
+		"https://private.media.example.com/a.mp4": false,
+		"https://example.org/a.mp4":               false,
+		"file:///etc/passwd":                      false,
+		"gopher://cdn.example.com/":               false,
+	}
+	for URL, allowed := range urls {
+		u, _ := url.Parse(URL)
+		if err := policy.CheckURL(u); (err == nil) != allowed {
+			t.Errorf("%s: unexpected result %v", URL, err)
+		}
+	}
+
+	ips := map[string]bool{
+		"93.184.216.34":    true,
+		"127.0.0.1":        false,
+		"169.254.169.254":  false,
+		"192.168.1.1":      false,
+		"10.2.0.1":         false,
+		"10.1.0.1":         true,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"fd00::1":          false,
+	}
+	for ip, allowed := range ips {
+		if err := policy.CheckIP(net.ParseIP(ip)); (err == nil) != allowed {
+			t.Errorf("%s: unexpected result %v", ip, err)
+		}
+	}
+}
+
+func TestDownloadPolicy_Download(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/video.mp4":
+			writer.Header().Set("Content-Type", "video/mp4")
+			writer.Write([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"))
+		case "/error":
+			writer.Header().Set("Content-Type", "video/mp4")
+			writer.WriteHeader(http.StatusNotFound)
+			writer.Write([]byte("<html>not found</html>"))
+		case "/page":
+			writer.Header().Set("Content-Type", "video/mp4")
+			writer.Write([]byte("<html><body>login</body></html>"))
+		case "/large":
+			writer.Write([]byte(strings.Repeat("a", 2048)))
+		case "/redirect":
+			http.Redirect(writer, request, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if _, err := NewDownloadPolicy(nil).Download(ctx, server.URL+"/video.mp4"); !errors.Is(err, ErrDownloadForbidden) {
+		t.Errorf("loopback should be refused by default, got %v", err)
+	}
+
+	policy := NewDownloadPolicy(&DownloadConfig{AllowCIDRs: []string{"127.0.0.0/8", "::1/128"}, MaxSize: 1024})
+	reader, err := policy.Download(ctx, server.URL+"/video.mp4", "video/")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !strings.Contains(string(data), "ftypmp42") {
+		t.Errorf("unexpected body %q %v", data, err)
+	}
+
+	if _, err := policy.Download(ctx, server.URL+"/error", "video/"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected status error, got %v", err)
+	}
+	if _, err := policy.Download(ctx, server.URL+"/page", "video/"); !errors.Is(err, ErrDownloadContentType) {
+		t.Errorf("expected content type error, got %v", err)
+	}
+	if _, err := policy.Download(ctx, server.URL+"/redirect"); !errors.Is(err, ErrDownloadForbidden) {
+		t.Errorf("expected redirect to be refused, got %v", err)
+	}
+
+	reader, err = policy.Download(ctx, server.URL+"/large")
+	if err == nil {
+		_, err = ioutil.ReadAll(reader)
+		reader.Close()
+	}
+	if err != ErrDownloadTooLarge {
+		t.Errorf("expected size error, got %v", err)
+	}
+}
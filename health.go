@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HEALTHZ_PATH = "/healthz"
+
+const READYZ_PATH = "/readyz"
+
+const HEALTH_STATUS_OK = "ok"
+
+const HEALTH_STATUS_FAIL = "fail"
+
+// checks still running after this are reported as failed
+const HEALTH_TIMEOUT = 10 * time.Second
+
+const HEALTH_MIN_FREE_SPACE = 1 << 30
+
+const HEALTH_SENTINEL_KEY = "/healthz"
+
+// readiness results are reused this long, orchestrators probe every few
+// seconds and each run starts ffmpeg, ffprobe and nona
+const HEALTH_CACHE_TTL = 5 * time.Second
+
+// nona prints its usage and version on -h and exits with 1
+const NONA_USAGE_EXIT_CODE = 1
+
+// matches "ffmpeg version 4.2.2-..." as well as "Version: 2019.0.0"
+var versionPattern = regexp.MustCompile(`(?i)version:?\s+v?(\d+(?:\.\d+)*)`)
+
+type HealthConfig struct {
+	// minimum versions, e.g. "4.2", only checked when set
+	FFmpegVersion  string `json:"ffmpeg_version"`
+	FFProbeVersion string `json:"ffprobe_version"`
+	NonaVersion    string `json:"nona_version"`
+	// bytes that must be free in the temp dir, default 1GB
+	MinFreeSpace int64 `json:"min_free_space"`
+	// key of the storage HEAD request, a missing key still proves the
+	// storage answers with valid credentials
+	SentinelKey string `json:"sentinel_key"`
+}
+
+// swagger:model HealthCheck
+type HealthCheck struct {
+	// 检查项
+	Name string `json:"name"`
+	// 是否通过
+	OK bool `json:"ok"`
+	// 检测到的版本、剩余空间等
+	Detail string `json:"detail,omitempty"`
+	// 失败原因
+	Error string `json:"error,omitempty"`
+}
+
+// swagger:model HealthStatus
+type HealthStatus struct {
+	// ok 或 fail
+	Status string `json:"status"`
+	// 各检查项结果
+	Checks []*HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCache keeps the last readiness status of each tenant.
+type HealthCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]*healthCacheEntry
+}
+
+type healthCacheEntry struct {
+	status  *HealthStatus
+	checked time.Time
+}
+
+func NewHealthCache(ttl time.Duration) *HealthCache {
+	return &HealthCache{
+		ttl:     ttl,
+		entries: make(map[string]*healthCacheEntry),
+	}
+}
+
+// Get returns the cached status of key or runs probe when it expired,
+// concurrent callers wait for the running probe instead of starting more.
+func (this *HealthCache) Get(key string, now time.Time, probe func() *HealthStatus) *HealthStatus {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	entry, ok := this.entries[key]
+	if ok && now.Sub(entry.checked) < this.ttl {
+		return entry.status
+	}
+
+	entry = &healthCacheEntry{status: probe(), checked: now}
+	this.entries[key] = entry
+
+	return entry.status
+}
+
+type healthProbe struct {
+	name string
+	run  func(check *HealthCheck) error
+}
+
+func (this *HealthConfig) minFreeSpace() int64 {
+	if this.MinFreeSpace > 0 {
+		return this.MinFreeSpace
+	}
+
+	return HEALTH_MIN_FREE_SPACE
+}
+
+func (this *HealthConfig) sentinelKey() string {
+	if len(this.SentinelKey) > 0 {
+		return this.SentinelKey
+	}
+
+	return HEALTH_SENTINEL_KEY
+}
+
+func isHealthPath(path string) bool {
+	return path == HEALTHZ_PATH || path == READYZ_PATH
+}
+
+func parseVersion(output string) string {
+	match := versionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// compareVersions compares dotted numeric versions, missing parts are 0.
+func compareVersions(a string, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		numA, numB := 0, 0
+		if i < len(partsA) {
+			numA, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			numB, _ = strconv.Atoi(partsB[i])
+		}
+		if numA != numB {
+			if numA < numB {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// checkBinary runs bin with args and checks the version it prints, a
+// binary that prints no version is taken as broken.
+func checkBinary(bin string, minVersion string, args []string, accepted ...int) func(check *HealthCheck) error {
+	return func(check *HealthCheck) error {
+		if len(bin) <= 0 {
+			return fmt.Errorf("binary is not configured")
+		}
+		output, err := NewBuilder(bin).SetParams(args...).Probe(accepted...)
+		if err != nil {
+			return err
+		}
+
+		version := parseVersion(output)
+		if len(version) <= 0 {
+			return fmt.Errorf("version not found in %s output", bin)
+		}
+		check.Detail = version
+		if len(minVersion) > 0 && compareVersions(version, minVersion) < 0 {
+			return fmt.Errorf("version %s is older than %s", version, minVersion)
+		}
+
+		return nil
+	}
+}
+
+func checkTempDir(path string, minFree int64) func(check *HealthCheck) error {
+	return func(check *HealthCheck) error {
+		err := os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		file, err := ioutil.TempFile(path, ".healthz-")
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString("ok")
+		file.Close()
+		os.Remove(file.Name())
+		if err != nil {
+			return err
+		}
+
+		free, err := diskFree(path)
+		if free < 0 {
+			check.Detail = err.Error()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		check.Detail = fmt.Sprintf("%d bytes free", free)
+		if free < minFree {
+			return fmt.Errorf("%d bytes free, %d required", free, minFree)
+		}
+
+		return nil
+	}
+}
+
+func checkStorage(storage IStorage, key string) func(check *HealthCheck) error {
+	return func(check *HealthCheck) error {
+		err := storage.Head(key)
+		if err == ErrObjectNotFound {
+			check.Detail = "sentinel key not found"
+			return nil
+		}
+
+		return err
+	}
+}
+
+// readinessProbes lists the dependencies the worker needs to take jobs.
+func (this *Worker) readinessProbes() []*healthProbe {
+	conf := this.Conf.Health
+	if conf == nil {
+		conf = &HealthConfig{}
+	}
+	ffmpegConf := this.Conf.FFMpegConf
+	if ffmpegConf == nil {
+		ffmpegConf = &FFMPEGConfig{}
+	}
+
+	probes := []*healthProbe{
+		&healthProbe{"ffmpeg", checkBinary(ffmpegConf.FFmpeg, conf.FFmpegVersion, []string{"-version"})},
+		&healthProbe{"ffprobe", checkBinary(ffmpegConf.FFProbe, conf.FFProbeVersion, []string{"-version"})},
+		&healthProbe{"nona", checkBinary(NewNonaWrapper(``).Bin, conf.NonaVersion, []string{"-h"}, NONA_USAGE_EXIT_CODE)},
+		&healthProbe{"temp", checkTempDir(this.Conf.TempPath, conf.minFreeSpace())},
+	}
+
+	backend, err := this.storageBackend("")
+	if err != nil {
+		return append(probes, &healthProbe{"storage", func(check *HealthCheck) error {
+			return err
+		}})
+	}
+	probes = append(probes, &healthProbe{"storage:" + backend, func(check *HealthCheck) error {
+		storage, err := this.SourceStorage(backend)
+		if err != nil {
+			return err
+		}
+		return checkStorage(storage, conf.sentinelKey())(check)
+	}})
+
+	return probes
+}
+
+func runHealthProbes(probes []*healthProbe, timeout time.Duration) *HealthStatus {
+	checks := make([]*HealthCheck, len(probes))
+	lock := sync.Mutex{}
+	wait := sync.WaitGroup{}
+	for i, probe := range probes {
+		wait.Add(1)
+		go func(i int, probe *healthProbe) {
+			defer wait.Done()
+			check := &HealthCheck{Name: probe.name}
+			err := probe.run(check)
+			check.OK = err == nil
+			if err != nil {
+				check.Error = err.Error()
+			}
+			lock.Lock()
+			checks[i] = check
+			lock.Unlock()
+		}(i, probe)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+
+	status := &HealthStatus{Status: HEALTH_STATUS_OK, Checks: make([]*HealthCheck, len(probes))}
+	lock.Lock()
+	defer lock.Unlock()
+	for i, check := range checks {
+		if check == nil {
+			check = &HealthCheck{Name: probes[i].name, Error: "timed out"}
+		}
+		if !check.OK {
+			status.Status = HEALTH_STATUS_FAIL
+		}
+		status.Checks[i] = check
+	}
+
+	return status
+}
+
+// Readiness checks that ffmpeg, ffprobe and nona run, the temp dir is
+// writable with enough space and the storage answers.
+func (this *Worker) Readiness() *HealthStatus {
+	return runHealthProbes(this.readinessProbes(), HEALTH_TIMEOUT)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		output  string
+		version string
+	}{
+		{"ffmpeg version 4.2.2-static https://johnvansickle.com/ffmpeg/", "4.2.2"},
+		{"ffprobe version 4.3.1 Copyright (c) 2007-2020", "4.3.1"},
+		{"nona: stitch a panorama image\n\nVersion: 2019.0.0.5fcf7d5bd9c6", "2019.0.0.5"},
+		{"usage: nona [options]", ""},
+	}
+	for _, c := range cases {
+		if version := parseVersion(c.output); version != c.version {
+			t.Errorf("%q: expected %q, got %q", c.output, c.version, version)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"4.2.2", "4.2", 1},
+		{"4.2", "4.2.0", 0},
+		{"4.10", "4.9", 1},
+		{"3.4.8", "4.2", -1},
+	}
+	for _, c := range cases {
+		if result := compareVersions(c.a, c.b); result != c.expected {
+			t.Errorf("%s vs %s: expected %d, got %d", c.a, c.b, c.expected, result)
+		}
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	cases := []struct {
+		script     string
+		minVersion string
+		accepted   []int
+		ok         bool
+	}{
+		{"echo ffmpeg version 4.2.2", "", nil, true},
+		{"echo ffmpeg version 4.2.2", "4.3", nil, false},
+		{"echo usage: ffmpeg", "", nil, false},
+		// e.g. a missing shared library
+		{"echo ffmpeg version 4.2.2; exit 127", "", nil, false},
+		{"echo nona version 2019.0.0; exit 1", "", []int{NONA_USAGE_EXIT_CODE}, true},
+		{"echo nona version 2019.0.0; exit 127", "", []int{NONA_USAGE_EXIT_CODE}, false},
+	}
+	for _, c := range cases {
+		err := checkBinary("sh", c.minVersion, []string{"-c", c.script}, c.accepted...)(&HealthCheck{})
+		if (err == nil) != c.ok {
+			t.Errorf("%q: expected ok %v, got %v", c.script, c.ok, err)
+		}
+	}
+}
+
+func TestCheckTempDir(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "spin360-health")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	check := &HealthCheck{}
+	err = checkTempDir(filepath.Join(tempPath, "temp"), 1)(check)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(tempPath, "temp"))
+	if len(files) != 0 {
+		t.Errorf("probe file was not removed: %d files left", len(files))
+	}
+
+	free, err := diskFree(tempPath)
+	if err != nil {
+		return
+	}
+	err = checkTempDir(tempPath, free*2)(&HealthCheck{})
+	if err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("expected free space error, got %v", err)
+	}
+}
+
+func TestCheckStorage(t *testing.T) {
+	storage := newMemoryStorage()
+	check := &HealthCheck{}
+	if err := checkStorage(storage, HEALTH_SENTINEL_KEY)(check); err != nil || len(check.Detail) <= 0 {
+		t.Errorf("missing sentinel key should pass, got %v", err)
+	}
+
+	storage.PutContent("ok", HEALTH_SENTINEL_KEY, &UploadOptions{})
+	if err := checkStorage(storage, HEALTH_SENTINEL_KEY)(&HealthCheck{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunHealthProbes(t *testing.T) {
+	status := runHealthProbes([]*healthProbe{
+		&healthProbe{"ok", func(check *HealthCheck) error { return nil }},
+		&healthProbe{"broken", func(check *HealthCheck) error { return errors.New("broken") }},
+		&healthProbe{"slow", func(check *HealthCheck) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	}, 100*time.Millisecond)
+
+	if status.Status != HEALTH_STATUS_FAIL || len(status.Checks) != 3 {
+		t.Errorf("unexpected status %+v", status)
+		return
+	}
+	if !status.Checks[0].OK || status.Checks[1].Error != "broken" || status.Checks[2].Error != "timed out" {
+		t.Errorf("unexpected checks %+v %+v %+v", status.Checks[0], status.Checks[1], status.Checks[2])
+	}
+}
+
+func TestHTTPService_Health(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		FFMpegConf: &FFMPEGConfig{FFmpeg: "/nonexistent/ffmpeg"},
+		TempPath:   os.TempDir(),
+		Auth: &AuthConfig{
+			APIKeys: []*APIKeyConfig{&APIKeyConfig{Name: "prometheus", Key: "metrics-key", Scopes: []string{SCOPE_METRICS}}},
+		},
+	})
+	handler := httpServer.getHTTPHandler()
+
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, HEALTHZ_PATH, nil))
+	if writer.Code != http.StatusOK {
+		t.Errorf("healthz: expected 200, got %d %s", writer.Code, writer.Body.String())
+	}
+
+	// versions and storage errors stay hidden from anonymous callers
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, READYZ_PATH, nil))
+	if writer.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: expected 503, got %d", writer.Code)
+	}
+	if strings.Contains(writer.Body.String(), "checks") {
+		t.Errorf("readyz: unexpected checks in %s", writer.Body.String())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, READYZ_PATH, nil)
+	request.Header.Set(API_KEY_HEADER, "metrics-key")
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, request)
+	if writer.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: expected 503, got %d", writer.Code)
+	}
+	for _, name := range []string{`"name":"ffmpeg"`, `"name":"storage"`} {
+		if !strings.Contains(writer.Body.String(), name) {
+			t.Errorf("readyz: missing %s in %s", name, writer.Body.String())
+		}
+	}
+}
+
+func TestHealthCache_Get(t *testing.T) {
+	cache := NewHealthCache(5 * time.Second)
+	runs := 0
+	probe := func() *HealthStatus {
+		runs++
+		return &HealthStatus{Status: HEALTH_STATUS_OK}
+	}
+
+	now := time.Now()
+	cache.Get("", now, probe)
+	cache.Get("", now.Add(4*time.Second), probe)
+	if runs != 1 {
+		t.Errorf("expected a cached status, probed %d times", runs)
+	}
+	cache.Get("acme", now, probe)
+	cache.Get("", now.Add(5*time.Second), probe)
+	if runs != 3 {
+		t.Errorf("expected tenants and expired entries to probe again, probed %d times", runs)
+	}
+}
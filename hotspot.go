@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var ErrHotSpotNotFound = errors.New("hotspot not found")
+
+// swagger:parameters hotSpotParams
+type HotSpotParams struct {
+	//in: body
+	Body *PageHotSpot
+}
+
+// swagger:parameters vr360HotSpotParams
+type VR360HotSpotParams struct {
+	//in: body
+	Body *PannellumHotSpot
+}
+
+// swagger:parameters jsonPatchParams
+type JSONPatchParams struct {
+	//in: body
+	Body []*JSONPatchOperation
+}
+
+// EditableConfig is a stored config that can be modified in place.
+type EditableConfig interface {
+	EnsureHotSpotIds()
+	Validate() ValidationErrors
+}
+
+// hotSpotId keeps ids of hotspots saved before they had one stable: the
+// index based id is the same on every read until the config is saved.
+func hotSpotId(index int, ids map[string]bool) string {
+	id := fmt.Sprintf("hotspot-%d", index)
+	for n := 1; ids[id]; n++ {
+		id = fmt.Sprintf("hotspot-%d-%d", index, n)
+	}
+	ids[id] = true
+
+	return id
+}
+
+func (this *Spin360Config) EnsureHotSpotIds() {
+	ids := make(map[string]bool)
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot != nil && len(hotSpot.Id) > 0 {
+			ids[hotSpot.Id] = true
+		}
+	}
+	for i, hotSpot := range this.HotSpot {
+		if hotSpot != nil && len(hotSpot.Id) <= 0 {
+			hotSpot.Id = hotSpotId(i, ids)
+		}
+	}
+}
+
+func (this *PannellumConfig) EnsureHotSpotIds() {
+	ids := make(map[string]bool)
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot != nil && len(hotSpot.Id) > 0 {
+			ids[hotSpot.Id] = true
+		}
+	}
+	for i, hotSpot := range this.HotSpot {
+		if hotSpot != nil && len(hotSpot.Id) <= 0 {
+			hotSpot.Id = hotSpotId(i, ids)
+		}
+	}
+}
+
+func (this *Spin360Config) hotSpotIndex(id string) int {
+	for i, hotSpot := range this.HotSpot {
+		if hotSpot != nil && hotSpot.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (this *PannellumConfig) hotSpotIndex(id string) int {
+	for i, hotSpot := range this.HotSpot {
+		if hotSpot != nil && hotSpot.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// modifyConfig loads the current config, applies modify and saves it back
+// only if nobody else saved in between, returning the new etag.
+func (this *Worker) modifyConfig(store *ConfigStore, hash string, ifMatch string, conf EditableConfig, modify func() error) (string, error) {
+	etag, err := store.Load(hash, 0, conf)
+	if err != nil {
+		return "", err
+	}
+	if len(ifMatch) > 0 && !MatchETag(ifMatch, etag) {
+		return "", ErrPreconditionFailed
+	}
+	conf.EnsureHotSpotIds()
+
+	err = modify()
+	if err != nil {
+		return "", err
+	}
+
+	conf.EnsureHotSpotIds()
+	if errs := conf.Validate(); len(errs) > 0 {
+		return "", errs
+	}
+
+	_, version, err := store.SaveIfMatch(hash, conf, etag)
+	if err != nil {
+		return "", err
+	}
+
+	return version.ETag, nil
+}
+
+// patchConfig applies a JSON Patch to the config, reset must clear conf so
+// members removed by the patch do not survive the decode.
+func (this *Worker) patchConfig(store *ConfigStore, hash string, patch []*JSONPatchOperation, ifMatch string, conf EditableConfig, reset func()) (string, error) {
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		data, err := json.Marshal(conf)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		data, err = ApplyJSONPatch(data, patch)
+		if err != nil {
+			return err
+		}
+
+		reset()
+		err = json.Unmarshal(data, conf)
+		if err != nil {
+			return ValidationErrors{&ValidationError{Field: "body", Message: err.Error()}}
+		}
+		return nil
+	})
+}
+
+func (this *Worker) ListHotSpots(hash string) ([]*PageHotSpot, string, error) {
+	conf, etag, err := this.GetConfigWithETag(hash, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf.HotSpot, etag, nil
+}
+
+func (this *Worker) AddHotSpot(hash string, hotSpot *PageHotSpot, ifMatch string) (string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	if len(hotSpot.Id) <= 0 {
+		hotSpot.Id = uuid.NewV4().String()
+	}
+	conf := new(Spin360Config)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		if conf.hotSpotIndex(hotSpot.Id) >= 0 {
+			return ValidationErrors{&ValidationError{Field: "id", Message: fmt.Sprintf("duplicate id %q", hotSpot.Id)}}
+		}
+		conf.HotSpot = append(conf.HotSpot, hotSpot)
+		return nil
+	})
+}
+
+func (this *Worker) UpdateHotSpot(hash string, id string, hotSpot *PageHotSpot, ifMatch string) (string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	hotSpot.Id = id
+	conf := new(Spin360Config)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		index := conf.hotSpotIndex(id)
+		if index < 0 {
+			return ErrHotSpotNotFound
+		}
+		conf.HotSpot[index] = hotSpot
+		return nil
+	})
+}
+
+func (this *Worker) DeleteHotSpot(hash string, id string, ifMatch string) (string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	conf := new(Spin360Config)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		index := conf.hotSpotIndex(id)
+		if index < 0 {
+			return ErrHotSpotNotFound
+		}
+		conf.HotSpot = append(conf.HotSpot[:index], conf.HotSpot[index+1:]...)
+		return nil
+	})
+}
+
+func (this *Worker) PatchConfig(hash string, patch []*JSONPatchOperation, ifMatch string) (*Spin360Config, string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return nil, "", err
+	}
+
+	conf := new(Spin360Config)
+	etag, err := this.patchConfig(store, hash, patch, ifMatch, conf, func() {
+		*conf = Spin360Config{}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf, etag, nil
+}
+
+func (this *Worker) ListVR360HotSpots(hash string) ([]*PannellumHotSpot, string, error) {
+	conf, etag, err := this.GetVR360ConfigWithETag(hash, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf.HotSpot, etag, nil
+}
+
+func (this *Worker) AddVR360HotSpot(hash string, hotSpot *PannellumHotSpot, ifMatch string) (string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	if len(hotSpot.Id) <= 0 {
+		hotSpot.Id = uuid.NewV4().String()
+	}
+	conf := new(PannellumConfig)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		if conf.hotSpotIndex(hotSpot.Id) >= 0 {
+			return ValidationErrors{&ValidationError{Field: "id", Message: fmt.Sprintf("duplicate id %q", hotSpot.Id)}}
+		}
+		conf.HotSpot = append(conf.HotSpot, hotSpot)
+		return nil
+	})
+}
+
+func (this *Worker) UpdateVR360HotSpot(hash string, id string, hotSpot *PannellumHotSpot, ifMatch string) (string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	hotSpot.Id = id
+	conf := new(PannellumConfig)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		index := conf.hotSpotIndex(id)
+		if index < 0 {
+			return ErrHotSpotNotFound
+		}
+		conf.HotSpot[index] = hotSpot
+		return nil
+	})
+}
+
+func (this *Worker) DeleteVR360HotSpot(hash string, id string, ifMatch string) (string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return "", err
+	}
+
+	conf := new(PannellumConfig)
+
+	return this.modifyConfig(store, hash, ifMatch, conf, func() error {
+		index := conf.hotSpotIndex(id)
+		if index < 0 {
+			return ErrHotSpotNotFound
+		}
+		conf.HotSpot = append(conf.HotSpot[:index], conf.HotSpot[index+1:]...)
+		return nil
+	})
+}
+
+func (this *Worker) PatchVR360Config(hash string, patch []*JSONPatchOperation, ifMatch string) (*PannellumConfig, string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return nil, "", err
+	}
+
+	conf := new(PannellumConfig)
+	etag, err := this.patchConfig(store, hash, patch, ifMatch, conf, func() {
+		*conf = PannellumConfig{}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return conf, etag, nil
+}
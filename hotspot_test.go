@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSpin360Config_EnsureHotSpotIds(t *testing.T) {
+	conf := &Spin360Config{
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{Type: HOTSPOT_TYPE_TEXT},
+			&PageHotSpot{Id: "hotspot-0", Type: HOTSPOT_TYPE_TEXT},
+			&PageHotSpot{Type: HOTSPOT_TYPE_TEXT},
+		},
+	}
+
+	conf.EnsureHotSpotIds()
+	ids := []string{"hotspot-0-1", "hotspot-0", "hotspot-2"}
+	for i, id := range ids {
+		if conf.HotSpot[i].Id != id {
+			t.Errorf("hotspot %d: expected id %s, got %s", i, id, conf.HotSpot[i].Id)
+		}
+	}
+}
+
+func TestWorker_ModifyConfig(t *testing.T) {
+	store := NewConfigStore(newMemoryStorage())
+	_, saved, err := store.Save("abc", &Spin360Config{
+		Pages: []*SpinPage{&SpinPage{ImageURL: "https://example.com/0.jpg"}},
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{Type: HOTSPOT_TYPE_TEXT, Text: NewLocalizedText("a"), Coordinates: []*HotSpotCoordinates{
+				&HotSpotCoordinates{PageIndex: 0, X: 0.1, Y: 0.2},
+			}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	worker := NewWorker(&Config{})
+	conf := new(Spin360Config)
+	patch := []*JSONPatchOperation{}
+	err = json.Unmarshal([]byte(`[
+		{"op":"test","path":"/hotspot/0/id","value":"hotspot-0"},
+		{"op":"replace","path":"/hotspot/0/coordinate/0/x","value":0.15}
+	]`), &patch)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	etag, err := worker.patchConfig(store, "abc", patch, saved.ETag, conf, func() {
+		*conf = Spin360Config{}
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if conf.HotSpot[0].Coordinates[0].X != 0.15 || conf.HotSpot[0].Id != "hotspot-0" {
+		t.Errorf("unexpected patched hotspot %+v", conf.HotSpot[0])
+		t.Fail()
+		return
+	}
+
+	// stale etag
+	_, err = worker.modifyConfig(store, "abc", saved.ETag, new(Spin360Config), func() error {
+		return nil
+	})
+	if err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+		t.Fail()
+		return
+	}
+
+	// invalid result is rejected and nothing is saved
+	conf = new(Spin360Config)
+	_, err = worker.modifyConfig(store, "abc", etag, conf, func() error {
+		conf.HotSpot[0].Type = "unknown"
+		return nil
+	})
+	if _, ok := err.(ValidationErrors); !ok {
+		t.Errorf("expected ValidationErrors, got %v", err)
+		t.Fail()
+		return
+	}
+	current := new(Spin360Config)
+	currentETag, err := store.Load("abc", 0, current)
+	if err != nil || currentETag != etag {
+		t.Errorf("config should be unchanged, %v", err)
+		t.Fail()
+		return
+	}
+}
@@ -16,15 +16,20 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/satori/go.uuid"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -35,6 +40,10 @@ const (
 	STATUS_TASK_RUNNING = "RUNNING"
 	STATUS_TASK_DONE    = "DONE"
 	STATUS_TASK_FAILED  = "FAILED"
+
+	TASK_KIND_SPLIT       = "split"
+	TASK_KIND_VR360       = "vr360"
+	TASK_KIND_VR360_VIDEO = "vr360_video"
 )
 
 type Task struct {
@@ -42,12 +51,22 @@ type Task struct {
 	Result interface{} `json:"data"`
 	Error  string      `json:"error"`
 	Status string      `json:"status"`
+	Kind   string      `json:"kind"`
+	Tenant string      `json:"-"`
 }
 
 type HTTPService struct {
 	config   *Config
 	tasks    map[string]*Task
 	taskLock chan bool
+	auth     *Authenticator
+	authErr  error
+	limiter  *RateLimiter
+	quotas   *QuotaTracker
+	uploads  *UploadStore
+	health   *HealthCache
+	// serializes OSS callbacks so replays see the record of the first one
+	ossLock sync.Mutex
 }
 
 // swagger:response ServiceResult
@@ -58,39 +77,102 @@ type ServiceResult struct {
 }
 
 func NewHTTP(conf *Config) *HTTPService {
-	return &HTTPService{
+	service := &HTTPService{
 		config:   conf,
 		tasks:    make(map[string]*Task),
 		taskLock: make(chan bool, 1),
+		quotas:   NewQuotaTracker(),
+		uploads:  NewUploadStore(conf.TempPath, conf.Uploads),
+		health:   NewHealthCache(HEALTH_CACHE_TTL),
+	}
+	if conf.RateLimit != nil {
+		service.limiter = NewRateLimiter(conf.RateLimit)
 	}
+	if conf.Auth != nil {
+		service.auth, service.authErr = NewAuthenticator(conf.Auth)
+	}
+
+	return service
 }
 
 func (this *HTTPService) getHTTPHandler() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/", this.RedirectSwagger)
-	r.HandleFunc("/split", this.Split)
-	r.HandleFunc("/vr360", this.VR360)
-	r.HandleFunc("/vr360/s3", this.VR360ToS3)
-	r.HandleFunc("/config", this.SavePlayerConfig).Methods("POST")
-	r.HandleFunc("/vr360/config", this.SaveVR360Config).Methods("POST")
-	r.HandleFunc("/config/{hash}", this.GetConfig).Methods("GET")
-	r.HandleFunc("/vr360/config/{hash}", this.GetVR360Config).Methods("GET")
-	r.HandleFunc("/vr360/config/{hash}", this.SaveVR360Config).Methods("POST")
-	r.HandleFunc("/s3", this.S3)
-	r.HandleFunc("/s3/url", this.S3FromURL).Methods("POST")
-	r.HandleFunc("/oss/params", this.GetOSSUploadParams).Methods("GET")
-	r.HandleFunc("/task", this.GetTask)
+	r.HandleFunc("/metrics", this.RequireScope(SCOPE_METRICS, this.Metrics)).Methods("GET")
+	r.HandleFunc(HEALTHZ_PATH, this.Healthz).Methods("GET")
+	r.HandleFunc(READYZ_PATH, this.Readyz).Methods("GET")
+	r.HandleFunc("/split", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.Split)))
+	r.HandleFunc("/vr360", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.VR360)))
+	r.HandleFunc("/vr360/s3", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.VR360ToS3)))
+	r.HandleFunc("/vr360/key", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.VR360FromKey))).Methods("POST")
+	r.HandleFunc("/config", this.RequireScope(SCOPE_CONFIG_WRITE, this.SavePlayerConfig)).Methods("POST")
+	r.HandleFunc("/vr360/config", this.RequireScope(SCOPE_CONFIG_WRITE, this.SaveVR360Config)).Methods("POST")
+	r.HandleFunc("/config/track", this.RequireScope(SCOPE_CONFIG_WRITE, this.TrackHotSpot)).Methods("POST")
+	r.HandleFunc("/config/import", this.RequireScope(SCOPE_CONFIG_WRITE, this.Throttle(this.ImportConfig))).Methods("POST")
+	r.HandleFunc("/config/{hash}", this.RequireScope(SCOPE_CONFIG_READ, this.GetConfig)).Methods("GET")
+	r.HandleFunc("/config/{hash}/versions", this.RequireScope(SCOPE_CONFIG_READ, this.GetConfigVersions)).Methods("GET")
+	r.HandleFunc("/config/{hash}/rollback", this.RequireScope(SCOPE_CONFIG_WRITE, this.RollbackConfig)).Methods("POST")
+	r.HandleFunc("/config/{hash}/migrate", this.RequireScope(SCOPE_CONFIG_WRITE, this.MigrateConfig)).Methods("POST")
+	r.HandleFunc("/config/{hash}/export", this.RequireScope(SCOPE_CONFIG_READ, this.ExportConfig)).Methods("GET")
+	r.HandleFunc("/config/{hash}", this.RequireScope(SCOPE_CONFIG_WRITE, this.PatchConfig)).Methods("PATCH")
+	r.HandleFunc("/config/{hash}/hotspots", this.RequireScope(SCOPE_CONFIG_READ, this.ListHotSpots)).Methods("GET")
+	r.HandleFunc("/config/{hash}/hotspots", this.RequireScope(SCOPE_CONFIG_WRITE, this.AddHotSpot)).Methods("POST")
+	r.HandleFunc("/config/{hash}/hotspots/{id}", this.RequireScope(SCOPE_CONFIG_WRITE, this.UpdateHotSpot)).Methods("PUT")
+	r.HandleFunc("/config/{hash}/hotspots/{id}", this.RequireScope(SCOPE_CONFIG_WRITE, this.DeleteHotSpot)).Methods("DELETE")
+	r.HandleFunc("/vr360/config/{hash}", this.RequireScope(SCOPE_CONFIG_READ, this.GetVR360Config)).Methods("GET")
+	r.HandleFunc("/vr360/config/{hash}", this.RequireScope(SCOPE_CONFIG_WRITE, this.PatchVR360Config)).Methods("PATCH")
+	r.HandleFunc("/vr360/config/{hash}/hotspots", this.RequireScope(SCOPE_CONFIG_READ, this.ListVR360HotSpots)).Methods("GET")
+	r.HandleFunc("/vr360/config/{hash}/hotspots", this.RequireScope(SCOPE_CONFIG_WRITE, this.AddVR360HotSpot)).Methods("POST")
+	r.HandleFunc("/vr360/config/{hash}/hotspots/{id}", this.RequireScope(SCOPE_CONFIG_WRITE, this.UpdateVR360HotSpot)).Methods("PUT")
+	r.HandleFunc("/vr360/config/{hash}/hotspots/{id}", this.RequireScope(SCOPE_CONFIG_WRITE, this.DeleteVR360HotSpot)).Methods("DELETE")
+	r.HandleFunc("/vr360/config/{hash}/versions", this.RequireScope(SCOPE_CONFIG_READ, this.GetVR360ConfigVersions)).Methods("GET")
+	r.HandleFunc("/vr360/config/{hash}/rollback", this.RequireScope(SCOPE_CONFIG_WRITE, this.RollbackVR360Config)).Methods("POST")
+	r.HandleFunc("/vr360/config/{hash}/export", this.RequireScope(SCOPE_CONFIG_READ, this.ExportVR360Config)).Methods("GET")
+	r.HandleFunc("/vr360/config/{hash}", this.RequireScope(SCOPE_CONFIG_WRITE, this.SaveVR360Config)).Methods("POST")
+	r.HandleFunc("/vr360/video", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.VR360Video))).Methods("POST")
+	r.HandleFunc("/vr360/video/{hash}", this.RequireScope(SCOPE_CONFIG_READ, this.GetVR360VideoConfig)).Methods("GET")
+	r.HandleFunc("/vr360/tour", this.RequireScope(SCOPE_CONFIG_WRITE, this.SaveTour)).Methods("POST")
+	r.HandleFunc("/vr360/tour/{hash}", this.RequireScope(SCOPE_CONFIG_READ, this.GetTour)).Methods("GET")
+	r.HandleFunc("/vr360/tour/{hash}", this.RequireScope(SCOPE_CONFIG_WRITE, this.SaveTour)).Methods("POST")
+	r.HandleFunc("/vr360/tour/{hash}/scene", this.RequireScope(SCOPE_CONFIG_WRITE, this.AddTourScene)).Methods("POST")
+	r.HandleFunc("/vr360/tour/{hash}/link", this.RequireScope(SCOPE_CONFIG_WRITE, this.LinkTourScenes)).Methods("POST")
+	r.HandleFunc("/s3", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.S3)))
+	r.HandleFunc("/s3/url", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.S3FromURL))).Methods("POST")
+	r.HandleFunc("/s3/key", this.RequireScope(SCOPE_UPLOAD, this.Throttle(this.S3FromKey))).Methods("POST")
+	r.HandleFunc("/oss/params", this.RequireScope(SCOPE_UPLOAD, this.GetOSSUploadParams)).Methods("GET")
+	r.HandleFunc(OSS_CALLBACK_PATH, this.OSSCallback).Methods("POST")
+	r.HandleFunc("/s3/params", this.RequireScope(SCOPE_UPLOAD, this.GetS3UploadParams)).Methods("GET")
+	r.HandleFunc("/upload/params", this.RequireScope(SCOPE_UPLOAD, this.GetUploadParams)).Methods("GET")
+	r.HandleFunc("/task", this.RequireScope(SCOPE_UPLOAD, this.GetTask))
+	r.HandleFunc("/upload", this.UploadOptions).Methods("OPTIONS")
+	r.HandleFunc("/upload", this.RequireScope(SCOPE_UPLOAD, this.CreateUpload)).Methods("POST")
+	r.HandleFunc("/upload/{id}", this.UploadOptions).Methods("OPTIONS")
+	r.HandleFunc("/upload/{id}", this.RequireScope(SCOPE_UPLOAD, this.HeadUpload)).Methods("HEAD")
+	r.HandleFunc("/upload/{id}", this.RequireScope(SCOPE_UPLOAD, this.PatchUpload)).Methods("PATCH")
+	r.HandleFunc("/upload/{id}", this.RequireScope(SCOPE_UPLOAD, this.DeleteUpload)).Methods("DELETE")
 	r.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/",
 		http.FileServer(http.Dir(fmt.Sprintf("%s/ui", this.config.WebRoot)))))
 	r.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger/",
 		http.FileServer(http.Dir(fmt.Sprintf("%s/swagger", this.config.WebRoot)))))
 	r.NotFoundHandler = http.HandlerFunc(this.NotFoundHandle)
+	r.Use(this.Instrument, this.Authenticate, this.ResolveTenant)
 
 	return r
 }
 
 func (this *HTTPService) Start() error {
 	log.Info("http service starting")
+	if this.authErr != nil {
+		return this.authErr
+	}
+	if this.config.Auth == nil {
+		log.Warning("auth is not configured, every endpoint is public")
+	}
+	if this.config.OSSUpload != nil {
+		if err := this.config.OSSUpload.Validate(); err != nil {
+			return err
+		}
+	}
 	log.Infof("Please open http://%s\n", this.config.Listen)
 	return http.ListenAndServe(this.config.Listen, this.getHTTPHandler())
 }
@@ -136,6 +218,8 @@ func GetMimeType(src *multipart.FileHeader) (string, string, error) {
 // responses:
 //   200:
 //     description: OK
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
@@ -143,7 +227,7 @@ func GetMimeType(src *multipart.FileHeader) (string, string, error) {
 func (this *HTTPService) Split(writer http.ResponseWriter, request *http.Request) {
 
 	request.ParseMultipartForm(32 << 20)
-	uploadFile, _, err := request.FormFile("video")
+	uploadFile, _, err := this.formFile(request, "video")
 	if err != nil {
 		log.Error(err)
 		this.ResponseError(err, writer, 500)
@@ -162,7 +246,8 @@ func (this *HTTPService) Split(writer http.ResponseWriter, request *http.Request
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Minute*30))
 	defer cancel()
 
-	worker := NewWorker(this.config)
+	worker := this.newWorker(request)
+	this.startJob(request)
 	out, err := worker.Split(ctx, uploadFile, splitSize)
 	if err != nil {
 		log.Error(err)
@@ -184,33 +269,81 @@ func (this *HTTPService) Split(writer http.ResponseWriter, request *http.Request
 // produces:
 //   - application/json
 // parameters:
+// - name: mode
+//   type: string
+//   in: formData
+//   enum: [equirectangular, cubemap, dualfisheye]
+//   description: 输入类型，默认 equirectangular
 // - name: image
 //   type: file
 //   in: formData
-//   required: true
-//   description: 图片文件
+//   description: 等距柱状投影全景图或双鱼眼原图，mode 为 equirectangular/dualfisheye 时必填
+// - name: faces
+//   type: file
+//   in: formData
+//   description: cubemap 六个立方体面的 zip 包，文件名为 f/b/u/d/l/r 或 front/back/up/down/left/right，每面为正方形且边长不超过 8192px
+// - name: face_f
+//   type: file
+//   in: formData
+//   description: cubemap 前面，未提供 faces 时需同时上传 face_f/face_b/face_u/face_d/face_l/face_r
+// - name: layout
+//   type: string
+//   in: formData
+//   enum: [sbs, tb]
+//   description: 双鱼眼排列方式（左右/上下），默认 sbs
+// - name: fov
+//   type: number
+//   in: formData
+//   description: 双鱼眼单镜头视场角，默认 190
+// - name: tileSize
+//   type: integer
+//   in: formData
+//   description: 分片尺寸，须为 2 的幂，默认 512
+// - name: quality
+//   type: integer
+//   in: formData
+//   description: JPEG 压缩质量 1-100，默认 95
+// - name: fallbackSize
+//   type: integer
+//   in: formData
+//   description: fallback 立方体面尺寸，默认 1024
+// - name: format
+//   type: string
+//   in: formData
+//   enum: [jpg, png]
+//   description: 分片图片格式，默认 jpg
 // responses:
 //   200:
 //     description: OK
+//   400:
+//     description: Invalid input, 缺少全景图或立方体面、mode 不支持等
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
 func (this *HTTPService) VR360(writer http.ResponseWriter, request *http.Request)  {
 	request.ParseMultipartForm(32 << 20)
-	uploadFile, _, err := request.FormFile("image")
+	opts, err := this.getTileOptions(request)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+	source, closeSource, err := this.getVR360Source(request, this.formOpener(request))
 	if err != nil {
 		log.Error(err)
-		this.ResponseError(err, writer, 500)
+		this.ResponseVR360SourceError(err, writer)
 		return
 	}
-	defer uploadFile.Close()
+	defer closeSource()
 
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Minute*30))
 	defer cancel()
 
-	worker := NewWorker(this.config)
-	reader, err := worker.VR360(ctx, uploadFile)
+	worker := this.newWorker(request)
+	this.startJob(request)
+	reader, err := worker.VR360(ctx, source, opts)
 	if err != nil {
 		log.Error(err)
 		this.ResponseError(err, writer, 500)
@@ -220,6 +353,146 @@ func (this *HTTPService) VR360(writer http.ResponseWriter, request *http.Request
 	this.streamFile(reader, time.Now().Format("20060102150405.zip"), writer)
 }
 
+// getVR360Source collects the panorama for the requested mode from the files
+// of open, the returned func closes every opened file.
+func (this *HTTPService) getVR360Source(request *http.Request, open fileOpener) (*VR360Source, func(), error) {
+	closers := make([]io.Closer, 0)
+	closeAll := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+
+	source := &VR360Source{
+		Mode: request.FormValue("mode"),
+	}
+	if len(source.Mode) <= 0 {
+		source.Mode = VR360_MODE_EQUIRECTANGULAR
+	}
+
+	switch source.Mode {
+	case VR360_MODE_EQUIRECTANGULAR, VR360_MODE_DUAL_FISHEYE:
+		uploadFile, _, err := open("image")
+		if err == http.ErrMissingFile {
+			return nil, closeAll, newVR360SourceError("missing image")
+		}
+		if err != nil {
+			return nil, closeAll, err
+		}
+		closers = append(closers, uploadFile)
+		source.Image = uploadFile
+
+		if source.Mode == VR360_MODE_DUAL_FISHEYE {
+			source.Fisheye = NewFisheyeOptions()
+			if layout := request.FormValue("layout"); len(layout) > 0 {
+				source.Fisheye.Layout = layout
+			}
+			if fov := request.FormValue("fov"); len(fov) > 0 {
+				n, err := strconv.ParseFloat(fov, 64)
+				if err != nil {
+					return nil, closeAll, newVR360SourceError("invalid fov: %s", fov)
+				}
+				source.Fisheye.FOV = n
+			}
+			err = source.Fisheye.Validate()
+			if err != nil {
+				return nil, closeAll, newVR360SourceError("%s", err)
+			}
+		}
+	case VR360_MODE_CUBEMAP:
+		source.Faces = make([]io.Reader, len(faceLetters))
+		zipFile, header, err := open("faces")
+		if err == nil {
+			closers = append(closers, zipFile)
+			archive, err := zip.NewReader(zipFile, header.Size)
+			if err != nil {
+				return nil, closeAll, newVR360SourceError("faces: %s", err)
+			}
+			for _, file := range archive.File {
+				index, ok := CubeFaceIndex(file.Name)
+				if !ok || file.FileInfo().IsDir() {
+					continue
+				}
+				reader, err := file.Open()
+				if err != nil {
+					return nil, closeAll, newVR360SourceError("%s: %s", file.Name, err)
+				}
+				closers = append(closers, reader)
+				source.Faces[index] = reader
+			}
+		} else {
+			for i, letter := range faceLetters {
+				uploadFile, _, err := open(fmt.Sprintf("face_%s", letter))
+				if err != nil {
+					continue
+				}
+				closers = append(closers, uploadFile)
+				source.Faces[i] = uploadFile
+			}
+		}
+		for i, face := range source.Faces {
+			if face == nil {
+				return nil, closeAll, newVR360SourceError("missing cube face %s", faceLetters[i])
+			}
+		}
+	default:
+		return nil, closeAll, newVR360SourceError("unsupported mode %q", source.Mode)
+	}
+
+	return source, closeAll, nil
+}
+
+func (this *HTTPService) formOpener(request *http.Request) fileOpener {
+	return func(field string) (multipart.File, *multipart.FileHeader, error) {
+		return this.formFile(request, field)
+	}
+}
+
+func (this *HTTPService) getVersionParam(request *http.Request) (int, error) {
+	val := request.FormValue("version")
+	if len(val) <= 0 {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(val)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version: %s", val)
+	}
+
+	return version, nil
+}
+
+func (this *HTTPService) getTileOptions(request *http.Request) (*TileOptions, error) {
+	opts := NewTileOptions()
+
+	intFields := map[string]*int{
+		"tileSize":     &opts.TileSize,
+		"quality":      &opts.Quality,
+		"fallbackSize": &opts.FallbackSize,
+	}
+	for name, field := range intFields {
+		val := request.FormValue(name)
+		if len(val) <= 0 {
+			continue
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, val)
+		}
+		*field = n
+	}
+	if format := request.FormValue("format"); len(format) > 0 {
+		opts.Format = format
+	}
+
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
 //
 // swagger:operation POST /s3 uploadS3
 //
@@ -244,13 +517,15 @@ func (this *HTTPService) VR360(writer http.ResponseWriter, request *http.Request
 // responses:
 //   200:
 //     description: OK
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
 func (this *HTTPService) S3(writer http.ResponseWriter, request *http.Request) {
 	request.ParseMultipartForm(32 << 20)
-	uploadFile, _, err := request.FormFile("video")
+	uploadFile, _, err := this.formFile(request, "video")
 	if err != nil {
 		log.Error(err)
 		this.ResponseError(err, writer, 500)
@@ -266,7 +541,7 @@ func (this *HTTPService) S3(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	task := this.createTask()
+	task := this.createTask(request, TASK_KIND_SPLIT)
 
 	go func() {
 		defer uploadFile.Close()
@@ -274,11 +549,12 @@ func (this *HTTPService) S3(writer http.ResponseWriter, request *http.Request) {
 		task.Status = STATUS_TASK_RUNNING
 		this.UpdateTaskStatus(task.ID, task)
 
-		worker := NewWorker(this.config)
+		worker := this.newWorker(request)
 		list, err := worker.S3(uploadFile, splitSize)
 		if err != nil {
 			log.Error(err)
 			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
 			this.UpdateTaskStatus(task.ID, task)
 			return
 		}
@@ -315,6 +591,10 @@ func (this *HTTPService) S3(writer http.ResponseWriter, request *http.Request) {
 // responses:
 //   200:
 //     description: OK
+//   400:
+//     description: URL 不在允许的下载范围内
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
@@ -330,17 +610,27 @@ func (this *HTTPService) S3FromURL(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
-	task := this.createTask()
+	videoURL, err := url.Parse(URL)
+	if err == nil {
+		err = NewDownloadPolicy(this.requestConfig(request).Download).CheckURL(videoURL)
+	}
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	task := this.createTask(request, TASK_KIND_SPLIT)
 
 	go func() {
 		task.Status = STATUS_TASK_RUNNING
 		this.UpdateTaskStatus(task.ID, task)
 
-		worker := NewWorker(this.config)
+		worker := this.newWorker(request)
 		list, err := worker.S3FromURL(URL, splitSize)
 		if err != nil {
 			log.Error(err)
 			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
 			this.UpdateTaskStatus(task.ID, task)
 			return
 		}
@@ -354,9 +644,9 @@ func (this *HTTPService) S3FromURL(writer http.ResponseWriter, request *http.Req
 }
 
 //
-// swagger:operation POST /vr360/s3 vr360ToS3
+// swagger:operation POST /s3/key uploadS3FromKey
 //
-// 上传全景图，返回task（任务）ID， 将全景图分片资源打包上传到S3
+// 对存储中已有的视频截图（如通过 /oss/params 直传的文件），返回task（任务）ID
 //
 // ---
 // consumes:
@@ -364,46 +654,70 @@ func (this *HTTPService) S3FromURL(writer http.ResponseWriter, request *http.Req
 // produces:
 //   - application/json
 // parameters:
-// - name: image
-//   type: file
+// - name: key
+//   type: string
+//   in: formData
+//   required: true
+//   description: 视频文件的对象 key，可包含存储前缀
+// - name: storage
+//   type: string
+//   in: formData
+//   enum: [s3, oss]
+//   description: 读取的存储，默认为配置 source_storage
+// - name: splitSize
+//   type: integer
 //   in: formData
 //   required: true
-//   description: 全景图文件
+//   description: 截图总数
 // responses:
 //   200:
 //     description: OK
+//   400:
+//     description: 缺少 key 或存储未配置
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) VR360ToS3(writer http.ResponseWriter, request *http.Request) {
+func (this *HTTPService) S3FromKey(writer http.ResponseWriter, request *http.Request) {
 	request.ParseMultipartForm(32 << 20)
-	uploadFile, _, err := request.FormFile("image")
+	key := request.FormValue("key")
+	backend := request.FormValue("storage")
+
+	splitSize, err := strconv.Atoi(request.FormValue("splitSize"))
 	if err != nil {
 		log.Error(err)
 		this.ResponseError(err, writer, 500)
 		return
 	}
+	if len(key) <= 0 {
+		this.ResponseError(errors.New("missing key param"), writer, http.StatusBadRequest)
+		return
+	}
+	worker := this.newWorker(request)
+	if _, err := worker.storageBackend(backend); err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
 
-	task := this.createTask()
+	task := this.createTask(request, TASK_KIND_SPLIT)
 
 	go func() {
-		defer uploadFile.Close()
-
 		task.Status = STATUS_TASK_RUNNING
 		this.UpdateTaskStatus(task.ID, task)
 
-		worker := NewWorker(this.config)
-		url, err := worker.VR360ToS3(uploadFile)
+		list, err := worker.S3FromKey(backend, key, splitSize)
 		if err != nil {
 			log.Error(err)
 			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
 			this.UpdateTaskStatus(task.ID, task)
 			return
 		}
 
 		task.Status = STATUS_TASK_DONE
-		task.Result = url
+		task.Result = list
 		this.UpdateTaskStatus(task.ID, task)
 	}()
 
@@ -411,160 +725,2022 @@ func (this *HTTPService) VR360ToS3(writer http.ResponseWriter, request *http.Req
 }
 
 //
-// swagger:operation POST /config configParams
+// swagger:operation POST /vr360/s3 vr360ToS3
 //
-// 保存播放器配置，返回配置文件的URL
+// 上传全景图，返回task（任务）ID， 将全景图分片资源打包上传到S3
 //
 // ---
 // consumes:
-//   - application/json
 //   - multipart/form-data
 // produces:
 //   - application/json
 // parameters:
-// - name: Body
-//   in: body
-//   description: 配置
-// - name: hash
+// - name: mode
 //   type: string
-//   in: query
-//   description: 配置hash, 如提供即更新已有配置
+//   in: formData
+//   enum: [equirectangular, cubemap, dualfisheye]
+//   description: 输入类型，默认 equirectangular
+// - name: image
+//   type: file
+//   in: formData
+//   description: 等距柱状投影全景图或双鱼眼原图，mode 为 equirectangular/dualfisheye 时必填
+// - name: faces
+//   type: file
+//   in: formData
+//   description: cubemap 六个立方体面的 zip 包，文件名为 f/b/u/d/l/r 或 front/back/up/down/left/right，每面为正方形且边长不超过 8192px
+// - name: face_f
+//   type: file
+//   in: formData
+//   description: cubemap 前面，未提供 faces 时需同时上传 face_f/face_b/face_u/face_d/face_l/face_r
+// - name: layout
+//   type: string
+//   in: formData
+//   enum: [sbs, tb]
+//   description: 双鱼眼排列方式（左右/上下），默认 sbs
+// - name: fov
+//   type: number
+//   in: formData
+//   description: 双鱼眼单镜头视场角，默认 190
+// - name: tileSize
+//   type: integer
+//   in: formData
+//   description: 分片尺寸，须为 2 的幂，默认 512
+// - name: quality
+//   type: integer
+//   in: formData
+//   description: JPEG 压缩质量 1-100，默认 95
+// - name: fallbackSize
+//   type: integer
+//   in: formData
+//   description: fallback 立方体面尺寸，默认 1024
+// - name: format
+//   type: string
+//   in: formData
+//   enum: [jpg, png]
+//   description: 分片图片格式，默认 jpg
 // responses:
 //   200:
 //     description: OK
+//   400:
+//     description: Invalid input, 缺少全景图或立方体面、mode 不支持等
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) SavePlayerConfig(writer http.ResponseWriter, request *http.Request) {
-	decoder := json.NewDecoder(request.Body)
-	config := new(Spin360Config)
-	err := decoder.Decode(config)
+func (this *HTTPService) VR360ToS3(writer http.ResponseWriter, request *http.Request) {
+	request.ParseMultipartForm(32 << 20)
+	opts, err := this.getTileOptions(request)
 	if err != nil {
-		this.ResponseError(err, writer, 500)
+		this.ResponseError(err, writer, http.StatusBadRequest)
 		return
 	}
-
-	hash := ""
-	params := request.URL.Query()
-	vals, ok := params["hash"]
-	if ok && len(vals) > 0 {
-		hash = vals[0]
-	}
-
-	url := ""
-	worker := NewWorker(this.config)
-	if len(hash) > 0 {
-		url, err = worker.UpdatePlayConfig(hash, config)
-	} else {
-		url, err = worker.SavePlayConfig(config)
-	}
+	source, closeSource, err := this.getVR360Source(request, this.formOpener(request))
 	if err != nil {
-		this.ResponseError(err, writer, 500)
+		log.Error(err)
+		this.ResponseVR360SourceError(err, writer)
 		return
 	}
 
-	this.ResponseJSON(url, writer)
+	task := this.createTask(request, TASK_KIND_VR360)
+
+	go func() {
+		defer closeSource()
+
+		task.Status = STATUS_TASK_RUNNING
+		this.UpdateTaskStatus(task.ID, task)
+
+		worker := this.newWorker(request)
+		url, err := worker.VR360ToS3(source, opts)
+		if err != nil {
+			log.Error(err)
+			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
+			this.UpdateTaskStatus(task.ID, task)
+			return
+		}
+
+		task.Status = STATUS_TASK_DONE
+		task.Result = url
+		this.UpdateTaskStatus(task.ID, task)
+	}()
+
+	this.ResponseJSON(&task, writer)
 }
 
 //
-// swagger:operation POST /vr360/config/{hash} vr360Params
+// swagger:operation POST /vr360/key vr360FromKey
 //
-// 保存VR360播放器配置，返回配置文件的URL
+// 对存储中已有的全景图生成分片，返回task（任务）ID，将全景图分片资源上传到S3
 //
 // ---
 // consumes:
-//   - application/json
 //   - multipart/form-data
 // produces:
 //   - application/json
 // parameters:
-// - name: Body
-//   in: body
-//   description: 配置
-// - name: hash
+// - name: mode
 //   type: string
-//   in: path
-//   description: 配置hash, 如提供即更新已有配置
+//   in: formData
+//   enum: [equirectangular, cubemap, dualfisheye]
+//   description: 输入类型，默认 equirectangular
+// - name: image
+//   type: string
+//   in: formData
+//   description: 全景图的对象 key，mode 为 equirectangular/dualfisheye 时必填
+// - name: faces
+//   type: string
+//   in: formData
+//   description: cubemap 六个立方体面 zip 包的对象 key，每面为正方形且边长不超过 8192px
+// - name: face_f
+//   type: string
+//   in: formData
+//   description: cubemap 前面的对象 key，未提供 faces 时需同时提供 face_f/face_b/face_u/face_d/face_l/face_r
+// - name: storage
+//   type: string
+//   in: formData
+//   enum: [s3, oss]
+//   description: 读取的存储，默认为配置 source_storage
+// - name: layout
+//   type: string
+//   in: formData
+//   enum: [sbs, tb]
+//   description: 双鱼眼排列方式（左右/上下），默认 sbs
+// - name: fov
+//   type: number
+//   in: formData
+//   description: 双鱼眼单镜头视场角，默认 190
+// - name: tileSize
+//   type: integer
+//   in: formData
+//   description: 分片尺寸，须为 2 的幂，默认 512
+// - name: quality
+//   type: integer
+//   in: formData
+//   description: JPEG 压缩质量 1-100，默认 95
+// - name: fallbackSize
+//   type: integer
+//   in: formData
+//   description: fallback 立方体面尺寸，默认 1024
+// - name: format
+//   type: string
+//   in: formData
+//   enum: [jpg, png]
+//   description: 分片图片格式，默认 jpg
 // responses:
 //   200:
 //     description: OK
+//   400:
+//     description: 参数错误或存储未配置
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) SaveVR360Config(writer http.ResponseWriter, request *http.Request) {
-	decoder := json.NewDecoder(request.Body)
-	config := new(PannellumConfig)
-	err := decoder.Decode(config)
+func (this *HTTPService) VR360FromKey(writer http.ResponseWriter, request *http.Request) {
+	request.ParseMultipartForm(32 << 20)
+	opts, err := this.getTileOptions(request)
 	if err != nil {
-		this.ResponseError(err, writer, 500)
+		this.ResponseError(err, writer, http.StatusBadRequest)
 		return
 	}
-
-	hashArgs := make([]string, 0)
-	params := mux.Vars(request)
-	hash, ok := params["hash"]
-	if ok {
-		hashArgs = append(hashArgs, hash)
-	}
-
-	url := ""
-	worker := NewWorker(this.config)
-	url, err = worker.SaveVR360Config(config, hashArgs...)
-	if err != nil {
-		this.ResponseError(err, writer, 500)
+	backend := request.FormValue("storage")
+	worker := this.newWorker(request)
+	if _, err := worker.storageBackend(backend); err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
 		return
 	}
 
-	this.ResponseJSON(url, writer)
-}
+	task := this.createTask(request, TASK_KIND_VR360)
+
+	go func() {
+		task.Status = STATUS_TASK_RUNNING
+		this.UpdateTaskStatus(task.ID, task)
+
+		source, closeSource, err := this.getVR360Source(request, func(field string) (multipart.File, *multipart.FileHeader, error) {
+			return worker.FetchObject(backend, request.FormValue(field))
+		})
+		defer closeSource()
+
+		url := ""
+		if err == nil {
+			url, err = worker.VR360ToS3(source, opts)
+		}
+		if err != nil {
+			log.Error(err)
+			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
+			this.UpdateTaskStatus(task.ID, task)
+			return
+		}
+
+		task.Status = STATUS_TASK_DONE
+		task.Result = url
+		this.UpdateTaskStatus(task.ID, task)
+	}()
 
+	this.ResponseJSON(&task, writer)
+}
 
 //
-// swagger:operation GET /config/{hash} getConfig
+// swagger:operation POST /vr360/video vr360Video
 //
-// 获取spin360 配置
+// 上传全景视频，返回task（任务）ID，转码为多码率 HLS 上传到S3，任务结果为播放器配置URL
 //
 // ---
 // consumes:
-//   - application/json
+//   - multipart/form-data
 // produces:
 //   - application/json
 // parameters:
-// - name: hash
-//   in: path
-//   description: 配置hash
+// - name: video
+//   type: file
+//   in: formData
+//   required: true
+//   description: 等距柱状投影 (2:1) 全景视频文件
 // responses:
 //   200:
 //     description: OK
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) GetConfig(writer http.ResponseWriter, request *http.Request) {
-	params := mux.Vars(request)
-	hash, ok := params["hash"]
-	if !ok {
-		this.ResponseError(errors.New("missing hash param"), writer, 500)
+func (this *HTTPService) VR360Video(writer http.ResponseWriter, request *http.Request) {
+	request.ParseMultipartForm(32 << 20)
+	uploadFile, _, err := this.formFile(request, "video")
+	if err != nil {
+		log.Error(err)
+		this.ResponseError(err, writer, 500)
 		return
 	}
 
-	worker := NewWorker(this.config)
+	task := this.createTask(request, TASK_KIND_VR360_VIDEO)
 
-	conf, err := worker.GetConfig(hash)
+	go func() {
+		defer uploadFile.Close()
+
+		task.Status = STATUS_TASK_RUNNING
+		this.UpdateTaskStatus(task.ID, task)
+
+		worker := this.newWorker(request)
+		url, err := worker.VR360Video(uploadFile)
+		if err != nil {
+			log.Error(err)
+			task.Status = STATUS_TASK_FAILED
+			task.Error = err.Error()
+			this.UpdateTaskStatus(task.ID, task)
+			return
+		}
+
+		task.Status = STATUS_TASK_DONE
+		task.Result = url
+		this.UpdateTaskStatus(task.ID, task)
+	}()
+
+	this.ResponseJSON(&task, writer)
+}
+
+//
+// swagger:operation GET /vr360/video/{hash} getVR360VideoConfig
+//
+// 获取全景视频播放器配置
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetVR360VideoConfig(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	conf, err := worker.GetVR360VideoConfig(hash)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	this.ResponseJSON(conf, writer)
+}
+
+//
+// swagger:operation POST /config configParams
+//
+// 保存播放器配置，返回配置文件的URL
+//
+// ---
+// consumes:
+//   - application/json
+//   - multipart/form-data
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: 配置
+// - name: hash
+//   type: string
+//   in: query
+//   description: 配置hash, 如提供即更新已有配置, 只能包含字母、数字、- 和 _
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 更新时提供 GET 返回的 ETag, 配置已被他人修改则返回 412
+// responses:
+//   200:
+//     description: OK
+//   400:
+//     description: Invalid hash
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) SavePlayerConfig(writer http.ResponseWriter, request *http.Request) {
+	hash := ""
+	params := request.URL.Query()
+	vals, ok := params["hash"]
+	if ok && len(vals) > 0 {
+		hash = vals[0]
+		if err := ValidateConfigHash(hash); err != nil {
+			this.ResponseError(err, writer, http.StatusBadRequest)
+			return
+		}
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	config := new(Spin360Config)
+	err := decoder.Decode(config)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+	config.EnsureHotSpotIds()
+	if errs := config.Validate(); len(errs) > 0 {
+		this.ResponseValidationError(errs, writer)
+		return
+	}
+
+	url := ""
+	etag := ""
+	worker := this.newWorker(request)
+	if len(hash) > 0 {
+		url, etag, err = worker.UpdatePlayConfigIfMatch(hash, config, request.Header.Get("If-Match"))
+	} else {
+		url, err = worker.SavePlayConfig(config)
+	}
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+	if len(etag) > 0 {
+		writer.Header().Set("ETag", etag)
+	}
+
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation POST /vr360/config/{hash} vr360Params
+//
+// 保存VR360播放器配置，返回配置文件的URL
+//
+// ---
+// consumes:
+//   - application/json
+//   - multipart/form-data
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: 配置, 也接受旧版 VR360Config 格式（src, hotspot）; 更新已有配置时只合并热点及变更的 src, 分片、预览图、多语言文字等旧版无法表达的字段保留
+// - name: hash
+//   type: string
+//   in: path
+//   description: 配置hash, 如提供即更新已有配置
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 更新时提供 GET 返回的 ETag, 配置已被他人修改则返回 412
+// responses:
+//   200:
+//     description: OK
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) SaveVR360Config(writer http.ResponseWriter, request *http.Request) {
+	data, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+	hash, ok := mux.Vars(request)["hash"]
+	if ok && IsLegacyVR360Config(data) {
+		// the legacy shape cannot hold multires, previews or translations,
+		// only what it changed is merged into the stored config
+		legacy := new(VR360Config)
+		err = json.Unmarshal(data, legacy)
+		if err != nil {
+			this.ResponseError(err, writer, 500)
+			return
+		}
+		url, etag, err := this.newWorker(request).UpdateLegacyVR360ConfigIfMatch(hash, legacy, request.Header.Get("If-Match"))
+		if err != nil {
+			this.ResponseConfigError(err, writer)
+			return
+		}
+		writer.Header().Set("ETag", etag)
+		this.ResponseJSON(url, writer)
+		return
+	}
+
+	config, err := DecodeVR360Config(data)
 	if err != nil {
 		this.ResponseError(err, writer, 500)
 		return
 	}
+	if errs := config.Validate(); len(errs) > 0 {
+		this.ResponseValidationError(errs, writer)
+		return
+	}
+
+	url := ""
+	etag := ""
+	worker := this.newWorker(request)
+	if ok {
+		url, etag, err = worker.UpdateVR360ConfigIfMatch(hash, config, request.Header.Get("If-Match"))
+	} else {
+		url, err = worker.SaveVR360Config(config)
+	}
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+	if len(etag) > 0 {
+		writer.Header().Set("ETag", etag)
+	}
+
+	this.ResponseJSON(url, writer)
+}
+
+
+//
+// swagger:operation GET /config/{hash} getConfig
+//
+// 获取spin360 配置
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   description: 历史版本号，不提供即返回当前配置
+// - name: lang
+//   type: string
+//   in: query
+//   description: 语言, 例如 en, zh-TW, zh-CN; 提供时热点文字解析为该语言的字符串, 缺失时依次回退到相近语言、默认语言
+// - name: If-None-Match
+//   type: string
+//   in: header
+//   description: 上次获取的 ETag, 未修改时返回 304
+// responses:
+//   200:
+//     description: OK, ETag 响应头为配置内容的版本标识; 提供 lang 或 format 时为转换后内容的标识, 不能用于 If-Match
+//   304:
+//     description: Not modified
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetConfig(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	version, err := this.getVersionParam(request)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+
+	conf, etag, err := worker.GetConfigWithETag(hash, version)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	if lang := request.FormValue("lang"); len(lang) > 0 {
+		conf.Localize(lang)
+		etag, err = representationETag(conf)
+		if err != nil {
+			this.ResponseError(err, writer, 500)
+			return
+		}
+	}
+
+	writer.Header().Set("ETag", etag)
+	if match := request.Header.Get("If-None-Match"); len(match) > 0 && MatchETag(match, etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	this.ResponseJSON(conf, writer)
 }
 
 //
-// swagger:operation GET /vr360/config/{hash} getConfig
+// swagger:operation GET /vr360/config/{hash} getVR360Config
+//
+// 获取VR360 配置
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   description: 历史版本号，不提供即返回当前配置
+// - name: format
+//   type: string
+//   in: query
+//   description: 为 vr360 时返回旧版 VR360Config 格式
+// - name: lang
+//   type: string
+//   in: query
+//   description: 语言, 例如 en, zh-TW, zh-CN; 提供时热点文字解析为该语言的字符串, 缺失时依次回退到相近语言、默认语言
+// - name: If-None-Match
+//   type: string
+//   in: header
+//   description: 上次获取的 ETag, 未修改时返回 304
+// responses:
+//   200:
+//     description: OK, ETag 响应头为配置内容的版本标识; 提供 lang 或 format 时为转换后内容的标识, 不能用于 If-Match
+//   304:
+//     description: Not modified
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetVR360Config(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	version, err := this.getVersionParam(request)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+
+	conf, etag, err := worker.GetVR360ConfigWithETag(hash, version)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	var body interface{} = conf
+	converted := false
+	if lang := request.FormValue("lang"); len(lang) > 0 {
+		conf.Localize(lang)
+		converted = true
+	}
+	if request.FormValue("format") == VR360_CONFIG_FORMAT_LEGACY {
+		body = NewVR360Config(conf)
+		converted = true
+	}
+	if converted {
+		etag, err = representationETag(body)
+		if err != nil {
+			this.ResponseError(err, writer, 500)
+			return
+		}
+	}
+
+	writer.Header().Set("ETag", etag)
+	if match := request.Header.Get("If-None-Match"); len(match) > 0 && MatchETag(match, etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	this.ResponseJSON(body, writer)
+}
+
+//
+// swagger:operation GET /config/{hash}/versions configVersions
+//
+// 获取spin360 配置的历史版本列表
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetConfigVersions(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	list, err := worker.GetConfigVersions(hash)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(list, writer)
+}
+
+//
+// swagger:operation POST /config/{hash}/rollback configRollback
+//
+// 将spin360 配置回滚到指定版本（以新版本保存），返回配置文件的URL
+//
+// ---
+// consumes:
+//   - application/json
+//   - multipart/form-data
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   required: true
+//   description: 回滚目标版本号
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 当前配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) RollbackConfig(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	version, err := this.getVersionParam(request)
+	if err != nil || version <= 0 {
+		this.ResponseError(errors.New("missing or invalid version param"), writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	url, etag, err := worker.RollbackConfig(hash, version, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation GET /vr360/config/{hash}/versions vr360ConfigVersions
+//
+// 获取VR360 配置的历史版本列表
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetVR360ConfigVersions(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	list, err := worker.GetVR360ConfigVersions(hash)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(list, writer)
+}
+
+//
+// swagger:operation POST /vr360/config/{hash}/rollback vr360ConfigRollback
+//
+// 将VR360 配置回滚到指定版本（以新版本保存），返回配置文件的URL
+//
+// ---
+// consumes:
+//   - application/json
+//   - multipart/form-data
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   required: true
+//   description: 回滚目标版本号
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 当前配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) RollbackVR360Config(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	version, err := this.getVersionParam(request)
+	if err != nil || version <= 0 {
+		this.ResponseError(errors.New("missing or invalid version param"), writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	url, etag, err := worker.RollbackVR360Config(hash, version, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation GET /config/{hash}/hotspots configHotSpots
+//
+// 获取spin360 配置的热点列表
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// responses:
+//   200:
+//     description: OK, ETag 响应头为配置内容的版本标识
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) ListHotSpots(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	list, etag, err := worker.ListHotSpots(hash)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(list, writer)
+}
+
+//
+// swagger:operation POST /config/{hash}/hotspots hotSpotParams
+//
+// 向spin360 配置添加热点，未提供 id 时自动生成，返回保存后的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: Body
+//   in: body
+//   description: 热点
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) AddHotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	hotSpot := new(PageHotSpot)
+	err := decoder.Decode(hotSpot)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	etag, err := worker.AddHotSpot(hash, hotSpot, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(hotSpot, writer)
+}
+
+//
+// swagger:operation PUT /config/{hash}/hotspots/{id} hotSpotParams
+//
+// 替换spin360 配置中指定 id 的热点，返回保存后的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: id
+//   in: path
+//   description: 热点ID
+// - name: Body
+//   in: body
+//   description: 热点
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) UpdateHotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+	id := params["id"]
+
+	decoder := json.NewDecoder(request.Body)
+	hotSpot := new(PageHotSpot)
+	err := decoder.Decode(hotSpot)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	etag, err := worker.UpdateHotSpot(hash, id, hotSpot, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(hotSpot, writer)
+}
+
+//
+// swagger:operation DELETE /config/{hash}/hotspots/{id} configHotSpots
+//
+// 删除spin360 配置中指定 id 的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: id
+//   in: path
+//   description: 热点ID
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) DeleteHotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+	id := params["id"]
+
+	worker := this.newWorker(request)
+	etag, err := worker.DeleteHotSpot(hash, id, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(id, writer)
+}
+
+//
+// swagger:operation PATCH /config/{hash} jsonPatchParams
+//
+// 使用 RFC 6902 JSON Patch 局部修改spin360 配置，返回修改后的配置
+//
+// ---
+// consumes:
+//   - application/json-patch+json
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: Body
+//   in: body
+//   description: JSON Patch 操作数组
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   400:
+//     description: Invalid patch document
+//   404:
+//     description: Not found
+//   409:
+//     description: Conflict, test 操作不匹配
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Patch 无法应用或结果校验失败
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) PatchConfig(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	patch := make([]*JSONPatchOperation, 0)
+	err := decoder.Decode(&patch)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	conf, etag, err := worker.PatchConfig(hash, patch, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(conf, writer)
+}
+
+//
+// swagger:operation GET /vr360/config/{hash}/hotspots vr360HotSpots
+//
+// 获取VR360 配置的热点列表
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// responses:
+//   200:
+//     description: OK, ETag 响应头为配置内容的版本标识
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) ListVR360HotSpots(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	list, etag, err := worker.ListVR360HotSpots(hash)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(list, writer)
+}
+
+//
+// swagger:operation POST /vr360/config/{hash}/hotspots vr360HotSpotParams
+//
+// 向VR360 配置添加热点，未提供 id 时自动生成，返回保存后的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: Body
+//   in: body
+//   description: 热点
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) AddVR360HotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	hotSpot := new(PannellumHotSpot)
+	err := decoder.Decode(hotSpot)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	etag, err := worker.AddVR360HotSpot(hash, hotSpot, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(hotSpot, writer)
+}
+
+//
+// swagger:operation PUT /vr360/config/{hash}/hotspots/{id} vr360HotSpotParams
+//
+// 替换VR360 配置中指定 id 的热点，返回保存后的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: id
+//   in: path
+//   description: 热点ID
+// - name: Body
+//   in: body
+//   description: 热点
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) UpdateVR360HotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+	id := params["id"]
+
+	decoder := json.NewDecoder(request.Body)
+	hotSpot := new(PannellumHotSpot)
+	err := decoder.Decode(hotSpot)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	etag, err := worker.UpdateVR360HotSpot(hash, id, hotSpot, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(hotSpot, writer)
+}
+
+//
+// swagger:operation DELETE /vr360/config/{hash}/hotspots/{id} vr360HotSpots
+//
+// 删除VR360 配置中指定 id 的热点
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: id
+//   in: path
+//   description: 热点ID
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) DeleteVR360HotSpot(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+	id := params["id"]
+
+	worker := this.newWorker(request)
+	etag, err := worker.DeleteVR360HotSpot(hash, id, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(id, writer)
+}
+
+//
+// swagger:operation PATCH /vr360/config/{hash} jsonPatchParams
+//
+// 使用 RFC 6902 JSON Patch 局部修改VR360 配置，返回修改后的配置
+//
+// ---
+// consumes:
+//   - application/json-patch+json
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: Body
+//   in: body
+//   description: JSON Patch 操作数组
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   400:
+//     description: Invalid patch document
+//   404:
+//     description: Not found
+//   409:
+//     description: Conflict, test 操作不匹配
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Patch 无法应用或结果校验失败
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) PatchVR360Config(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	patch := make([]*JSONPatchOperation, 0)
+	err := decoder.Decode(&patch)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	conf, etag, err := worker.PatchVR360Config(hash, patch, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(conf, writer)
+}
+
+//
+// swagger:operation GET /config/{hash}/export exportConfig
+//
+// 导出 spin360 配置及其引用的全部图片为 zip 包，包内含 manifest.json、config.json 与 assets 目录，可通过 /config/import 导入其他环境
+//
+// ---
+// produces:
+//   - application/zip
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   description: 历史版本号，不提供即导出当前配置
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) ExportConfig(writer http.ResponseWriter, request *http.Request) {
+	this.exportConfig(writer, request, (*Worker).ExportConfig)
+}
+
+//
+// swagger:operation GET /vr360/config/{hash}/export exportVR360Config
+//
+// 导出 vr360 配置及全景图、预览图与全部 multires 分片为 zip 包，可通过 /config/import 导入其他环境
+//
+// ---
+// produces:
+//   - application/zip
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: version
+//   type: integer
+//   in: query
+//   description: 历史版本号，不提供即导出当前配置
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) ExportVR360Config(writer http.ResponseWriter, request *http.Request) {
+	this.exportConfig(writer, request, (*Worker).ExportVR360Config)
+}
+
+func (this *HTTPService) exportConfig(writer http.ResponseWriter, request *http.Request,
+	export func(*Worker, context.Context, string, int) (io.Reader, error)) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	version, err := this.getVersionParam(request)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Minute*30))
+	defer cancel()
+
+	worker := this.newWorker(request)
+	reader, err := export(worker, ctx, hash, version)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.streamFile(reader, fmt.Sprintf("%s.zip", hash), writer)
+}
+
+//
+// swagger:operation POST /config/import importConfig
+//
+// 导入 export 接口生成的 zip 包: 资源上传到当前存储的 {hash}/ 目录，配置中的URL改写为新地址后保存为新版本
+//
+// ---
+// consumes:
+//   - multipart/form-data
+// produces:
+//   - application/json
+// parameters:
+// - name: bundle
+//   type: file
+//   in: formData
+//   required: true
+//   description: 导出的 zip 包
+// - name: hash
+//   type: string
+//   in: formData
+//   description: 导入后的配置hash，不提供时沿用导出时的hash
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 覆盖已有配置时提供 GET 返回的 ETag, 配置已被他人修改则返回 412
+// responses:
+//   200:
+//     description: OK
+//     schema:
+//       $ref: "#/definitions/BundleImportResult"
+//   400:
+//     description: Invalid bundle, 包括解压后资源超过 download.max_size
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   429:
+//     description: Too many requests, 超出频率限制或租户每日配额, Retry-After 为可重试秒数
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) ImportConfig(writer http.ResponseWriter, request *http.Request) {
+	request.ParseMultipartForm(32 << 20)
+	bundle, header, err := this.formFile(request, "bundle")
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+	defer bundle.Close()
+
+	worker := this.newWorker(request)
+	result, err := worker.ImportBundle(bundle, header.Size, request.FormValue("hash"), request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+	this.startJob(request)
+	writer.Header().Set("ETag", result.Version.ETag)
+
+	this.ResponseJSON(result, writer)
+}
+
+//
+// swagger:operation POST /config/{hash}/migrate migrateConfig
+//
+// 将已保存的 spin360 配置坐标改写为数值 normalized 坐标, 像素坐标按所在图片尺寸换算，返回改写后的配置
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 配置hash
+// - name: If-Match
+//   type: string
+//   in: header
+//   description: 配置的 ETag, 不匹配时返回 412
+// responses:
+//   200:
+//     description: OK
+//   404:
+//     description: Not found
+//   412:
+//     description: Precondition failed, 配置已被修改
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) MigrateConfig(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	conf, etag, err := worker.MigrateConfig(hash, request.Header.Get("If-Match"))
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	writer.Header().Set("ETag", etag)
+	this.ResponseJSON(conf, writer)
+}
+
+//
+// swagger:operation POST /config/track hotSpotTrackParams
+//
+// 根据一个或两个关键帧坐标生成热点在所有帧上的坐标，可选使用模板匹配修正
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: 关键帧与插值参数
+// responses:
+//   200:
+//     description: OK, data 为完整的坐标数组
+//   404:
+//     description: Config not found
+//   422:
+//     description: Validation failed, data 为字段错误列表
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) TrackHotSpot(writer http.ResponseWriter, request *http.Request) {
+	decoder := json.NewDecoder(request.Body)
+	req := new(HotSpotTrackRequest)
+	err := decoder.Decode(req)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	list, err := worker.TrackHotSpot(req)
+	if err != nil {
+		this.ResponseConfigError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(list, writer)
+}
+
+//
+// swagger:operation POST /vr360/tour/{hash} tourParams
+//
+// 创建或保存多场景导览配置，返回配置文件的URL
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: Pannellum 导览配置，创建时可为空
+// - name: hash
+//   type: string
+//   in: path
+//   description: 导览hash, 如提供即更新已有导览（POST /vr360/tour 创建新导览）
+// responses:
+//   200:
+//     description: OK
+//   400:
+//     description: Invalid tour
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) SaveTour(writer http.ResponseWriter, request *http.Request) {
+	tour := NewPannellumTour()
+	decoder := json.NewDecoder(request.Body)
+	err := decoder.Decode(tour)
+	if err != nil && err != io.EOF {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	hashArgs := make([]string, 0)
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if ok {
+		hashArgs = append(hashArgs, hash)
+	}
+
+	err = tour.Validate()
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	worker := this.newWorker(request)
+	url, err := worker.SaveTour(tour, hashArgs...)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation GET /vr360/tour/{hash} getTour
+//
+// 获取多场景导览配置（Pannellum tour 格式）
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: hash
+//   in: path
+//   description: 导览hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetTour(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	tour, err := worker.GetTour(hash)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(tour, writer)
+}
+
+//
+// swagger:operation POST /vr360/tour/{hash}/scene tourSceneParams
+//
+// 将 /vr360/s3 生成的全景图加入导览作为场景，返回导览配置URL
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: 场景参数
+// - name: hash
+//   type: string
+//   in: path
+//   required: true
+//   description: 导览hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) AddTourScene(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	scene := new(TourSceneRequest)
+	err := decoder.Decode(scene)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	url, err := worker.AddTourScene(hash, scene)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation POST /vr360/tour/{hash}/link tourLinkParams
+//
+// 在两个场景之间添加 scene 类型热点，返回导览配置URL
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: Body
+//   in: body
+//   description: 场景链接参数
+// - name: hash
+//   type: string
+//   in: path
+//   required: true
+//   description: 导览hash
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) LinkTourScenes(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	hash, ok := params["hash"]
+	if !ok {
+		this.ResponseError(errors.New("missing hash param"), writer, 500)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	link := new(TourLinkRequest)
+	err := decoder.Decode(link)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	worker := this.newWorker(request)
+	url, err := worker.LinkTourScenes(hash, link)
+	if err != nil {
+		this.ResponseStorageError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(url, writer)
+}
+
+//
+// swagger:operation GET /oss/params OSSUploadParams
+//
+// 获取 OSS web直传参数，配置 oss_upload.callback_url 时上传完成后 OSS 回调 /oss/callback
+//
+// ---
+// consumes:
+//   - application/json
+// produces:
+//   - application/json
+// parameters:
+// - name: filename
+//   in: query
+//   required: true
+//   description: 待上传文件名
+// responses:
+//   200:
+//     description: OK
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetOSSUploadParams(writer http.ResponseWriter, request *http.Request) {
+	result, err := this.ossUploadParams(request)
+	if err != nil {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	this.ResponseJSON(result, writer)
+}
+
+func (this *HTTPService) ossUploadParams(request *http.Request) (*OSSWebFormMultipart, error) {
+	conf := this.requestConfig(request)
+	oss, err := NewOSSStorage(conf.OSS)
+	if err != nil {
+		return nil, err
+	}
+
+	return oss.GenerateFormMultipart(request.FormValue("filename"), 1*time.Hour,
+		conf.OSSUpload.callbackURL(GetTenant(request.Context())), conf.OSSUpload)
+}
+
+//
+// swagger:operation GET /s3/params S3UploadParams
+//
+// 获取 S3 浏览器直传参数（预签名 POST 表单），上传完成后可通过 /s3/key 处理
+//
+// ---
+// produces:
+//   - application/json
+// parameters:
+// - name: filename
+//   in: query
+//   required: true
+//   description: 待上传文件名
+// - name: contentType
+//   in: query
+//   description: 文件类型，配置 s3_upload.content_types 时必填且须匹配
+// responses:
+//   200:
+//     description: OK
+//     schema:
+//       $ref: "#/definitions/S3PresignedPost"
+//   400:
+//     description: 文件类型不允许
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetS3UploadParams(writer http.ResponseWriter, request *http.Request) {
+	result, err := this.s3UploadParams(request)
+	if err != nil {
+		this.ResponseUploadParamsError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(result, writer)
+}
+
+func (this *HTTPService) s3UploadParams(request *http.Request) (*S3PresignedPost, error) {
+	conf := this.requestConfig(request)
+	if conf.S3 == nil {
+		return nil, errors.New("s3 is not configured")
+	}
+	s3 := &S3Storage{Conf: conf.S3}
+
+	return s3.PresignPost(request.FormValue("filename"), request.FormValue("contentType"), conf.S3Upload, time.Now())
+}
+
+//
+// swagger:operation GET /upload/params uploadParams
+//
+// 获取当前存储（配置 source_storage）的浏览器直传参数，storage 为 oss 时同 /oss/params，为 s3 时同 /s3/params
+//
+// ---
+// produces:
+//   - application/json
+// parameters:
+// - name: filename
+//   in: query
+//   required: true
+//   description: 待上传文件名
+// - name: contentType
+//   in: query
+//   description: 文件类型，仅 s3 使用
+// - name: storage
+//   in: query
+//   enum: [s3, oss]
+//   description: 指定存储，默认为配置 source_storage
+// responses:
+//   200:
+//     description: OK
+//     schema:
+//       $ref: "#/definitions/DirectUploadParams"
+//   400:
+//     description: 存储未配置或文件类型不允许
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) GetUploadParams(writer http.ResponseWriter, request *http.Request) {
+	backend, err := this.newWorker(request).storageBackend(request.FormValue("storage"))
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	result := &DirectUploadParams{Storage: backend}
+	if backend == STORAGE_OSS {
+		result.Params, err = this.ossUploadParams(request)
+	} else {
+		result.Params, err = this.s3UploadParams(request)
+	}
+	if err != nil {
+		this.ResponseUploadParamsError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(result, writer)
+}
+
+//
+// swagger:operation POST /oss/callback ossCallback
 //
-// 获取VR360 配置
+// 阿里云 OSS 直传完成回调，校验 OSS 签名后记录上传文件，配置 oss_upload.job 时自动开始处理任务，
+// 响应经 OSS 返回给上传客户端，包含任务ID；对象已有记录时（如回调被重放）直接返回该记录，不再开始任务
 //
 // ---
 // consumes:
@@ -572,73 +2748,476 @@ func (this *HTTPService) GetConfig(writer http.ResponseWriter, request *http.Req
 // produces:
 //   - application/json
 // parameters:
-// - name: hash
-//   in: path
-//   description: 配置hash
+// - name: tenant
+//   in: query
+//   description: 租户，由 /oss/params 生成的回调地址携带
 // responses:
 //   200:
 //     description: OK
+//     schema:
+//       $ref: "#/definitions/OSSUploadRecord"
+//   400:
+//     description: 文件大小或类型不符合 oss_upload 配置
+//   403:
+//     description: 签名无效或对象不属于该租户
+//   429:
+//     description: 超出租户每日配额
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) GetVR360Config(writer http.ResponseWriter, request *http.Request) {
-	params := mux.Vars(request)
-	hash, ok := params["hash"]
-	if !ok {
-		this.ResponseError(errors.New("missing hash param"), writer, 500)
+func (this *HTTPService) OSSCallback(writer http.ResponseWriter, request *http.Request) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Error(err)
+		this.ResponseError(err, writer, 500)
+		return
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	_, err = VerifyWebUploadCallback(request)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusForbidden)
+		return
+	}
+
+	tenant := request.URL.Query().Get("tenant")
+	conf, err := this.config.ForTenant(tenant)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusForbidden)
+		return
+	}
+	if conf.OSS == nil {
+		this.ResponseError(errors.New("aliyun-oss is not configured"), writer, 500)
+		return
+	}
+
+	callback := new(OSSCallbackBody)
+	err = json.Unmarshal(body, callback)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+	record, err := NewOSSUploadRecord(conf.OSS, callback)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusForbidden)
+		return
+	}
+	err = conf.OSSUpload.Check(callback)
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
 		return
 	}
 
-	worker := NewWorker(this.config)
+	request = request.WithContext(withTenant(request.Context(), &requestTenant{name: tenant, conf: conf}))
+	worker := this.newWorker(request)
 
-	conf, err := worker.GetVR360Config(hash)
+	// a signed callback can be replayed, only the first one of an object
+	// starts the job and counts against the quota
+	this.ossLock.Lock()
+	defer this.ossLock.Unlock()
+	existing, err := worker.LoadOSSUploadRecord(record.Key)
+	if err == nil {
+		this.ResponseJSON(existing, writer)
+		return
+	}
+	if err != ErrObjectNotFound {
+		this.ResponseError(err, writer, 500)
+		return
+	}
+
+	var task *Task
+	if conf.OSSUpload != nil && len(conf.OSSUpload.Job) > 0 {
+		if quota := this.config.TenantQuota(tenant); quota != nil {
+			now := time.Now()
+			usage, err := this.quotas.StartJob(tenant, quota, record.Size, now)
+			setQuotaHeaders(writer, quota, usage)
+			if err != nil {
+				this.ResponseError(err, writer, http.StatusTooManyRequests)
+				return
+			}
+			this.quotas.AddBytes(tenant, record.Size, now)
+		}
+
+		task = this.createTask(request, conf.OSSUpload.Job)
+		record.Job = conf.OSSUpload.Job
+		record.Task = task.ID
+	}
+
+	err = worker.SaveOSSUploadRecord(record)
 	if err != nil {
+		if task != nil {
+			this.RemoveTask(task.ID)
+		}
 		this.ResponseError(err, writer, 500)
 		return
 	}
 
-	this.ResponseJSON(conf, writer)
+	if task != nil {
+		go func() {
+			task.Status = STATUS_TASK_RUNNING
+			this.UpdateTaskStatus(task.ID, task)
+
+			result, err := worker.ProcessOSSUpload(record.Job, record)
+			if err != nil {
+				log.Error(err)
+				task.Status = STATUS_TASK_FAILED
+				task.Error = err.Error()
+				this.UpdateTaskStatus(task.ID, task)
+				return
+			}
+
+			task.Status = STATUS_TASK_DONE
+			task.Result = result
+			this.UpdateTaskStatus(task.ID, task)
+		}()
+	}
+
+	this.ResponseJSON(record, writer)
 }
 
 //
-// swagger:operation GET /oss/params OSSUploadParams
+// swagger:operation OPTIONS /upload uploadOptions
 //
-// 获取 OSS web直传参数
+// 断点续传上传服务能力查询（tus 1.0.0 协议）
+//
+// ---
+// responses:
+//   204:
+//     description: OK, Tus-Version、Tus-Extension、Tus-Max-Size 响应头为服务能力
+//
+//
+func (this *HTTPService) UploadOptions(writer http.ResponseWriter, request *http.Request) {
+	this.setTusHeaders(writer)
+	writer.Header().Set("Tus-Version", TUS_VERSION)
+	writer.Header().Set("Tus-Extension", TUS_EXTENSIONS)
+	writer.Header().Set("Tus-Max-Size", strconv.FormatInt(this.uploads.MaxSize(), 10))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+//
+// swagger:operation POST /upload createUpload
+//
+// 创建断点续传上传（tus 1.0.0 creation 扩展），完成后可在 /split、/s3、/vr360、/vr360/s3、/vr360/video 等接口以 {字段}_upload_id（如 video_upload_id）代替上传文件
 //
 // ---
-// consumes:
-//   - application/json
-// produces:
-//   - application/json
 // parameters:
-// - name: filename
-//   in: query
+// - name: Tus-Resumable
+//   type: string
+//   in: header
 //   required: true
-//   description: 待上传文件名
+//   description: 协议版本 1.0.0
+// - name: Upload-Length
+//   type: integer
+//   in: header
+//   required: true
+//   description: 文件总字节数
+// - name: Upload-Metadata
+//   type: string
+//   in: header
+//   description: 逗号分隔的 "key base64(value)" 列表，支持 filename、filetype
+// responses:
+//   201:
+//     description: Created, Location 响应头为上传地址
+//   400:
+//     description: Invalid Upload-Length or Upload-Metadata
+//   412:
+//     description: 不支持的 Tus-Resumable 版本
+//   413:
+//     description: 超出最大上传大小
+//   429:
+//     description: Upload-Length 超出租户每日配额
+//   500:
+//     description: Error
+//
+//
+func (this *HTTPService) CreateUpload(writer http.ResponseWriter, request *http.Request) {
+	if !this.checkTusVersion(writer, request) {
+		return
+	}
+
+	length, err := strconv.ParseInt(request.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		this.ResponseError(errors.New("invalid Upload-Length"), writer, http.StatusBadRequest)
+		return
+	}
+	metadata, err := ParseUploadMetadata(request.Header.Get("Upload-Metadata"))
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+	// uploads referenced by upload_id are not counted again by Throttle
+	err = this.newRequestQuota(writer, request).Check(length, time.Now())
+	if err != nil {
+		this.ResponseError(err, writer, http.StatusTooManyRequests)
+		return
+	}
+
+	info, err := this.uploads.Create(length, metadata, GetTenant(request.Context()))
+	if err != nil {
+		this.ResponseUploadError(err, writer)
+		return
+	}
+
+	writer.Header().Set("Location", fmt.Sprintf("/upload/%s", info.Id))
+	writer.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	writer.WriteHeader(http.StatusCreated)
+}
+
+//
+// swagger:operation HEAD /upload/{id} headUpload
+//
+// 查询断点续传上传的已接收字节数
+//
+// ---
+// parameters:
+// - name: id
+//   in: path
+//   description: 上传ID
+// - name: Tus-Resumable
+//   type: string
+//   in: header
+//   required: true
+//   description: 协议版本 1.0.0
 // responses:
 //   200:
-//     description: OK
+//     description: OK, Upload-Offset 响应头为已接收字节数
+//   404:
+//     description: Not found
+//
+//
+func (this *HTTPService) HeadUpload(writer http.ResponseWriter, request *http.Request) {
+	if !this.checkTusVersion(writer, request) {
+		return
+	}
+
+	info, err := this.uploads.Info(mux.Vars(request)["id"], GetTenant(request.Context()))
+	if err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	writer.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	writer.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	if len(info.Metadata) > 0 {
+		writer.Header().Set("Upload-Metadata", EncodeUploadMetadata(info.Metadata))
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+//
+// swagger:operation PATCH /upload/{id} patchUpload
+//
+// 从 Upload-Offset 处续传文件内容，中断后先 HEAD 查询偏移再继续
+//
+// ---
+// consumes:
+//   - application/offset+octet-stream
+// parameters:
+// - name: id
+//   in: path
+//   description: 上传ID
+// - name: Tus-Resumable
+//   type: string
+//   in: header
+//   required: true
+//   description: 协议版本 1.0.0
+// - name: Upload-Offset
+//   type: integer
+//   in: header
+//   required: true
+//   description: 本次内容的起始偏移，须等于已接收字节数
+// responses:
+//   204:
+//     description: OK, Upload-Offset 响应头为新的已接收字节数
+//   404:
+//     description: Not found
+//   409:
+//     description: Upload-Offset 与已接收字节数不符
+//   413:
+//     description: 内容超出 Upload-Length
+//   415:
+//     description: Content-Type 须为 application/offset+octet-stream
+//   423:
+//     description: 该上传正在被写入
+//   429:
+//     description: 超出租户每日字节配额
 //   500:
 //     description: Error
 //
 //
-func (this *HTTPService) GetOSSUploadParams(writer http.ResponseWriter, request *http.Request) {
-	filename := request.FormValue("filename")
+func (this *HTTPService) PatchUpload(writer http.ResponseWriter, request *http.Request) {
+	if !this.checkTusVersion(writer, request) {
+		return
+	}
+	if request.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		this.ResponseError(errors.New("Content-Type must be application/offset+octet-stream"), writer, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(request.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		this.ResponseError(errors.New("invalid Upload-Offset"), writer, http.StatusBadRequest)
+		return
+	}
 
-	oss, err := NewOSSStorage(this.config.OSS)
+	body := this.newRequestQuota(writer, request).ReadCloser(request.Body)
+	info, err := this.uploads.Append(mux.Vars(request)["id"], GetTenant(request.Context()), offset, body)
+	if info != nil {
+		writer.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		writer.Header().Set("Upload-Expires", info.Expires.Format(http.TimeFormat))
+	}
 	if err != nil {
-		this.ResponseError(err, writer, 500)
+		this.ResponseUploadError(err, writer)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+//
+// swagger:operation DELETE /upload/{id} deleteUpload
+//
+// 删除断点续传上传（tus 1.0.0 termination 扩展）
+//
+// ---
+// parameters:
+// - name: id
+//   in: path
+//   description: 上传ID
+// - name: Tus-Resumable
+//   type: string
+//   in: header
+//   required: true
+//   description: 协议版本 1.0.0
+// responses:
+//   204:
+//     description: OK
+//   404:
+//     description: Not found
+//
+//
+func (this *HTTPService) DeleteUpload(writer http.ResponseWriter, request *http.Request) {
+	if !this.checkTusVersion(writer, request) {
 		return
 	}
 
-	result, err := oss.GenerateFormMultipart(filename, 1*time.Hour, "")
+	err := this.uploads.Delete(mux.Vars(request)["id"], GetTenant(request.Context()))
 	if err != nil {
-		this.ResponseError(err, writer, 500)
+		this.ResponseUploadError(err, writer)
 		return
 	}
 
-	this.ResponseJSON(result, writer)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (this *HTTPService) setTusHeaders(writer http.ResponseWriter) {
+	writer.Header().Set("Tus-Resumable", TUS_VERSION)
+}
+
+func (this *HTTPService) checkTusVersion(writer http.ResponseWriter, request *http.Request) bool {
+	this.setTusHeaders(writer)
+	if request.Header.Get("Tus-Resumable") != TUS_VERSION {
+		writer.Header().Set("Tus-Version", TUS_VERSION)
+		this.ResponseError(errors.New("unsupported Tus-Resumable version"), writer, http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+//
+// swagger:operation GET /metrics metrics
+//
+// Prometheus 指标：任务状态及队列长度、ffmpeg/ffprobe/nona 进程耗时及退出码、各存储上传字节数及耗时、各接口请求耗时，
+// 配置 auth 时需要 metrics scope
+//
+// ---
+// produces:
+//   - text/plain
+// responses:
+//   200:
+//     description: OK
+//   401:
+//     description: Unauthorized
+//   403:
+//     description: Forbidden, 缺少 metrics scope
+//
+//
+func (this *HTTPService) Metrics(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	this.WriteMetrics(writer)
+}
+
+//
+// swagger:operation GET /healthz health healthz
+//
+// 存活检查，服务可以响应即返回 200
+//
+// ---
+// produces:
+//   - application/json
+// responses:
+//   200:
+//     description: OK
+//     schema:
+//       "$ref": "#/definitions/HealthStatus"
+//
+//
+func (this *HTTPService) Healthz(writer http.ResponseWriter, request *http.Request) {
+	this.ResponseJSON(&HealthStatus{Status: HEALTH_STATUS_OK}, writer)
+}
+
+//
+// swagger:operation GET /readyz health readyz
+//
+// 就绪检查：ffmpeg、ffprobe、nona 可执行且版本符合要求，临时目录可写且剩余空间足够，存储可访问（HEAD 哨兵 key）
+//
+// 结果缓存 5 秒；配置 auth 时只有带 metrics scope 的调用方能看到 checks，其他调用方只返回 status
+//
+// ---
+// produces:
+//   - application/json
+// responses:
+//   200:
+//     description: OK
+//     schema:
+//       "$ref": "#/definitions/HealthStatus"
+//   503:
+//     description: 未就绪，有权限时 checks 中为失败原因
+//     schema:
+//       "$ref": "#/definitions/HealthStatus"
+//
+//
+func (this *HTTPService) Readyz(writer http.ResponseWriter, request *http.Request) {
+	status := this.health.Get(GetTenant(request.Context()), time.Now(), this.newWorker(request).Readiness)
+	if !this.canReadHealthChecks(request) {
+		status = &HealthStatus{Status: status.Status}
+	}
+	if status.Status != HEALTH_STATUS_OK {
+		writer.Header().Add("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(writer).Encode(&ServiceResult{Data: status, Error: "not ready", Status: false})
+		return
+	}
+
+	this.ResponseJSON(status, writer)
+}
+
+// canReadHealthChecks tells if the caller may see versions, free space and
+// storage errors, /readyz skips authentication so credentials are checked
+// here and invalid ones only hide the checks.
+func (this *HTTPService) canReadHealthChecks(request *http.Request) bool {
+	if this.config.Auth == nil {
+		return true
+	}
+	if this.auth == nil {
+		return false
+	}
+
+	principal, err := this.auth.Authenticate(request)
+
+	return err == nil && principal.HasScope(SCOPE_METRICS)
 }
 
 // swagger:operation GET /task task
@@ -667,7 +3246,8 @@ func (this *HTTPService) GetTask(writer http.ResponseWriter, request *http.Reque
 	TaskID := request.FormValue("id")
 
 	task, ok := this.tasks[TaskID]
-	if !ok {
+	// tasks of other tenants are reported as missing
+	if !ok || task.Tenant != GetTenant(request.Context()) {
 		this.ResponseError(errors.New("task not found"), writer, 500)
 		return
 	}
@@ -675,7 +3255,7 @@ func (this *HTTPService) GetTask(writer http.ResponseWriter, request *http.Reque
 	this.ResponseJSON(&task, writer)
 }
 
-func (this *HTTPService) createTask() (*Task) {
+func (this *HTTPService) createTask(request *http.Request, kind string) *Task {
 	tid := fmt.Sprintf("%s", uuid.NewV4())
 
 	this.taskLock <- true
@@ -686,13 +3266,22 @@ func (this *HTTPService) createTask() (*Task) {
 	task := &Task{
 		ID:     tid,
 		Status: STATUS_TASK_STARTED,
+		Kind:   kind,
+		Tenant: GetTenant(request.Context()),
 	}
+	taskTransitions.Add(1, kind, task.Status)
+	this.startJob(request)
 
 	this.tasks[tid] = task
 
 	return task
 }
 
+// startJob charges the job of a throttled request to the tenant quota.
+func (this *HTTPService) startJob(request *http.Request) {
+	getRequestQuota(request.Context()).StartJob()
+}
+
 func (this *HTTPService) UpdateTaskStatus(uuid string, task *Task) {
 	this.taskLock <- true
 	defer func() {
@@ -700,6 +3289,7 @@ func (this *HTTPService) UpdateTaskStatus(uuid string, task *Task) {
 	}()
 
 	this.tasks[uuid] = task
+	taskTransitions.Add(1, task.Kind, task.Status)
 }
 
 func (this *HTTPService) RemoveTask(uuid string) {
@@ -728,6 +3318,95 @@ func (this *HTTPService) ResponseError(err error, writer http.ResponseWriter, St
 	encoder.Encode(serverError)
 }
 
+func (this *HTTPService) ResponseStorageError(err error, writer http.ResponseWriter) {
+	if err == ErrObjectNotFound {
+		this.ResponseError(err, writer, http.StatusNotFound)
+		return
+	}
+	if err == ErrPreconditionFailed {
+		this.ResponseError(err, writer, http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, ErrInvalidConfigHash) {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	this.ResponseError(err, writer, 500)
+}
+
+// ResponseVR360SourceError answers 400 for panorama input the client has
+// to fix.
+func (this *HTTPService) ResponseVR360SourceError(err error, writer http.ResponseWriter) {
+	if errors.Is(err, ErrInvalidVR360Source) {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	this.ResponseError(err, writer, 500)
+}
+
+// ResponseConfigError maps the errors of config modifications to their
+// status codes.
+func (this *HTTPService) ResponseConfigError(err error, writer http.ResponseWriter) {
+	switch e := err.(type) {
+	case ValidationErrors:
+		this.ResponseValidationError(e, writer)
+		return
+	case *JSONPatchError:
+		if e.TestFailed {
+			this.ResponseError(err, writer, http.StatusConflict)
+			return
+		}
+		this.ResponseError(err, writer, http.StatusUnprocessableEntity)
+		return
+	case *BundleError:
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+	if err == ErrHotSpotNotFound {
+		this.ResponseError(err, writer, http.StatusNotFound)
+		return
+	}
+
+	this.ResponseStorageError(err, writer)
+}
+
+func (this *HTTPService) ResponseUploadParamsError(err error, writer http.ResponseWriter) {
+	if errors.Is(err, ErrContentTypeNotAllowed) {
+		this.ResponseError(err, writer, http.StatusBadRequest)
+		return
+	}
+
+	this.ResponseError(err, writer, 500)
+}
+
+func (this *HTTPService) ResponseUploadError(err error, writer http.ResponseWriter) {
+	switch err {
+	case ErrUploadNotFound:
+		this.ResponseError(err, writer, http.StatusNotFound)
+	case ErrUploadOffset:
+		this.ResponseError(err, writer, http.StatusConflict)
+	case ErrUploadTooLarge:
+		this.ResponseError(err, writer, http.StatusRequestEntityTooLarge)
+	case ErrUploadBusy:
+		this.ResponseError(err, writer, http.StatusLocked)
+	case ErrQuotaExceeded:
+		this.ResponseError(err, writer, http.StatusTooManyRequests)
+	default:
+		this.ResponseError(err, writer, 500)
+	}
+}
+
+func (this *HTTPService) ResponseValidationError(errs ValidationErrors, writer http.ResponseWriter) {
+	serverError := &ServiceResult{Error: "validation failed", Data: errs, Status: false}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusUnprocessableEntity)
+
+	encoder := json.NewEncoder(writer)
+	encoder.Encode(serverError)
+}
+
 func (this *HTTPService) ResponseJSON(src interface{}, writer http.ResponseWriter) {
 	serverResult := &ServiceResult{Data: src, Status: true}
 	writer.Header().Add("Content-Type", "application/json")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -103,3 +104,31 @@ func TestHTTPService_Split(t *testing.T) {
 
 	defer os.Remove(saveFilePath)
 }
+
+func TestHTTPService_SavePlayerConfigValidation(t *testing.T) {
+	httpServer := NewHTTP(&Config{})
+
+	body := bytes.NewBufferString(`{"page":[{"img":"https://example.com/1.png"}],"hotspot":[{"type":"unknown","coordinate":[{"index":2,"x":-0.5,"y":1}]}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/config", body)
+	writer := httptest.NewRecorder()
+
+	httpServer.getHTTPHandler().ServeHTTP(writer, req)
+
+	resp := writer.Result()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Response code is %v", resp.StatusCode)
+		t.Fail()
+		return
+	}
+
+	result := new(ServiceResult)
+	err := json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if list, ok := result.Data.([]interface{}); !ok || len(list) != 3 {
+		t.Errorf("unexpected errors %+v", result.Data)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+	// regions written with a script other than their language default
+	localeScripts = map[string]string{
+		"zh-hk": "zh-Hant",
+		"zh-mo": "zh-Hant",
+		"zh-tw": "zh-Hant",
+		"zh-cn": "zh-Hans",
+		"zh-sg": "zh-Hans",
+		"zh-my": "zh-Hans",
+		"zh":    "zh-Hans",
+	}
+)
+
+// LocalizedText maps locales to strings, a plain JSON string is kept under
+// the "" key so configs saved before localisation still decode.
+//
+// swagger:model LocalizedText
+type LocalizedText map[string]string
+
+func NewLocalizedText(text string) LocalizedText {
+	return LocalizedText{"": text}
+}
+
+func (this *LocalizedText) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*this = NewLocalizedText(text)
+		return nil
+	}
+
+	var locales map[string]string
+	err := json.Unmarshal(data, &locales)
+	if err != nil {
+		return err
+	}
+	*this = LocalizedText(locales)
+
+	return nil
+}
+
+// MarshalJSON writes untranslated texts back as plain strings.
+func (this LocalizedText) MarshalJSON() ([]byte, error) {
+	if len(this) == 0 {
+		return json.Marshal("")
+	}
+	if text, ok := this[""]; ok && len(this) == 1 {
+		return json.Marshal(text)
+	}
+
+	return json.Marshal(map[string]string(this))
+}
+
+func (this LocalizedText) IsBlank() bool {
+	for _, text := range this {
+		if len(strings.TrimSpace(text)) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsLocalized is true when the text carries at least one tagged locale.
+func (this LocalizedText) IsLocalized() bool {
+	for locale := range this {
+		if len(locale) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (this LocalizedText) lookup(locale string) (string, bool) {
+	for key, text := range this {
+		if strings.EqualFold(key, locale) {
+			return text, true
+		}
+	}
+
+	return "", false
+}
+
+// localeFallbacks lists the locales tried for lang, most specific first:
+// zh-Hant-TW, zh-Hant, zh and the script a region is written in.
+func localeFallbacks(lang string) []string {
+	list := make([]string, 0)
+	parts := strings.Split(lang, "-")
+	for i := len(parts); i > 0; i-- {
+		locale := strings.Join(parts[:i], "-")
+		list = append(list, locale)
+		if script, ok := localeScripts[strings.ToLower(locale)]; ok {
+			list = append(list, script)
+		}
+	}
+
+	return list
+}
+
+// Resolve picks the text for lang, falling back to related locales, the
+// default locale, the untagged text and finally any translation.
+func (this LocalizedText) Resolve(lang string, defaultLocale string) string {
+	candidates := make([]string, 0)
+	if len(lang) > 0 {
+		candidates = append(candidates, localeFallbacks(lang)...)
+	}
+	if len(defaultLocale) > 0 {
+		candidates = append(candidates, defaultLocale)
+	}
+	candidates = append(candidates, "")
+
+	for _, locale := range candidates {
+		if text, ok := this.lookup(locale); ok {
+			return text
+		}
+	}
+
+	keys := make([]string, 0, len(this))
+	for key := range this {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		return this[keys[0]]
+	}
+
+	return ""
+}
+
+// localize collapses the text to the resolved string, it then marshals as
+// a plain string.
+func (this *LocalizedText) localize(lang string, defaultLocale string) {
+	if len(*this) == 0 {
+		return
+	}
+	*this = NewLocalizedText(this.Resolve(lang, defaultLocale))
+}
+
+func localizeHotSpotPayload(lang string, defaultLocale string, images []*HotSpotImage, product *HotSpotProduct) {
+	for _, image := range images {
+		if image != nil {
+			image.Caption.localize(lang, defaultLocale)
+		}
+	}
+	if product != nil {
+		product.Title.localize(lang, defaultLocale)
+		product.CTAText.localize(lang, defaultLocale)
+	}
+}
+
+// Localize resolves every text of the config for lang.
+func (this *Spin360Config) Localize(lang string) {
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot == nil {
+			continue
+		}
+		hotSpot.Text.localize(lang, this.DefaultLocale)
+		localizeHotSpotPayload(lang, this.DefaultLocale, hotSpot.Images, hotSpot.Product)
+	}
+}
+
+func (this *PannellumConfig) Localize(lang string) {
+	for _, hotSpot := range this.HotSpot {
+		if hotSpot == nil {
+			continue
+		}
+		hotSpot.Text.localize(lang, this.DefaultLocale)
+		localizeHotSpotPayload(lang, this.DefaultLocale, hotSpot.Images, hotSpot.Product)
+	}
+}
+
+// Text checks a localisable text: tagged translations need a default
+// locale and must include it.
+func (this *ValidationErrors) Text(field string, text LocalizedText, defaultLocale string, required bool) {
+	if required && text.IsBlank() {
+		this.Add(field, "is required")
+		return
+	}
+	if !text.IsLocalized() {
+		return
+	}
+
+	locales := make([]string, 0, len(text))
+	for locale := range text {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		this.Locale(field, locale)
+	}
+	if len(defaultLocale) <= 0 {
+		this.Add(field, "defaultLocale is required for translated texts")
+		return
+	}
+	if _, ok := text.lookup(defaultLocale); !ok {
+		if _, ok := text[""]; !ok {
+			this.Add(field, "missing translation for default locale %s", defaultLocale)
+		}
+	}
+}
+
+func (this *ValidationErrors) Locale(field string, locale string) {
+	if len(locale) > 0 && !localePattern.MatchString(locale) {
+		this.Add(field, "invalid locale %q", locale)
+	}
+}
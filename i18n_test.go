@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLocalizedText_JSON(t *testing.T) {
+	hotSpot := new(PageHotSpot)
+	err := json.Unmarshal([]byte(`{"type":"text","text":"Hello"}`), hotSpot)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	data, _ := json.Marshal(hotSpot.Text)
+	if string(data) != `"Hello"` {
+		t.Errorf("plain text should stay a string, got %s", data)
+	}
+
+	err = json.Unmarshal([]byte(`{"type":"text","text":{"en":"Hello","zh-Hant":"你好"}}`), hotSpot)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if hotSpot.Text["zh-Hant"] != "你好" || len(hotSpot.Text) != 2 {
+		t.Errorf("unexpected text %v", hotSpot.Text)
+	}
+}
+
+func TestLocalizedText_Resolve(t *testing.T) {
+	text := LocalizedText{"en": "Hello", "zh-Hant": "你好", "zh-Hans": "你好啊"}
+	cases := map[string]string{
+		"en":         "Hello",
+		"en-GB":      "Hello",
+		"zh-TW":      "你好",
+		"zh-HK":      "你好",
+		"zh-Hant-TW": "你好",
+		"zh-CN":      "你好啊",
+		"zh":         "你好啊",
+		"fr":         "Hello",
+		"":           "Hello",
+	}
+	for lang, expected := range cases {
+		if resolved := text.Resolve(lang, "en"); resolved != expected {
+			t.Errorf("%s: expected %s, got %s", lang, expected, resolved)
+		}
+	}
+}
+
+func TestLocalizedText_Validate(t *testing.T) {
+	conf := &Spin360Config{
+		Pages: []*SpinPage{&SpinPage{ImageURL: "https://example.com/1.png"}},
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{Type: HOTSPOT_TYPE_TEXT, Text: LocalizedText{"en": "Hi", "zh-Hant": "你好"},
+				Coordinates: []*HotSpotCoordinates{&HotSpotCoordinates{X: 0.5, Y: 0.5}}},
+		},
+	}
+	errs := conf.Validate()
+	if !hasFieldError(errs, "hotspot[0].text") {
+		t.Errorf("missing default locale error in %s", errs)
+	}
+
+	conf.DefaultLocale = "en"
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+	}
+
+	conf.DefaultLocale = "zh-Hans"
+	if errs := conf.Validate(); !hasFieldError(errs, "hotspot[0].text") {
+		t.Errorf("missing translation error in %s", errs)
+	}
+
+	conf.DefaultLocale = "en"
+	conf.Localize("zh-TW")
+	data, _ := json.Marshal(conf.HotSpot[0].Text)
+	if string(data) != `"你好"` {
+		t.Errorf("unexpected localized text %s", data)
+	}
+}
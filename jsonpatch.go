@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	JSON_PATCH_ADD     = "add"
+	JSON_PATCH_REMOVE  = "remove"
+	JSON_PATCH_REPLACE = "replace"
+	JSON_PATCH_MOVE    = "move"
+	JSON_PATCH_COPY    = "copy"
+	JSON_PATCH_TEST    = "test"
+
+	JSON_PATCH_MIME_TYPE = "application/json-patch+json"
+)
+
+// RFC 6902 JSON Patch 操作
+//
+// swagger:model JSONPatchOperation
+type JSONPatchOperation struct {
+	// 操作类型
+	//
+	// enum:
+	//	- add
+	//	- remove
+	//	- replace
+	//	- move
+	//	- copy
+	//	- test
+	// required: true
+	Op string `json:"op"`
+	// 目标 JSON Pointer, 例如 /hotspot/0/coordinate/1/x
+	//
+	// required: true
+	Path string `json:"path"`
+	// move/copy 的来源 JSON Pointer
+	From string `json:"from,omitempty"`
+	// add/replace/test 的值
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatchError reports the failing operation, TestFailed is set when a
+// "test" operation did not match.
+type JSONPatchError struct {
+	Index      int
+	Op         string
+	Path       string
+	Message    string
+	TestFailed bool
+}
+
+func (this *JSONPatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %s): %s", this.Index, this.Op, this.Path, this.Message)
+}
+
+// ApplyJSONPatch applies the operations in order to a JSON document, the
+// document is left untouched if any operation fails.
+func ApplyJSONPatch(data []byte, patch []*JSONPatchOperation) ([]byte, error) {
+	var doc interface{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range patch {
+		if operation == nil {
+			return nil, &JSONPatchError{Index: i, Message: "empty operation"}
+		}
+		doc, err = applyJSONPatchOperation(doc, operation)
+		if err != nil {
+			patchErr := &JSONPatchError{Index: i, Op: operation.Op, Path: operation.Path, Message: err.Error()}
+			if err == errJSONPatchTestFailed {
+				patchErr.TestFailed = true
+			}
+			return nil, patchErr
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+var errJSONPatchTestFailed = fmt.Errorf("value does not match")
+
+func applyJSONPatchOperation(doc interface{}, operation *JSONPatchOperation) (interface{}, error) {
+	path, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case JSON_PATCH_ADD, JSON_PATCH_REPLACE, JSON_PATCH_TEST:
+		if operation.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		var value interface{}
+		err = json.Unmarshal(*operation.Value, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch operation.Op {
+		case JSON_PATCH_ADD:
+			return jsonPointerAdd(doc, path, value)
+		case JSON_PATCH_REPLACE:
+			// an empty path replaces the whole document
+			if len(path) <= 0 {
+				return value, nil
+			}
+			doc, _, err = jsonPointerRemove(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			return jsonPointerAdd(doc, path, value)
+		default:
+			current, err := jsonPointerGet(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, errJSONPatchTestFailed
+			}
+			return doc, nil
+		}
+	case JSON_PATCH_REMOVE:
+		doc, _, err = jsonPointerRemove(doc, path)
+		return doc, err
+	case JSON_PATCH_MOVE, JSON_PATCH_COPY:
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if operation.Op == JSON_PATCH_MOVE {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, fmt.Errorf("cannot move a value into one of its children")
+			}
+			doc, value, err = jsonPointerRemove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value, err = jsonPointerGet(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			value, err = copyJSONValue(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return jsonPointerAdd(doc, path, value)
+	}
+
+	return nil, fmt.Errorf("unknown op %q", operation.Op)
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if len(pointer) <= 0 {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// jsonArrayIndex parses an array token, "-" addresses the end of the array
+// and is only allowed when appending.
+func jsonArrayIndex(token string, length int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return length, nil
+	}
+	if len(token) <= 0 || len(token) > 1 && token[0] == '0' {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	max := length - 1
+	if appending {
+		max = length
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+
+	return index, nil
+}
+
+func jsonPointerChild(doc interface{}, token string) (interface{}, error) {
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", token)
+		}
+		return child, nil
+	case []interface{}:
+		index, err := jsonArrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		return container[index], nil
+	}
+
+	return nil, fmt.Errorf("cannot traverse into %q", token)
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	var err error
+	for _, token := range path {
+		doc, err = jsonPointerChild(doc, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// jsonPointerUpdate rebuilds the chain of parents after a child changed,
+// arrays may be reallocated so the new child has to be stored back.
+func jsonPointerUpdate(doc interface{}, path []string, update func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+
+	child, err := jsonPointerChild(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = jsonPointerUpdate(child, path[1:], update)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[path[0]] = child
+	case []interface{}:
+		index, _ := jsonArrayIndex(path[0], len(container), false)
+		container[index] = child
+	}
+
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) <= 0 {
+		return value, nil
+	}
+
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index, err := jsonArrayIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+
+		return nil, fmt.Errorf("cannot add %q to a scalar", token)
+	})
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) <= 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	var removed interface{}
+	doc, err := jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			removed = value
+			delete(container, token)
+			return container, nil
+		case []interface{}:
+			index, err := jsonArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = container[index]
+			return append(container[:index], container[index+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("cannot remove %q from a scalar", token)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, removed, nil
+}
+
+func copyJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+
+	return result, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func testPatch(t *testing.T, doc string, patch string) (string, error) {
+	operations := make([]*JSONPatchOperation, 0)
+	err := json.Unmarshal([]byte(patch), &operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ApplyJSONPatch([]byte(doc), operations)
+
+	return string(result), err
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	cases := []struct {
+		doc    string
+		patch  string
+		result string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"qux"}]`, `{"foo":["bar","qux"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{`{"a/b":{"m~n":1}}`, `[{"op":"test","path":"/a~1b/m~0n","value":1}]`, `{"a/b":{"m~n":1}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":"qux"}}]`, `{"baz":"qux"}`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":[1]}},{"op":"add","path":"/baz/-","value":2}]`, `{"baz":[1,2]}`},
+	}
+
+	for i, c := range cases {
+		result, err := testPatch(t, c.doc, c.patch)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		if result != c.result {
+			t.Errorf("case %d: expected %s, got %s", i, c.result, result)
+		}
+	}
+}
+
+func TestApplyJSONPatch_Errors(t *testing.T) {
+	cases := []struct {
+		patch      string
+		testFailed bool
+	}{
+		{`[{"op":"test","path":"/baz","value":"bar"}]`, true},
+		{`[{"op":"remove","path":"/missing"}]`, false},
+		{`[{"op":"replace","path":"/list/5","value":1}]`, false},
+		{`[{"op":"add","path":"/list/01","value":1}]`, false},
+		{`[{"op":"move","from":"/list","path":"/list/0"}]`, false},
+		{`[{"op":"add","path":"baz","value":1}]`, false},
+		{`[{"op":"add","path":"/baz"}]`, false},
+		{`[{"op":"frobnicate","path":"/baz"}]`, false},
+		{`[{"op":"remove","path":""}]`, false},
+	}
+
+	for i, c := range cases {
+		_, err := testPatch(t, `{"baz":"qux","list":[1,2]}`, c.patch)
+		patchErr, ok := err.(*JSONPatchError)
+		if !ok {
+			t.Errorf("case %d: expected JSONPatchError, got %v", i, err)
+			continue
+		}
+		if patchErr.TestFailed != c.testFailed {
+			t.Errorf("case %d: unexpected TestFailed %v", i, patchErr.TestFailed)
+		}
+	}
+}
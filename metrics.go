@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+var processBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800}
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	taskTransitions = NewCounterVec("spin360_task_transitions_total",
+		"Task status changes by kind and status.", "kind", "status")
+	processDuration = NewHistogramVec("spin360_process_duration_seconds",
+		"Run time of ffmpeg, ffprobe and nona processes by exit code.", processBuckets, "command", "exit_code")
+	storageBytes = NewCounterVec("spin360_storage_upload_bytes_total",
+		"Bytes uploaded per storage backend.", "backend")
+	storageDuration = NewHistogramVec("spin360_storage_request_duration_seconds",
+		"Latency of storage calls per backend, operation and result.", latencyBuckets, "backend", "operation", "result")
+	httpDuration = NewHistogramVec("spin360_http_request_duration_seconds",
+		"Latency of HTTP requests per route, method and status code.", latencyBuckets, "route", "method", "code")
+)
+
+// metricVec is a Prometheus metric family with one series per label set,
+// written in the text exposition format.
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values  []string
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+func newMetricVec(name string, help string, kind string, buckets []float64, labels []string) *metricVec {
+	return &metricVec{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+}
+
+func NewCounterVec(name string, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, "counter", nil, labels)
+}
+
+func NewGaugeVec(name string, help string, labels ...string) *metricVec {
+	return newMetricVec(name, help, "gauge", nil, labels)
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *metricVec {
+	return newMetricVec(name, help, "histogram", buckets, labels)
+}
+
+func (this *metricVec) get(values []string) *metricSeries {
+	if len(values) != len(this.labels) {
+		panic(fmt.Sprintf("%s expects %d label values, got %d", this.name, len(this.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	series, ok := this.series[key]
+	if !ok {
+		series = &metricSeries{values: values, counts: make([]uint64, len(this.buckets))}
+		this.series[key] = series
+	}
+
+	return series
+}
+
+func (this *metricVec) Add(delta float64, values ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.get(values).value += delta
+}
+
+func (this *metricVec) Set(value float64, values ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.get(values).value = value
+}
+
+func (this *metricVec) Observe(value float64, values ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	series := this.get(values)
+	for i, bound := range this.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.samples++
+}
+
+func (this *metricVec) Since(start time.Time, values ...string) {
+	this.Observe(time.Since(start).Seconds(), values...)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	if len(pairs) <= 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (this *metricVec) Write(writer io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	keys := make([]string, 0, len(this.series))
+	for key := range this.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", this.name, this.help, this.name, this.kind)
+	for _, key := range keys {
+		series := this.series[key]
+		if this.kind != "histogram" {
+			fmt.Fprintf(writer, "%s%s %s\n", this.name, formatLabels(this.labels, series.values), formatFloat(series.value))
+			continue
+		}
+		for i, bound := range this.buckets {
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", this.name,
+				formatLabels(this.labels, series.values, "le", formatFloat(bound)), series.counts[i])
+		}
+		fmt.Fprintf(writer, "%s_bucket%s %d\n", this.name, formatLabels(this.labels, series.values, "le", "+Inf"), series.samples)
+		fmt.Fprintf(writer, "%s_sum%s %s\n", this.name, formatLabels(this.labels, series.values), formatFloat(series.sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", this.name, formatLabels(this.labels, series.values), series.samples)
+	}
+}
+
+// observeProcess records a finished ffmpeg/ffprobe/nona run, -1 is a
+// process that could not be started.
+func observeProcess(bin string, start time.Time, state *os.ProcessState) {
+	code := -1
+	if state != nil {
+		code = state.ExitCode()
+	}
+	command := strings.TrimSuffix(filepath.Base(bin), filepath.Ext(bin))
+	processDuration.Since(start, command, strconv.Itoa(code))
+}
+
+func metricResult(err error) string {
+	if err == ErrObjectNotFound {
+		return "not_found"
+	}
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
+
+// meteredStorage records the uploaded bytes and call latency of an IStorage.
+type meteredStorage struct {
+	IStorage
+	backend string
+}
+
+func NewMeteredStorage(backend string, storage IStorage) IStorage {
+	return &meteredStorage{IStorage: storage, backend: backend}
+}
+
+func (this *meteredStorage) Upload(localPath string, Key string) (string, string, error) {
+	start := time.Now()
+	path, url, err := this.IStorage.Upload(localPath, Key)
+	storageDuration.Since(start, this.backend, "upload", metricResult(err))
+	if err == nil {
+		if info, statErr := os.Stat(localPath); statErr == nil {
+			storageBytes.Add(float64(info.Size()), this.backend)
+		}
+	}
+
+	return path, url, err
+}
+
+func (this *meteredStorage) PutContent(content string, Key string, opt *UploadOptions) (string, string, error) {
+	start := time.Now()
+	path, url, err := this.IStorage.PutContent(content, Key, opt)
+	storageDuration.Since(start, this.backend, "put", metricResult(err))
+	if err == nil {
+		storageBytes.Add(float64(len(content)), this.backend)
+	}
+
+	return path, url, err
+}
+
+func (this *meteredStorage) Get(Key string) (io.Reader, error) {
+	start := time.Now()
+	reader, err := this.IStorage.Get(Key)
+	storageDuration.Since(start, this.backend, "get", metricResult(err))
+
+	return reader, err
+}
+
+func (this *meteredStorage) Head(Key string) error {
+	start := time.Now()
+	err := this.IStorage.Head(Key)
+	storageDuration.Since(start, this.backend, "head", metricResult(err))
+
+	return err
+}
+
+// statusWriter keeps the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (this *statusWriter) WriteHeader(status int) {
+	this.status = status
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *statusWriter) Write(data []byte) (int, error) {
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+
+	return this.ResponseWriter.Write(data)
+}
+
+// Instrument is the router middleware recording the latency of every
+// matched route, labeled by its path template to keep the series bounded.
+func (this *HTTPService) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusWriter{ResponseWriter: writer}
+		next.ServeHTTP(recorder, request)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(request); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpDuration.Since(start, route, request.Method, strconv.Itoa(recorder.status))
+	})
+}
+
+// WriteMetrics writes the task gauges followed by every metric family.
+func (this *HTTPService) WriteMetrics(writer io.Writer) {
+	tasks := NewGaugeVec("spin360_tasks", "Tasks kept in memory by kind and status.", "kind", "status")
+	queue := NewGaugeVec("spin360_task_queue_depth", "Tasks started or running.")
+
+	this.taskLock <- true
+	depth := 0
+	for _, task := range this.tasks {
+		tasks.Add(1, task.Kind, task.Status)
+		if task.Status == STATUS_TASK_STARTED || task.Status == STATUS_TASK_RUNNING {
+			depth++
+		}
+	}
+	<-this.taskLock
+	queue.Set(float64(depth))
+
+	buffer := bufio.NewWriter(writer)
+	for _, vec := range []*metricVec{tasks, queue, taskTransitions, processDuration, storageBytes, storageDuration, httpDuration} {
+		vec.Write(buffer)
+	}
+	buffer.Flush()
+}
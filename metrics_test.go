@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricVec_Write(t *testing.T) {
+	histogram := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 5}, "route")
+	histogram.Observe(0.5, `/a"b`)
+	histogram.Observe(3, `/a"b`)
+	counter := NewCounterVec("test_total", "Test counter.")
+	counter.Add(2)
+
+	buffer := new(bytes.Buffer)
+	histogram.Write(buffer)
+	counter.Write(buffer)
+
+	expected := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/a\"b",le="1"} 1
+test_seconds_bucket{route="/a\"b",le="5"} 2
+test_seconds_bucket{route="/a\"b",le="+Inf"} 2
+test_seconds_sum{route="/a\"b"} 3.5
+test_seconds_count{route="/a\"b"} 2
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total 2
+`
+	if buffer.String() != expected {
+		t.Errorf("unexpected output:\n%s", buffer.String())
+	}
+}
+
+func TestCommandBuilder_Metrics(t *testing.T) {
+	NewBuilder("false").Run()
+
+	buffer := new(bytes.Buffer)
+	processDuration.Write(buffer)
+	if !strings.Contains(buffer.String(), `spin360_process_duration_seconds_count{command="false",exit_code="1"} 1`) {
+		t.Errorf("process not recorded:\n%s", buffer.String())
+	}
+}
+
+func TestMeteredStorage(t *testing.T) {
+	storage := NewMeteredStorage("memory", newMemoryStorage())
+	storage.PutContent("12345", "a.json", &UploadOptions{})
+	storage.Get("missing.json")
+
+	buffer := new(bytes.Buffer)
+	storageBytes.Write(buffer)
+	storageDuration.Write(buffer)
+	for _, line := range []string{
+		`spin360_storage_upload_bytes_total{backend="memory"} 5`,
+		`spin360_storage_request_duration_seconds_count{backend="memory",operation="put",result="ok"} 1`,
+		`spin360_storage_request_duration_seconds_count{backend="memory",operation="get",result="not_found"} 1`,
+	} {
+		if !strings.Contains(buffer.String(), line) {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
+
+func TestHTTPService_Metrics(t *testing.T) {
+	httpServer := NewHTTP(&Config{S3: &S3Config{}})
+	handler := httpServer.getHTTPHandler()
+
+	req := httptest.NewRequest(http.MethodGet, "/task?id=missing", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/task", nil)
+	httpServer.createTask(req, TASK_KIND_VR360)
+
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if writer.Header().Get("Content-Type") != METRICS_CONTENT_TYPE {
+		t.Errorf("unexpected content type %s", writer.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`spin360_tasks{kind="vr360",status="STARTED"} 1`,
+		`spin360_task_queue_depth 1`,
+		`spin360_http_request_duration_seconds_count{route="/task",method="GET",code="500"}`,
+	} {
+		if !strings.Contains(writer.Body.String(), line) {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
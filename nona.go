@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"errors"
+	"encoding/json"
 	"fmt"
 	uuid "github.com/satori/go.uuid"
 	_ "golang.org/x/image/tiff"
@@ -10,6 +10,7 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"os"
@@ -21,34 +22,178 @@ import (
 )
 
 var (
-	faces = []string{"face0000.tif", "face0001.tif", "face0002.tif", "face0003.tif", "face0004.tif", "face0005.tif"}
+	faces       = []string{"face0000.tif", "face0001.tif", "face0002.tif", "face0003.tif", "face0004.tif", "face0005.tif"}
 	faceLetters = []string{"f", "b", "u", "d", "l", "r"}
-	extension = ".jpg"
+	tileFormats = []string{TILE_FORMAT_JPG, TILE_FORMAT_PNG}
 )
 
 const (
-	TYPE_PANNELLUM_HOTSPOT_EMBED = "embed"
-	TYPE_PANNELLUM_HOTSPOT_TEXT  = "text"
-	TYPE_PANNELLUM_HOTSPOT_LINK  = "link"
+	TYPE_PANNELLUM_HOTSPOT_EMBED   = "embed"
+	TYPE_PANNELLUM_HOTSPOT_TEXT    = "text"
+	TYPE_PANNELLUM_HOTSPOT_LINK    = "link"
+	TYPE_PANNELLUM_HOTSPOT_SCENE   = "scene"
+	TYPE_PANNELLUM_HOTSPOT_IMAGE   = "image"
+	TYPE_PANNELLUM_HOTSPOT_VIDEO   = "video"
+	TYPE_PANNELLUM_HOTSPOT_PRODUCT = "product"
 )
 
+// css classes the viewer styles the custom hotspot types with
+var pannellumHotSpotClasses = map[string]string{
+	TYPE_PANNELLUM_HOTSPOT_IMAGE:   "pnlm-hotspot-image",
+	TYPE_PANNELLUM_HOTSPOT_VIDEO:   "pnlm-hotspot-video",
+	TYPE_PANNELLUM_HOTSPOT_PRODUCT: "pnlm-hotspot-product",
+}
+
+const (
+	TILE_FORMAT_JPG = "jpg"
+	TILE_FORMAT_PNG = "png"
+)
+
+const (
+	PREVIEW_DIR       = "preview"
+	PREVIEW_WIDTH     = 2048
+	THUMBNAIL_WIDTH   = 480
+	THUMBNAIL_HEIGHT  = 270
+	PLACEHOLDER_WIDTH = 64
+	PLACEHOLDER_SIGMA = 2.0
+	PREVIEW_FILE      = "equirectangular.jpg"
+	THUMBNAIL_FILE    = "thumbnail.jpg"
+	PLACEHOLDER_FILE  = "placeholder.jpg"
+)
+
+const (
+	DEFAULT_TILE_SIZE     = 512
+	DEFAULT_TILE_QUALITY  = 95
+	DEFAULT_FALLBACK_SIZE = 1024
+	MIN_TILE_SIZE         = 64
+	MAX_TILE_SIZE         = 4096
+	// 64px tiles of a 32768px cube face
+	MAX_TILE_LEVELS = 10
+)
+
+// swagger:model TileOptions
+type TileOptions struct {
+	// 分片尺寸（像素），须为 2 的幂
+	TileSize int `json:"tileSize"`
+	// JPEG 压缩质量 1-100
+	Quality int `json:"quality"`
+	// fallback 立方体面尺寸（像素）
+	FallbackSize int `json:"fallbackSize"`
+	// 分片图片格式, 可能值 "jpg", "png"
+	//
+	// enum:
+	//	- jpg
+	//	- png
+	Format string `json:"format"`
+}
+
+func NewTileOptions() *TileOptions {
+	return &TileOptions{
+		TileSize:     DEFAULT_TILE_SIZE,
+		Quality:      DEFAULT_TILE_QUALITY,
+		FallbackSize: DEFAULT_FALLBACK_SIZE,
+		Format:       TILE_FORMAT_JPG,
+	}
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Validate normalizes the format name and checks the options that do not
+// depend on the source image.
+func (this *TileOptions) Validate() error {
+	if strings.EqualFold(this.Format, "jpeg") {
+		this.Format = TILE_FORMAT_JPG
+	}
+	this.Format = strings.ToLower(this.Format)
+
+	supported := false
+	for _, format := range tileFormats {
+		if format == this.Format {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported tile format %q, expected one of %s",
+			this.Format, strings.Join(tileFormats, ", "))
+	}
+	if this.Quality < 1 || this.Quality > 100 {
+		return fmt.Errorf("tile quality %d out of range 1-100", this.Quality)
+	}
+	if this.TileSize < MIN_TILE_SIZE || this.TileSize > MAX_TILE_SIZE || !isPowerOfTwo(this.TileSize) {
+		return fmt.Errorf("tile size %d must be a power of two between %d and %d",
+			this.TileSize, MIN_TILE_SIZE, MAX_TILE_SIZE)
+	}
+	if this.FallbackSize < MIN_TILE_SIZE || this.FallbackSize > MAX_TILE_SIZE {
+		return fmt.Errorf("fallback size %d out of range %d-%d",
+			this.FallbackSize, MIN_TILE_SIZE, MAX_TILE_SIZE)
+	}
+
+	return nil
+}
+
+// Pyramid returns the effective tile size and level count for a cube face
+// size, using the same rule as Pannellum's generate.py.
+func (this *TileOptions) Pyramid(cubeSize int) (int, int, error) {
+	if cubeSize < 1 {
+		return 0, 0, fmt.Errorf("invalid cube size %d", cubeSize)
+	}
+
+	tileSize := this.TileSize
+	if tileSize > cubeSize {
+		tileSize = cubeSize
+	}
+	levels := int(math.Ceil(math.Log2(float64(cubeSize)/float64(tileSize)))) + 1
+	if int(math.Round(float64(cubeSize)/math.Pow(2, float64(levels-2)))) == tileSize {
+		levels -= 1
+	}
+
+	if levels > MAX_TILE_LEVELS {
+		return tileSize, levels, fmt.Errorf("cube size %d needs %d levels of %dpx tiles, at most %d are supported",
+			cubeSize, levels, tileSize, MAX_TILE_LEVELS)
+	}
+
+	return tileSize, levels, nil
+}
+
+func (this *TileOptions) Extension() string {
+	return fmt.Sprintf(".%s", this.Format)
+}
+
+func (this *TileOptions) SaveOptions() []imaging.EncodeOption {
+	return []imaging.EncodeOption{
+		imaging.JPEGQuality(this.Quality),
+	}
+}
+
 type NonaWrapper struct {
-	Bin    string
-	UseGPU bool
-	HaoV   int
+	Bin        string
+	UseGPU     bool
+	HaoV       int
 	SrcImgPath string
+	Options    *TileOptions
 }
 
 func NewNonaWrapper(ImagePath string) *NonaWrapper {
 	self := &NonaWrapper{
-		UseGPU: false,
-		HaoV: 360,
+		UseGPU:     false,
+		HaoV:       360,
 		SrcImgPath: ImagePath,
+		Options:    NewTileOptions(),
 	}
 
 	return self.GetBinPath()
 }
 
+func (this *NonaWrapper) SetOptions(opts *TileOptions) *NonaWrapper {
+	if opts != nil {
+		this.Options = opts
+	}
+
+	return this
+}
+
 func (this *NonaWrapper) SetBinPath(bin string) *NonaWrapper {
 	this.Bin = bin
 
@@ -92,45 +237,94 @@ type PannellumConfig struct {
 	// 全景图数据源类型
 	//
 	// required: true
-	Type    string              `json:"type"`
+	Type string `json:"type"`
 	// 全局图分片模式参数
-	Config  *MultiResConfig     `json:"multiRes"`
+	Config *MultiResConfig `json:"multiRes"`
 	// 未分割全景图URL
-	URL     string              `json:"panorama"`
+	URL string `json:"panorama"`
+	// 缩小的等距柱状投影预览图URL
+	Preview string `json:"preview,omitempty"`
+	// 列表页用缩略图URL
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// 模糊占位图URL
+	Placeholder string `json:"placeholder,omitempty"`
 	// 热点配置
 	//
 	HotSpot []*PannellumHotSpot `json:"hotSpots"`
+	// 默认语言, 热点文字包含多语言时必填
+	DefaultLocale string `json:"defaultLocale,omitempty"`
 }
 
 type PannellumHotSpot struct {
-	// 热点类型, 可能值 "embed", "link", "text"
+	// 热点类型, 可能值 "embed", "link", "text", "scene", "image", "video", "product"
 	//
 	// enum:
 	//	- embed
 	//	- link
 	//	- text
+	//	- scene
+	//	- image
+	//	- video
+	//	- product
 	// required: true
-	Type  string `json:"type"`
-	// Text 类型文字说明
+	Type string `json:"type"`
+	// Text 类型文字说明, 可为字符串或 locale 到文字的映射
 	//
 	// required: true
-	Text  string `json:"text"`
+	Text LocalizedText `json:"text"`
 	// Embed/Link 类型 URL
 	//
 	// required: true
-	Link  string `json:"link"`
+	Link string `json:"link"`
 	// 热点三维坐标
 	//
 	// required: true
-	Pitch float64    `json:"pitch"`
+	Pitch float64 `json:"pitch"`
 	// 热点三维坐标
 	//
 	// required: true
-	Yaw   float64    `json:"yaw"`
+	Yaw float64 `json:"yaw"`
 	// 热点ID
 	//
 	// required: true
-	Id    string `json:"id"`
+	Id string `json:"id"`
+	// Scene 类型目标场景ID
+	SceneId string `json:"sceneId,omitempty"`
+	// Scene 类型切换后的视角
+	TargetYaw *float64 `json:"targetYaw,omitempty"`
+	// Scene 类型切换后的视角
+	TargetPitch *float64 `json:"targetPitch,omitempty"`
+	// Scene 类型跳转到其他 spin360/VR360 配置, 与 sceneId 二选一
+	Target *HotSpotTarget `json:"target,omitempty"`
+	// Image 类型图集
+	Images []*HotSpotImage `json:"images,omitempty"`
+	// Video 类型视频
+	Video *HotSpotVideo `json:"video,omitempty"`
+	// Product 类型商品卡片
+	Product *HotSpotProduct `json:"product,omitempty"`
+	// Pannellum 热点 CSS class, image/video/product 类型默认为 pnlm-hotspot-{type}
+	CSSClass string `json:"cssClass,omitempty"`
+}
+
+// MarshalJSON fills in the default css class of the custom hotspot types so
+// Pannellum renders them distinctly.
+func (this PannellumHotSpot) MarshalJSON() ([]byte, error) {
+	type hotSpot PannellumHotSpot
+	if len(this.CSSClass) <= 0 || this.isDefaultClass() {
+		this.CSSClass = pannellumHotSpotClasses[this.Type]
+	}
+
+	return json.Marshal(hotSpot(this))
+}
+
+func (this PannellumHotSpot) isDefaultClass() bool {
+	for _, class := range pannellumHotSpotClasses {
+		if class == this.CSSClass {
+			return true
+		}
+	}
+
+	return false
 }
 
 type MultiResConfig struct {
@@ -144,7 +338,12 @@ type MultiResConfig struct {
 }
 
 func (this *NonaWrapper) Generate(distDir string) (*PannellumConfig, error) {
-	err := this.CopySrcToLocal(distDir)
+	err := this.Options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = this.CopySrcToLocal(distDir)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +357,13 @@ func (this *NonaWrapper) Generate(distDir string) (*PannellumConfig, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+
+	return this.GenerateFromFaces(cubeSize, distDir)
+}
+
+// GenerateFromFaces builds tiles, fallback and previews from the six cube
+// face files already present in distDir.
+func (this *NonaWrapper) GenerateFromFaces(cubeSize int, distDir string) (*PannellumConfig, error) {
 	tileSize, levels, err := this.GeneratingTiles(cubeSize, distDir)
 	if err != nil {
 		return nil, err
@@ -169,6 +374,11 @@ func (this *NonaWrapper) Generate(distDir string) (*PannellumConfig, error) {
 		return nil, err
 	}
 
+	err = this.GeneratePreview(distDir)
+	if err != nil {
+		return nil, err
+	}
+
 	this.ClearCuteFaceFiles(distDir)
 
 	return this.GenerateConfigJSON(cubeSize, levels, tileSize)
@@ -250,7 +460,10 @@ func (this *NonaWrapper) CopyToLocalFromReader(distDir string, reader io.ReadSee
 }
 
 func (this *NonaWrapper) GenerateFromReader(distDir string, reader io.ReadSeeker) (*PannellumConfig, error) {
-	this.CopyToLocalFromReader(distDir, reader)
+	err := this.CopyToLocalFromReader(distDir, reader)
+	if err != nil {
+		return nil, err
+	}
 
 	return this.Generate(distDir)
 }
@@ -258,25 +471,24 @@ func (this *NonaWrapper) GenerateFromReader(distDir string, reader io.ReadSeeker
 func (this *NonaWrapper) CreateCuteFace(TempPath string) error {
 	log.Info(`Generating cube faces...`)
 	configFilePath := filepath.Join(TempPath, `cubic.pto`)
-	args := []string{`-d`, `-o`, filepath.ToSlash(filepath.Join(TempPath, `face`)), configFilePath}
+
+	return this.Run(filepath.ToSlash(filepath.Join(TempPath, `face`)), configFilePath)
+}
+
+func (this *NonaWrapper) Run(outputPrefix string, configFilePath string) error {
+	args := []string{`-d`, `-o`, outputPrefix, configFilePath}
 	if this.UseGPU {
 		args[0] = `-g`
 	}
-	var outPipe bytes.Buffer
-	var errorPipe bytes.Buffer
-
-	cmd := exec.Command(this.Bin, args...)
-	cmd.Stdout = &outPipe
-	cmd.Stderr = &errorPipe
-	err := cmd.Run()
+	out, err := NewBuilder(this.Bin).SetParams(args...).Run()
 	if err != nil {
-		log.Error(err)
-		return errors.New(errorPipe.String())
+		return err
 	}
 
-	log.Info(outPipe.String())
+	output, _ := ioutil.ReadAll(out)
+	log.Info(string(output))
 
-	return err
+	return nil
 }
 
 func (this *NonaWrapper) ClearCuteFaceFiles(TempDir string) {
@@ -373,15 +585,12 @@ func (this *NonaWrapper) GenerateCubicConfigFile(distFileName string) (int, erro
 func (this *NonaWrapper) GeneratingTiles(cubeSize int, tempDir string) (int, int, error) {
 	log.Info(`Generating tiles...`)
 
-	tileSize := 512
-	if tileSize > cubeSize {
-		tileSize = cubeSize
+	tileSize, levels, err := this.Options.Pyramid(cubeSize)
+	if err != nil {
+		log.Error(err)
+		return tileSize, levels, err
 	}
 	tiles := int(math.Ceil(float64(cubeSize) / float64(tileSize)))
-	levels := int(math.Ceil(math.Log2(float64(cubeSize) / float64(tileSize)))) + 1
-	if int(math.Round(float64(cubeSize) / math.Pow(2, float64(levels - 2)))) == tileSize {
-		levels -= 1
-	}
 
 	for f := 0; f < 6; f++ {
 		size := cubeSize
@@ -406,13 +615,13 @@ func (this *NonaWrapper) GeneratingTiles(cubeSize int, tempDir string) (int, int
 						lower := int(math.Min(float64(i) *float64(tileSize) + float64(tileSize), float64(size)))
 
 						tile := imaging.Crop(img, image.Rect(left, upper, right, lower))
-						tilePath := filepath.Join(tempDir, fmt.Sprintf(`%d/%s%d_%d%s`, level, faceLetters[f], i, j, extension))
+						tilePath := filepath.Join(tempDir, fmt.Sprintf(`%d/%s%d_%d%s`, level, faceLetters[f], i, j, this.Options.Extension()))
 						tileDir := filepath.Dir(tilePath)
 						if _, err := os.Stat(tileDir); err != nil && os.IsNotExist(err) {
 							os.MkdirAll(tileDir, os.ModePerm)
 						}
 						if !tile.Bounds().Empty() {
-							err := imaging.Save(tile, tilePath, imaging.JPEGQuality(95))
+							err := imaging.Save(tile, tilePath, this.Options.SaveOptions()...)
 							if err != nil {
 								log.Error(err)
 								return tileSize, levels, err
@@ -433,7 +642,7 @@ func (this *NonaWrapper) GeneratingTiles(cubeSize int, tempDir string) (int, int
 
 func (this *NonaWrapper) GenerateFallback(tempDir string) error {
 	log.Info(`Generating fallback...`)
-	fallbackSize := 1024
+	fallbackSize := this.Options.FallbackSize
 
 	fallbackDir := filepath.Join(tempDir, "fallback")
 	if _, err := os.Stat(fallbackDir); err != nil && os.IsNotExist(err) {
@@ -446,10 +655,14 @@ func (this *NonaWrapper) GenerateFallback(tempDir string) error {
 			log.Error(err)
 			return err
 		}
-		img = imaging.Resize(img, fallbackSize, fallbackSize, imaging.Lanczos)
+		size := fallbackSize
+		if width := img.Bounds().Dx(); width < size {
+			size = width
+		}
+		img = imaging.Resize(img, size, size, imaging.Lanczos)
 
-		imgPath := filepath.Join(fallbackDir, fmt.Sprintf(`%s%s`, faceLetters[f], extension))
-		err = imaging.Save(img, imgPath, imaging.JPEGQuality(95))
+		imgPath := filepath.Join(fallbackDir, fmt.Sprintf(`%s%s`, faceLetters[f], this.Options.Extension()))
+		err = imaging.Save(img, imgPath, this.Options.SaveOptions()...)
 		if err != nil {
 			log.Error(err)
 			return err
@@ -459,17 +672,69 @@ func (this *NonaWrapper) GenerateFallback(tempDir string) error {
 	return nil
 }
 
+func (this *NonaWrapper) GeneratePreview(tempDir string) error {
+	log.Info(`Generating preview...`)
+
+	previewDir := filepath.Join(tempDir, PREVIEW_DIR)
+	if _, err := os.Stat(previewDir); err != nil && os.IsNotExist(err) {
+		os.MkdirAll(previewDir, os.ModePerm)
+	}
+	quality := imaging.JPEGQuality(this.Options.Quality)
+
+	src, err := imaging.Open(this.SrcImgPath)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	width := src.Bounds().Dx()
+	if width > PREVIEW_WIDTH {
+		width = PREVIEW_WIDTH
+	}
+	preview := imaging.Resize(src, width, width/2, imaging.Lanczos)
+	err = imaging.Save(preview, filepath.Join(previewDir, PREVIEW_FILE), quality)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	placeholder := imaging.Resize(preview, PLACEHOLDER_WIDTH, PLACEHOLDER_WIDTH/2, imaging.Box)
+	placeholder = imaging.Blur(placeholder, PLACEHOLDER_SIGMA)
+	err = imaging.Save(placeholder, filepath.Join(previewDir, PLACEHOLDER_FILE), quality)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// the front cube face is already a rectilinear view, crop it for the thumbnail
+	front, err := imaging.Open(filepath.Join(tempDir, faces[0]))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	thumbnail := imaging.Fill(front, THUMBNAIL_WIDTH, THUMBNAIL_HEIGHT, imaging.Center, imaging.Lanczos)
+	err = imaging.Save(thumbnail, filepath.Join(previewDir, THUMBNAIL_FILE), quality)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
 func (this *NonaWrapper) GenerateConfigJSON(cubeSize int, levels int, tileSize int) (*PannellumConfig, error) {
 	log.Info(`Generating config ...`)
 
 	conf := &PannellumConfig{
-		Type: "multires",
-		URL: filepath.Base(this.SrcImgPath),
+		Type:        "multires",
+		URL:         filepath.Base(this.SrcImgPath),
+		Preview:     filepath.ToSlash(filepath.Join(PREVIEW_DIR, PREVIEW_FILE)),
+		Thumbnail:   filepath.ToSlash(filepath.Join(PREVIEW_DIR, THUMBNAIL_FILE)),
+		Placeholder: filepath.ToSlash(filepath.Join(PREVIEW_DIR, PLACEHOLDER_FILE)),
 		Config: &MultiResConfig{
 			BasePath:       "",
 			Path:           "/%l/%s%y_%x",
 			FallbackPath:   "/fallback/%s",
-			Extension:      "jpg",
+			Extension:      this.Options.Format,
 			TileResolution: tileSize,
 			MaxLevel:       levels,
 			CubeResolution: cubeSize,
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	VR360_MODE_EQUIRECTANGULAR = "equirectangular"
+	VR360_MODE_CUBEMAP         = "cubemap"
+	VR360_MODE_DUAL_FISHEYE    = "dualfisheye"
+)
+
+const (
+	PANORAMA_FILE      = "panorama.jpg"
+	PANORAMA_MAX_WIDTH = 4096
+	// largest cube face edge accepted, faces are decoded at full size
+	CUBE_FACE_MAX_SIZE = 8192
+)
+
+// ErrInvalidVR360Source marks panorama input the client has to fix.
+var ErrInvalidVR360Source = errors.New("invalid vr360 source")
+
+func newVR360SourceError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidVR360Source, fmt.Sprintf(format, args...))
+}
+
+var faceAliases = map[string]int{
+	"front":  0,
+	"back":   1,
+	"up":     2,
+	"top":    2,
+	"down":   3,
+	"bottom": 3,
+	"left":   4,
+	"right":  5,
+}
+
+// CubeFaceIndex maps a file name such as "f.jpg", "face0002.tif" or
+// "left.png" to its index in faceLetters.
+func CubeFaceIndex(name string) (int, bool) {
+	base := strings.ToLower(filepath.Base(filepath.ToSlash(name)))
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	for i, letter := range faceLetters {
+		if base == letter || base == strings.TrimSuffix(faces[i], filepath.Ext(faces[i])) {
+			return i, true
+		}
+	}
+	index, ok := faceAliases[base]
+
+	return index, ok
+}
+
+// decodeCubeFace reads the dimensions of a face before decoding it so a
+// small upload cannot force a huge allocation.
+func decodeCubeFace(reader io.Reader) (image.Image, error) {
+	header := new(bytes.Buffer)
+	conf, _, err := image.DecodeConfig(io.TeeReader(reader, header))
+	if err != nil {
+		return nil, err
+	}
+	if conf.Width > CUBE_FACE_MAX_SIZE || conf.Height > CUBE_FACE_MAX_SIZE {
+		return nil, fmt.Errorf("%dx%d exceeds %dpx", conf.Width, conf.Height, CUBE_FACE_MAX_SIZE)
+	}
+
+	return imaging.Decode(io.MultiReader(header, reader))
+}
+
+// GenerateFromCubeFaces skips nona and tiles six cube faces directly, the
+// readers must be ordered as faceLetters.
+func (this *NonaWrapper) GenerateFromCubeFaces(distDir string, readers []io.Reader) (*PannellumConfig, error) {
+	err := this.Options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(readers) != len(faces) {
+		return nil, fmt.Errorf("cubemap requires %d faces, got %d", len(faces), len(readers))
+	}
+
+	log.Info(`Saving cube faces...`)
+	cubeSize := 0
+	cube := make([]image.Image, len(faces))
+	for i, reader := range readers {
+		img, err := decodeCubeFace(reader)
+		if err != nil {
+			log.Error(err)
+			return nil, fmt.Errorf("face %s: %s", faceLetters[i], err)
+		}
+		size := img.Bounds().Size()
+		if size.X != size.Y {
+			return nil, fmt.Errorf("face %s is not square: %dx%d", faceLetters[i], size.X, size.Y)
+		}
+		if i == 0 {
+			cubeSize = size.X
+		} else if size.X != cubeSize {
+			return nil, fmt.Errorf("face %s is %dpx, expected %dpx", faceLetters[i], size.X, cubeSize)
+		}
+
+		err = imaging.Save(img, filepath.Join(distDir, faces[i]))
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		cube[i] = img
+	}
+
+	width := cubeSize * 4
+	if width > PANORAMA_MAX_WIDTH {
+		width = PANORAMA_MAX_WIDTH
+	}
+	panoramaPath := filepath.Join(distDir, PANORAMA_FILE)
+	err = imaging.Save(CubeToEquirectangular(cube, width), panoramaPath,
+		imaging.JPEGQuality(this.Options.Quality))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	this.SrcImgPath = filepath.ToSlash(panoramaPath)
+
+	return this.GenerateFromFaces(cubeSize, distDir)
+}
+
+// CubeToEquirectangular projects six cube faces (ordered as faceLetters) to
+// an equirectangular image of the given width using nearest sampling.
+func CubeToEquirectangular(cube []image.Image, width int) *image.NRGBA {
+	height := width / 2
+	// every face only needs a quarter of the output width
+	faceSize := width / 4
+	if faceSize < 1 {
+		faceSize = 1
+	}
+	scaled := make([]*image.NRGBA, len(cube))
+	for i, face := range cube {
+		if face.Bounds().Dx() > faceSize {
+			scaled[i] = imaging.Resize(face, faceSize, faceSize, imaging.Box)
+		} else {
+			scaled[i] = imaging.Clone(face)
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		pitch := math.Pi/2 - (float64(py)+0.5)/float64(height)*math.Pi
+		for px := 0; px < width; px++ {
+			yaw := (float64(px)+0.5)/float64(width)*2*math.Pi - math.Pi
+
+			x := math.Cos(pitch) * math.Sin(yaw)
+			y := math.Sin(pitch)
+			z := math.Cos(pitch) * math.Cos(yaw)
+			face, u, v := cubeFaceUV(x, y, z)
+
+			src := scaled[face]
+			size := src.Bounds().Dx()
+			sx := int((u + 1) / 2 * float64(size))
+			sy := int((v + 1) / 2 * float64(size))
+			if sx >= size {
+				sx = size - 1
+			}
+			if sy >= size {
+				sy = size - 1
+			}
+
+			dst.SetNRGBA(px, py, src.NRGBAAt(sx, sy))
+		}
+	}
+
+	return dst
+}
+
+// cubeFaceUV returns the face index and the -1..1 texture coordinates
+// (v pointing down) hit by the direction x (right), y (up), z (forward).
+func cubeFaceUV(x, y, z float64) (int, float64, float64) {
+	ax, ay, az := math.Abs(x), math.Abs(y), math.Abs(z)
+
+	switch {
+	case az >= ax && az >= ay && z > 0:
+		return 0, x / az, -y / az
+	case az >= ax && az >= ay:
+		return 1, -x / az, -y / az
+	case ay >= ax && y > 0:
+		return 2, x / ay, z / ay
+	case ay >= ax:
+		return 3, x / ay, -z / ay
+	case x < 0:
+		return 4, z / ax, -y / ax
+	default:
+		return 5, -z / ax, -y / ax
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+var faceColors = []color.NRGBA{
+	{R: 255, A: 255},
+	{G: 255, A: 255},
+	{B: 255, A: 255},
+	{R: 255, G: 255, A: 255},
+	{G: 255, B: 255, A: 255},
+	{R: 255, B: 255, A: 255},
+}
+
+func TestCubeFaceIndex(t *testing.T) {
+	cases := map[string]int{
+		"f.jpg":             0,
+		"cube/B.PNG":        1,
+		"face0002.tif":      2,
+		"bottom.jpg":        3,
+		"panorama/left.jpg": 4,
+		"r":                 5,
+	}
+	for name, expected := range cases {
+		index, ok := CubeFaceIndex(name)
+		if !ok || index != expected {
+			t.Errorf("%s: got %d (%v), expected %d", name, index, ok, expected)
+		}
+	}
+
+	if _, ok := CubeFaceIndex("readme.txt"); ok {
+		t.Error("readme.txt should not be a cube face")
+	}
+}
+
+func TestCubeToEquirectangular(t *testing.T) {
+	cube := make([]image.Image, len(faceColors))
+	for i, c := range faceColors {
+		cube[i] = imaging.New(32, 32, c)
+	}
+
+	equirect := CubeToEquirectangular(cube, 128)
+	if equirect.Bounds().Dx() != 128 || equirect.Bounds().Dy() != 64 {
+		t.Errorf("unexpected size %v", equirect.Bounds().Size())
+	}
+
+	points := map[int][2]int{
+		0: {64, 32}, // yaw 0
+		1: {1, 32},  // yaw -180
+		2: {64, 0},  // zenith
+		3: {64, 63}, // nadir
+		4: {32, 32}, // yaw -90
+		5: {96, 32}, // yaw 90
+	}
+	for face, point := range points {
+		if equirect.NRGBAAt(point[0], point[1]) != faceColors[face] {
+			t.Errorf("%v: expected face %s", point, faceLetters[face])
+		}
+	}
+}
+
+func TestNonaWrapper_GenerateFromCubeFaces(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cubemap")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	readers := make([]io.Reader, len(faceColors))
+	for i, c := range faceColors {
+		buf := new(bytes.Buffer)
+		err = imaging.Encode(buf, imaging.New(256, 256, c), imaging.PNG)
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+			return
+		}
+		readers[i] = buf
+	}
+
+	opts := NewTileOptions()
+	opts.TileSize = 128
+	opts.FallbackSize = 128
+	conf, err := NewNonaWrapper(``).SetOptions(opts).GenerateFromCubeFaces(tempDir, readers)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	if conf.Config.CubeResolution != 256 || conf.Config.MaxLevel != 2 || conf.URL != PANORAMA_FILE {
+		t.Errorf("unexpected config %+v %+v", conf, conf.Config)
+	}
+	for _, file := range []string{"2/f1_1.jpg", "1/r0_0.jpg", "fallback/u.jpg", PANORAMA_FILE, conf.Thumbnail} {
+		if _, err := os.Stat(filepath.Join(tempDir, file)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, faces[0])); err == nil {
+		t.Error("cube faces should be removed")
+	}
+}
+
+func TestDecodeCubeFace(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := imaging.Encode(buf, imaging.New(16, 16, faceColors[0]), imaging.PNG)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	img, err := decodeCubeFace(buf)
+	if err != nil || img.Bounds().Dx() != 16 {
+		t.Errorf("unexpected face %v %v", img, err)
+	}
+
+	buf.Reset()
+	err = imaging.Encode(buf, imaging.New(CUBE_FACE_MAX_SIZE+1, 1, faceColors[0]), imaging.PNG)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	_, err = decodeCubeFace(buf)
+	if err == nil {
+		t.Error("expected an oversized face to be rejected")
+	}
+}
+
+func TestHTTPService_VR360_InvalidSource(t *testing.T) {
+	httpServer := NewHTTP(&Config{})
+	handler := httpServer.getHTTPHandler()
+
+	for _, mode := range []string{VR360_MODE_CUBEMAP, VR360_MODE_EQUIRECTANGULAR, "sphere"} {
+		body := new(bytes.Buffer)
+		form := multipart.NewWriter(body)
+		form.WriteField("mode", mode)
+		form.Close()
+
+		for _, path := range []string{"/vr360", "/vr360/s3"} {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body.Bytes()))
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			if writer.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: expected 400, got %d %s", path, mode, writer.Code, writer.Body.String())
+			}
+		}
+	}
+}
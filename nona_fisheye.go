@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	FISHEYE_LAYOUT_SIDE_BY_SIDE = "sbs"
+	FISHEYE_LAYOUT_TOP_BOTTOM   = "tb"
+)
+
+const (
+	DEFAULT_FISHEYE_FOV = 190.0
+	MIN_FISHEYE_FOV     = 180.0
+	MAX_FISHEYE_FOV     = 240.0
+)
+
+var fisheyeLenses = []string{"lens0.tif", "lens1.tif"}
+
+type FisheyeOptions struct {
+	// 双鱼眼排列方式, 可能值 "sbs" (左右), "tb" (上下)
+	Layout string `json:"layout"`
+	// 单个镜头视场角（度）
+	FOV float64 `json:"fov"`
+}
+
+func NewFisheyeOptions() *FisheyeOptions {
+	return &FisheyeOptions{
+		Layout: FISHEYE_LAYOUT_SIDE_BY_SIDE,
+		FOV:    DEFAULT_FISHEYE_FOV,
+	}
+}
+
+func (this *FisheyeOptions) Validate() error {
+	if this.Layout != FISHEYE_LAYOUT_SIDE_BY_SIDE && this.Layout != FISHEYE_LAYOUT_TOP_BOTTOM {
+		return fmt.Errorf("unsupported fisheye layout %q, expected %s or %s",
+			this.Layout, FISHEYE_LAYOUT_SIDE_BY_SIDE, FISHEYE_LAYOUT_TOP_BOTTOM)
+	}
+	if this.FOV < MIN_FISHEYE_FOV || this.FOV > MAX_FISHEYE_FOV {
+		return fmt.Errorf("fisheye fov %.1f out of range %.0f-%.0f", this.FOV, MIN_FISHEYE_FOV, MAX_FISHEYE_FOV)
+	}
+
+	return nil
+}
+
+// LensRects returns the area covered by the front and the back lens.
+func (this *FisheyeOptions) LensRects(width int, height int) (image.Rectangle, image.Rectangle) {
+	if this.Layout == FISHEYE_LAYOUT_TOP_BOTTOM {
+		return image.Rect(0, 0, width, height/2), image.Rect(0, height/2, width, height)
+	}
+
+	return image.Rect(0, 0, width/2, height), image.Rect(width/2, 0, width, height)
+}
+
+// GenerateFromDualFisheye stitches a dual-fisheye frame into an
+// equirectangular panorama with nona, then runs the normal pipeline.
+func (this *NonaWrapper) GenerateFromDualFisheye(distDir string, reader io.ReadSeeker, opts *FisheyeOptions) (*PannellumConfig, error) {
+	err := this.Options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = this.CopyToLocalFromReader(distDir, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = this.StitchDualFisheye(distDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return this.Generate(distDir)
+}
+
+func (this *NonaWrapper) StitchDualFisheye(distDir string, opts *FisheyeOptions) error {
+	log.Info(`Stitching dual fisheye...`)
+
+	src, err := imaging.Open(this.SrcImgPath)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	front, back := opts.LensRects(src.Bounds().Dx(), src.Bounds().Dy())
+	if front.Dx() != front.Dy() {
+		return fmt.Errorf("lens area %dx%d is not square, check the fisheye layout", front.Dx(), front.Dy())
+	}
+
+	for i, rect := range []image.Rectangle{front, back} {
+		err = imaging.Save(imaging.Crop(src, rect), filepath.Join(distDir, fisheyeLenses[i]))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	configPath := filepath.Join(distDir, `fisheye.pto`)
+	err = this.GenerateFisheyeConfigFile(configPath, front.Dx(), opts.FOV, distDir)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	stitchedPrefix := filepath.Join(distDir, `stitched`)
+	err = this.Run(filepath.ToSlash(stitchedPrefix), configPath)
+	if err != nil {
+		return err
+	}
+
+	stitched, err := imaging.Open(stitchedPrefix + ".tif")
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	panoramaPath := filepath.Join(distDir, PANORAMA_FILE)
+	err = imaging.Save(stitched, panoramaPath, imaging.JPEGQuality(this.Options.Quality))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	files := []string{this.SrcImgPath, configPath, stitchedPrefix + ".tif"}
+	for _, lens := range fisheyeLenses {
+		files = append(files, filepath.Join(distDir, lens))
+	}
+	for _, file := range files {
+		err := os.Remove(file)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	this.SrcImgPath = filepath.ToSlash(panoramaPath)
+
+	return nil
+}
+
+// GenerateFisheyeConfigFile writes a pto projecting two circular fisheye
+// lenses facing opposite directions onto one equirectangular image.
+func (this *NonaWrapper) GenerateFisheyeConfigFile(distFileName string, diameter int, fov float64, lensDir string) error {
+	width := int(math.Round(360/fov*float64(diameter)/2)) * 2
+
+	buff := bytes.NewBuffer([]byte{})
+	fmt.Fprintln(buff, fmt.Sprintf(`p f2 w%d h%d v360 E0 R0 n"TIFF"`, width, width/2))
+	fmt.Fprintln(buff, `m g1 i0 m2 p0.00784314`)
+	for i, yaw := range []int{0, 180} {
+		lensPath := filepath.ToSlash(filepath.Join(lensDir, fisheyeLenses[i]))
+		fmt.Fprintln(buff, fmt.Sprintf(`i a0 b0 c0 d0 e0 f2 h%d w%d n"%s" r0 p0 y%d v%g`,
+			diameter, diameter, lensPath, yaw, fov))
+	}
+	fmt.Fprintln(buff, `v`)
+	fmt.Fprintln(buff, `*`)
+
+	conf, err := os.Create(distFileName)
+	if err != nil {
+		return err
+	}
+	defer conf.Close()
+
+	_, err = io.Copy(conf, buff)
+
+	return err
+}
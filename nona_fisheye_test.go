@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFisheyeOptions_LensRects(t *testing.T) {
+	opts := NewFisheyeOptions()
+	front, back := opts.LensRects(3840, 1920)
+	if front.Dx() != 1920 || front.Dy() != 1920 || back.Min.X != 1920 {
+		t.Errorf("unexpected side by side lenses %v %v", front, back)
+	}
+
+	opts.Layout = FISHEYE_LAYOUT_TOP_BOTTOM
+	front, back = opts.LensRects(1920, 3840)
+	if front.Dx() != 1920 || front.Dy() != 1920 || back.Min.Y != 1920 {
+		t.Errorf("unexpected top bottom lenses %v %v", front, back)
+	}
+
+	opts.FOV = 120
+	if opts.Validate() == nil {
+		t.Error("expected fov validation error")
+	}
+}
+
+func TestNonaWrapper_GenerateFisheyeConfigFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fisheye")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	configPath := filepath.Join(tempDir, "fisheye.pto")
+	err = NewNonaWrapper(``).GenerateFisheyeConfigFile(configPath, 1920, 190, tempDir)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	config := string(content)
+	for _, expected := range []string{`p f2 w3638 h1819`, `f2 h1920 w1920`, `y180 v190`} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("missing %q in:\n%s", expected, config)
+		}
+	}
+}
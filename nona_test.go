@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"image/color"
 	_ "image/jpeg"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/disintegration/imaging"
 )
 
 func GetNona() (*NonaWrapper, error) {
@@ -158,4 +163,107 @@ func TestNonaWrapper_Generate(t *testing.T) {
 		t.Fail()
 		return
 	}
-}
\ No newline at end of file
+}
+func TestTileOptions_Validate(t *testing.T) {
+	opts := NewTileOptions()
+	opts.Format = "JPEG"
+	err := opts.Validate()
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if opts.Format != TILE_FORMAT_JPG {
+		t.Errorf("format not normalized: %s", opts.Format)
+	}
+
+	invalid := []*TileOptions{
+		&TileOptions{TileSize: 500, Quality: 95, FallbackSize: 1024, Format: "jpg"},
+		&TileOptions{TileSize: 512, Quality: 0, FallbackSize: 1024, Format: "jpg"},
+		&TileOptions{TileSize: 512, Quality: 95, FallbackSize: 8192, Format: "jpg"},
+		&TileOptions{TileSize: 512, Quality: 95, FallbackSize: 1024, Format: "avif"},
+	}
+	for _, opt := range invalid {
+		if opt.Validate() == nil {
+			t.Errorf("expected error for %+v", opt)
+		}
+	}
+}
+
+func TestTileOptions_Pyramid(t *testing.T) {
+	opts := NewTileOptions()
+
+	cases := map[int][2]int{
+		2000: {512, 3},
+		1024: {512, 2},
+		300:  {300, 1},
+	}
+	for cubeSize, expected := range cases {
+		tileSize, levels, err := opts.Pyramid(cubeSize)
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+			return
+		}
+		if tileSize != expected[0] || levels != expected[1] {
+			t.Errorf("cube %d: got tile %d levels %d, expected %v", cubeSize, tileSize, levels, expected)
+		}
+	}
+
+	opts.TileSize = MIN_TILE_SIZE
+	if _, levels, err := opts.Pyramid(32768); err != nil || levels != MAX_TILE_LEVELS {
+		t.Errorf("cube 32768: got levels %d, %v", levels, err)
+	}
+	if _, _, err := opts.Pyramid(65536); err == nil {
+		t.Error("expected an error for too many levels")
+	}
+}
+
+func TestNonaWrapper_GeneratePreview(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "src.jpg")
+	err = imaging.Save(imaging.New(512, 256, color.NRGBA{R: 255, A: 255}), srcPath)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	err = imaging.Save(imaging.New(256, 256, color.NRGBA{G: 255, A: 255}), filepath.Join(tempDir, faces[0]))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	Nona := NewNonaWrapper(srcPath)
+	err = Nona.GeneratePreview(tempDir)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	expected := map[string][2]int{
+		PREVIEW_FILE:     {512, 256},
+		THUMBNAIL_FILE:   {THUMBNAIL_WIDTH, THUMBNAIL_HEIGHT},
+		PLACEHOLDER_FILE: {PLACEHOLDER_WIDTH, PLACEHOLDER_WIDTH / 2},
+	}
+	for file, size := range expected {
+		img, err := imaging.Open(filepath.Join(tempDir, PREVIEW_DIR, file))
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+			return
+		}
+		if img.Bounds().Dx() != size[0] || img.Bounds().Dy() != size[1] {
+			t.Errorf("%s: got %v, expected %v", file, img.Bounds().Size(), size)
+		}
+	}
+}
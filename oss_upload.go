@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const OSS_CALLBACK_PATH = "/oss/callback"
+
+const OSS_JOB_SPLIT = "split"
+
+const OSS_JOB_VR360 = "vr360"
+
+// frames of a split job when split_size is not set
+const OSS_SPLIT_SIZE = 36
+
+// uploads recorded by the callback are kept under this dir of the prefix
+const OSS_RECORD_DIR = "records"
+
+// OSS only signs callbacks with public keys served from this host
+var ossPublicKeyHosts = []string{
+	"http://gosspublic.alicdn.com/",
+	"https://gosspublic.alicdn.com/",
+}
+
+var ErrOSSUploadRejected = errors.New("uploaded object is not accepted")
+
+// OSSUploadConfig configures browser direct uploads through /oss/params and
+// the callback OSS sends once an upload finished.
+type OSSUploadConfig struct {
+	// public URL of /oss/callback, no callback is requested when empty
+	CallbackURL string `json:"callback_url"`
+	// accepted content type prefixes, e.g. "video/", all when empty
+	ContentTypes []string `json:"content_types"`
+	// size limits in bytes enforced by the upload policy, 0 is unlimited
+	MinSize int64 `json:"min_size"`
+	MaxSize int64 `json:"max_size"`
+	// job started for every accepted upload: "split", "vr360" or none
+	Job string `json:"job"`
+	// frames of the split job
+	SplitSize int `json:"split_size"`
+}
+
+// OSSCallbackBody is the callbackBody OSS fills in, see GenerateFormMultipart.
+type OSSCallbackBody struct {
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Filename string `json:"filename"`
+	Bucket   string `json:"bucket"`
+}
+
+// swagger:model OSSUploadRecord
+type OSSUploadRecord struct {
+	// 对象 key
+	Key string `json:"key"`
+	// 存储桶
+	Bucket string `json:"bucket"`
+	// 文件字节数
+	Size int64 `json:"size"`
+	// 文件类型
+	MimeType string `json:"mime_type"`
+	// 文件URL
+	URL string `json:"url"`
+	// 上传完成时间
+	Uploaded time.Time `json:"uploaded"`
+	// 自动开始的任务类型 split/vr360
+	Job string `json:"job,omitempty"`
+	// 自动开始的任务ID，可通过 /task 查询
+	Task string `json:"task,omitempty"`
+}
+
+func (this *OSSUploadConfig) splitSize() int {
+	if this.SplitSize > 0 {
+		return this.SplitSize
+	}
+
+	return OSS_SPLIT_SIZE
+}
+
+// Validate checks the settings that would otherwise only fail once an
+// upload arrives.
+func (this *OSSUploadConfig) Validate() error {
+	switch this.Job {
+	case "", OSS_JOB_SPLIT, OSS_JOB_VR360:
+	default:
+		return fmt.Errorf("unsupported oss_upload job %q", this.Job)
+	}
+	if this.MaxSize > 0 && this.MinSize > this.MaxSize {
+		return errors.New("oss_upload min_size is larger than max_size")
+	}
+
+	return nil
+}
+
+// callbackURL is the callback of an upload started by tenant, the tenant
+// only selects the config, the object key is checked against its prefix.
+func (this *OSSUploadConfig) callbackURL(tenant string) string {
+	if this == nil || len(this.CallbackURL) <= 0 {
+		return ""
+	}
+	if len(tenant) <= 0 {
+		return this.CallbackURL
+	}
+
+	separator := "?"
+	if strings.Contains(this.CallbackURL, "?") {
+		separator = "&"
+	}
+
+	return this.CallbackURL + separator + url.Values{"tenant": []string{tenant}}.Encode()
+}
+
+// Check rejects uploads outside the configured size and content types.
+func (this *OSSUploadConfig) Check(body *OSSCallbackBody) error {
+	if this == nil {
+		return nil
+	}
+	if this.MinSize > 0 && body.Size < this.MinSize {
+		return fmt.Errorf("%w: size %d is below %d", ErrOSSUploadRejected, body.Size, this.MinSize)
+	}
+	if this.MaxSize > 0 && body.Size > this.MaxSize {
+		return fmt.Errorf("%w: size %d exceeds %d", ErrOSSUploadRejected, body.Size, this.MaxSize)
+	}
+	if len(this.ContentTypes) > 0 && !matchContentType(body.MimeType, this.ContentTypes) {
+		return fmt.Errorf("%w: content type %q", ErrOSSUploadRejected, body.MimeType)
+	}
+
+	return nil
+}
+
+func isOSSPublicKeyURL(publicKeyURL string) bool {
+	for _, prefix := range ossPublicKeyHosts {
+		if strings.HasPrefix(publicKeyURL, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewOSSUploadRecord checks that the callback is about an object of conf,
+// uploads are only accepted under the prefix of the tenant they were
+// signed for.
+func NewOSSUploadRecord(conf *OSSConfig, body *OSSCallbackBody) (*OSSUploadRecord, error) {
+	if body.Bucket != conf.Bucket {
+		return nil, fmt.Errorf("%w: bucket %q", ErrOSSUploadRejected, body.Bucket)
+	}
+	prefix := strings.Trim(conf.PrefixPath, "/")
+	key := strings.TrimLeft(path.Clean("/"+body.Filename), "/")
+	if len(prefix) > 0 && !strings.HasPrefix(key, prefix+"/") {
+		return nil, fmt.Errorf("%w: key %q is outside %q", ErrOSSUploadRejected, key, prefix)
+	}
+
+	return &OSSUploadRecord{
+		Key:      key,
+		Bucket:   body.Bucket,
+		Size:     body.Size,
+		MimeType: body.MimeType,
+		URL:      fmt.Sprintf("//%s.%s/%s", conf.Bucket, conf.EndPoint, key),
+		Uploaded: time.Now().UTC(),
+	}, nil
+}
+
+// ossRecordKey keeps the path of key below the prefix and its extension,
+// so "a/photo.jpg" and "b/photo.png" get records of their own.
+func ossRecordKey(prefix string, key string) string {
+	return path.Join("/", OSS_RECORD_DIR, objectKey(prefix, key)+".json")
+}
+
+// SaveOSSUploadRecord stores record as records/{key}.json beside the uploads.
+func (this *Worker) SaveOSSUploadRecord(record *OSSUploadRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	storage, err := this.SourceStorage(STORAGE_OSS)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	_, _, err = storage.PutContent(string(data), ossRecordKey(this.storagePrefix(STORAGE_OSS), record.Key),
+		&UploadOptions{ContentType: "application/json"})
+
+	return err
+}
+
+// LoadOSSUploadRecord reads records/{key}.json, ErrObjectNotFound when the
+// upload was not recorded yet.
+func (this *Worker) LoadOSSUploadRecord(key string) (*OSSUploadRecord, error) {
+	storage, err := this.SourceStorage(STORAGE_OSS)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return loadOSSUploadRecord(storage, ossRecordKey(this.storagePrefix(STORAGE_OSS), key))
+}
+
+func loadOSSUploadRecord(storage IStorage, recordKey string) (*OSSUploadRecord, error) {
+	reader, err := storage.Get(recordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	record := new(OSSUploadRecord)
+	err = json.NewDecoder(reader).Decode(record)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// ProcessOSSUpload runs the configured job of an uploaded object.
+func (this *Worker) ProcessOSSUpload(job string, record *OSSUploadRecord) (interface{}, error) {
+	switch job {
+	case OSS_JOB_SPLIT:
+		return this.S3FromKey(STORAGE_OSS, record.Key, this.Conf.OSSUpload.splitSize())
+	case OSS_JOB_VR360:
+		file, _, err := this.FetchObject(STORAGE_OSS, record.Key)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return this.VR360ToS3(&VR360Source{Mode: VR360_MODE_EQUIRECTANGULAR, Image: file}, NewTileOptions())
+	default:
+		return nil, fmt.Errorf("unsupported job %q", job)
+	}
+}
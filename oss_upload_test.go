@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOSSUploadConfig_CallbackURL(t *testing.T) {
+	var missing *OSSUploadConfig
+	if url := missing.callbackURL("acme"); url != "" {
+		t.Errorf("expected no callback, got %q", url)
+	}
+
+	conf := &OSSUploadConfig{CallbackURL: "https://api.example.com/oss/callback"}
+	if url := conf.callbackURL(""); url != conf.CallbackURL {
+		t.Errorf("unexpected default tenant callback %q", url)
+	}
+	if url := conf.callbackURL("acme"); url != "https://api.example.com/oss/callback?tenant=acme" {
+		t.Errorf("unexpected tenant callback %q", url)
+	}
+}
+
+func TestOSSUploadConfig_Check(t *testing.T) {
+	conf := &OSSUploadConfig{ContentTypes: []string{"video/"}, MinSize: 10, MaxSize: 100}
+
+	cases := []struct {
+		body   *OSSCallbackBody
+		accept bool
+	}{
+		{&OSSCallbackBody{MimeType: "video/mp4", Size: 50}, true},
+		{&OSSCallbackBody{MimeType: "image/png", Size: 50}, false},
+		{&OSSCallbackBody{MimeType: "video/mp4", Size: 5}, false},
+		{&OSSCallbackBody{MimeType: "video/mp4", Size: 500}, false},
+	}
+	for i, c := range cases {
+		err := conf.Check(c.body)
+		if c.accept != (err == nil) {
+			t.Errorf("case %d: unexpected result %v", i, err)
+		}
+		if err != nil && !errors.Is(err, ErrOSSUploadRejected) {
+			t.Errorf("case %d: expected ErrOSSUploadRejected, got %v", i, err)
+		}
+	}
+
+	if err := (&OSSUploadConfig{Job: "transcode"}).Validate(); err == nil {
+		t.Error("expected unsupported job to fail")
+	}
+}
+
+func TestNewOSSUploadRecord(t *testing.T) {
+	conf := &OSSConfig{Bucket: "media", EndPoint: "oss-cn-shenzhen.aliyuncs.com", PrefixPath: "spin360/acme"}
+
+	record, err := NewOSSUploadRecord(conf, &OSSCallbackBody{
+		Bucket: "media", Filename: "spin360/acme/a.mp4", Size: 10, MimeType: "video/mp4",
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if record.Key != "spin360/acme/a.mp4" || record.URL != "//media.oss-cn-shenzhen.aliyuncs.com/spin360/acme/a.mp4" {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	for _, body := range []*OSSCallbackBody{
+		&OSSCallbackBody{Bucket: "other", Filename: "spin360/acme/a.mp4"},
+		&OSSCallbackBody{Bucket: "media", Filename: "spin360/globex/a.mp4"},
+		&OSSCallbackBody{Bucket: "media", Filename: "spin360/acme/../globex/a.mp4"},
+	} {
+		if _, err := NewOSSUploadRecord(conf, body); !errors.Is(err, ErrOSSUploadRejected) {
+			t.Errorf("expected %+v to be rejected, got %v", body, err)
+		}
+	}
+}
+
+func TestOSSRecordKey(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+		record string
+	}{
+		{"spin360/acme", "spin360/acme/a/photo.jpg", "/records/a/photo.jpg.json"},
+		{"spin360/acme", "spin360/acme/b/photo.png", "/records/b/photo.png.json"},
+		{"/spin360/", "spin360/photo.jpg", "/records/photo.jpg.json"},
+		{"", "photo.jpg", "/records/photo.jpg.json"},
+	}
+	for _, c := range cases {
+		if record := ossRecordKey(c.prefix, c.key); record != c.record {
+			t.Errorf("%s: expected %s, got %s", c.key, c.record, record)
+		}
+	}
+}
+
+func TestLoadOSSUploadRecord(t *testing.T) {
+	storage := newMemoryStorage()
+	recordKey := ossRecordKey("spin360", "spin360/a/photo.jpg")
+	if _, err := loadOSSUploadRecord(storage, recordKey); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	storage.PutContent(`{"key":"spin360/a/photo.jpg","job":"vr360","task":"abc"}`, recordKey, &UploadOptions{})
+	record, err := loadOSSUploadRecord(storage, recordKey)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if record.Key != "spin360/a/photo.jpg" || record.Task != "abc" {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestOSSStorage_GenerateFormMultipart_Prefix(t *testing.T) {
+	storage := &OSSStorage{Conf: &OSSConfig{SecretKey: "secret", Bucket: "media", PrefixPath: "spin360/acme"}}
+	params, err := storage.GenerateFormMultipart("a.mp4", time.Minute, "", nil)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	policy, err := base64.StdEncoding.DecodeString(params.Policy)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if !strings.Contains(string(policy), `["starts-with","$key","spin360/acme/"]`) {
+		t.Errorf("unexpected policy %s", policy)
+	}
+	if !strings.HasPrefix(params.FileName, "spin360/acme/") || strings.Contains(params.FileName, "//") {
+		t.Errorf("unexpected filename %s", params.FileName)
+	}
+}
+
+func TestHTTPService_OSSCallback_Untrusted(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		S3:   &S3Config{},
+		OSS:  &OSSConfig{Bucket: "media", PrefixPath: "spin360"},
+		Auth: &AuthConfig{APIKeys: []*APIKeyConfig{&APIKeyConfig{Name: "a", Key: "k"}}},
+	})
+	handler := httpServer.getHTTPHandler()
+
+	// signed with a public key served from anywhere but OSS
+	req := httptest.NewRequest(http.MethodPost, OSS_CALLBACK_PATH,
+		strings.NewReader(`{"mimeType":"video/mp4", "size":10, "filename":"spin360/a.mp4", "bucket":"media"}`))
+	req.Header.Set("x-oss-pub-key-url", base64.StdEncoding.EncodeToString([]byte("https://attacker.example.com/key.pem")))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("signature")))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	if writer.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", writer.Code)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// client buckets idle for longer are dropped
+const RATE_LIMIT_IDLE = 10 * time.Minute
+
+var ErrRateLimited = errors.New("too many requests, retry later")
+
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+type RateLimitConfig struct {
+	// processing requests allowed per minute for each API key or IP
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	// requests allowed at once above the steady rate
+	Burst int `json:"burst"`
+	// take the client IP from X-Forwarded-For, only behind a trusted proxy
+	TrustProxy bool `json:"trust_proxy"`
+}
+
+// tokenBucket refills rate tokens per second up to burst.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// take refills the bucket and takes a token, it returns how long to wait
+// when none is available.
+func (this *tokenBucket) take(rate float64, burst int, now time.Time) time.Duration {
+	elapsed := now.Sub(this.lastSeen).Seconds()
+	if elapsed > 0 {
+		this.tokens = math.Min(float64(burst), this.tokens+elapsed*rate)
+		this.lastSeen = now
+	}
+	if this.tokens < 1 {
+		return time.Duration((1 - this.tokens) / rate * float64(time.Second))
+	}
+	this.tokens--
+
+	return 0
+}
+
+// RateLimiter keeps a token bucket per client.
+type RateLimiter struct {
+	conf    *RateLimitConfig
+	lock    sync.Mutex
+	clients map[string]*tokenBucket
+	pruned  time.Time
+}
+
+func NewRateLimiter(conf *RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		conf:    conf,
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+func (this *RateLimiter) burst() int {
+	if this.conf.Burst > 0 {
+		return this.conf.Burst
+	}
+
+	return 1
+}
+
+// Reserve takes a token for client, it returns the tokens left and how
+// long to wait when none was available.
+func (this *RateLimiter) Reserve(client string, now time.Time) (int, time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if now.Sub(this.pruned) > RATE_LIMIT_IDLE {
+		for key, item := range this.clients {
+			if now.Sub(item.lastSeen) > RATE_LIMIT_IDLE {
+				delete(this.clients, key)
+			}
+		}
+		this.pruned = now
+	}
+
+	bucket, ok := this.clients[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(this.burst()), lastSeen: now}
+		this.clients[client] = bucket
+	}
+
+	delay := bucket.take(this.conf.RequestsPerMinute/60, this.burst(), now)
+	if delay > 0 {
+		return 0, delay
+	}
+
+	return int(bucket.tokens), 0
+}
+
+type dailyUsage struct {
+	day   string
+	jobs  int
+	bytes int64
+}
+
+// QuotaTracker counts the jobs and bytes of each tenant for the current
+// UTC day, usage is kept in memory and restarts with the process.
+type QuotaTracker struct {
+	lock  sync.Mutex
+	usage map[string]*dailyUsage
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		usage: make(map[string]*dailyUsage),
+	}
+}
+
+func (this *QuotaTracker) current(tenant string, now time.Time) *dailyUsage {
+	day := now.UTC().Format("2006-01-02")
+	usage, ok := this.usage[tenant]
+	if !ok || usage.day != day {
+		usage = &dailyUsage{day: day}
+		this.usage[tenant] = usage
+	}
+
+	return usage
+}
+
+// Check reports whether tenant may start a job of size bytes, nothing is
+// counted, a size below 0 is unknown.
+func (this *QuotaTracker) Check(tenant string, quota *TenantQuota, size int64, now time.Time) (dailyUsage, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	usage := this.current(tenant, now)
+	if quota.DailyJobs > 0 && usage.jobs >= quota.DailyJobs {
+		return *usage, ErrQuotaExceeded
+	}
+	if quota.DailyBytes > 0 && usage.bytes+int64(math.Max(float64(size), 0)) > quota.DailyBytes {
+		return *usage, ErrQuotaExceeded
+	}
+
+	return *usage, nil
+}
+
+// StartJob counts a job of size bytes against quota, nothing is counted
+// when it would exceed the quota.
+func (this *QuotaTracker) StartJob(tenant string, quota *TenantQuota, size int64, now time.Time) (dailyUsage, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	usage := this.current(tenant, now)
+	if quota.DailyJobs > 0 && usage.jobs >= quota.DailyJobs {
+		return *usage, ErrQuotaExceeded
+	}
+	if quota.DailyBytes > 0 && usage.bytes+int64(math.Max(float64(size), 0)) > quota.DailyBytes {
+		return *usage, ErrQuotaExceeded
+	}
+	usage.jobs++
+
+	return *usage, nil
+}
+
+func (this *QuotaTracker) AddBytes(tenant string, size int64, now time.Time) dailyUsage {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	usage := this.current(tenant, now)
+	usage.bytes += size
+
+	return *usage
+}
+
+type requestQuotaContextKey struct{}
+
+// requestQuota charges the work of one throttled request to its tenant:
+// the job once processing actually starts and every byte received or
+// fetched on its behalf.
+type requestQuota struct {
+	tracker *QuotaTracker
+	tenant  string
+	quota   *TenantQuota
+	writer  http.ResponseWriter
+	once    sync.Once
+}
+
+func withRequestQuota(ctx context.Context, quota *requestQuota) context.Context {
+	return context.WithValue(ctx, requestQuotaContextKey{}, quota)
+}
+
+func getRequestQuota(ctx context.Context) *requestQuota {
+	quota, _ := ctx.Value(requestQuotaContextKey{}).(*requestQuota)
+
+	return quota
+}
+
+// newRequestQuota is the quota charged for request, nil when its tenant
+// is unlimited.
+func (this *HTTPService) newRequestQuota(writer http.ResponseWriter, request *http.Request) *requestQuota {
+	tenant := GetTenant(request.Context())
+	quota := this.config.TenantQuota(tenant)
+	if quota == nil {
+		return nil
+	}
+
+	return &requestQuota{tracker: this.quotas, tenant: tenant, quota: quota, writer: writer}
+}
+
+// Check fails when a job of size bytes would exceed the quota, nothing is
+// counted yet.
+func (this *requestQuota) Check(size int64, now time.Time) error {
+	if this == nil {
+		return nil
+	}
+
+	usage, err := this.tracker.Check(this.tenant, this.quota, size, now)
+	setQuotaHeaders(this.writer, this.quota, usage)
+	if err != nil {
+		year, month, day := now.UTC().Date()
+		retryAfter(this.writer, time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC).Sub(now))
+	}
+
+	return err
+}
+
+// StartJob counts the job of the request, Throttle already checked the
+// quota, later calls of the same request are ignored.
+func (this *requestQuota) StartJob() {
+	if this == nil {
+		return
+	}
+
+	this.once.Do(func() {
+		usage, err := this.tracker.StartJob(this.tenant, this.quota, 0, time.Now())
+		if err != nil {
+			// a concurrent request took the last job, the check already passed
+			log.Warningf("tenant %s: %s", this.tenant, err)
+		}
+		setQuotaHeaders(this.writer, this.quota, usage)
+	})
+}
+
+// AddBytes counts n bytes and fails once the daily bytes are exceeded.
+func (this *requestQuota) AddBytes(n int64) error {
+	if this == nil {
+		return nil
+	}
+
+	usage := this.tracker.AddBytes(this.tenant, n, time.Now())
+	if this.quota.DailyBytes > 0 && usage.bytes > this.quota.DailyBytes {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// Reader counts what is read from reader against the quota.
+func (this *requestQuota) Reader(reader io.Reader) io.Reader {
+	if this == nil {
+		return reader
+	}
+
+	return &countingReader{Reader: reader, count: this.AddBytes}
+}
+
+// ReadCloser is Reader for bodies that have to be closed.
+func (this *requestQuota) ReadCloser(reader io.ReadCloser) io.ReadCloser {
+	if this == nil {
+		return reader
+	}
+
+	return &countingReadCloser{countingReader: countingReader{Reader: reader, count: this.AddBytes}, closer: reader}
+}
+
+// countingReader passes the bytes read to count, reading stops with the
+// error count returns.
+type countingReader struct {
+	io.Reader
+	count func(int64) error
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.Reader.Read(p)
+	if n > 0 {
+		if countErr := this.count(int64(n)); countErr != nil {
+			return n, countErr
+		}
+	}
+
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	closer io.Closer
+}
+
+func (this *countingReadCloser) Close() error {
+	return this.closer.Close()
+}
+
+// clientKey identifies the caller for rate limiting: the authenticated
+// principal, else the client IP.
+func (this *HTTPService) clientKey(request *http.Request) string {
+	if principal := GetPrincipal(request.Context()); principal != nil {
+		return fmt.Sprintf("principal:%s", principal.Name)
+	}
+
+	if this.config.RateLimit != nil && this.config.RateLimit.TrustProxy {
+		if forwarded := request.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+			return fmt.Sprintf("ip:%s", strings.TrimSpace(strings.Split(forwarded, ",")[0]))
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	return fmt.Sprintf("ip:%s", host)
+}
+
+func retryAfter(writer http.ResponseWriter, delay time.Duration) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+}
+
+func setQuotaHeaders(writer http.ResponseWriter, quota *TenantQuota, usage dailyUsage) {
+	if quota.DailyJobs > 0 {
+		writer.Header().Set("X-Quota-Jobs-Limit", strconv.Itoa(quota.DailyJobs))
+		writer.Header().Set("X-Quota-Jobs-Remaining", strconv.Itoa(int(math.Max(float64(quota.DailyJobs-usage.jobs), 0))))
+	}
+	if quota.DailyBytes > 0 {
+		writer.Header().Set("X-Quota-Bytes-Limit", strconv.FormatInt(quota.DailyBytes, 10))
+		writer.Header().Set("X-Quota-Bytes-Remaining", strconv.FormatInt(int64(math.Max(float64(quota.DailyBytes-usage.bytes), 0)), 10))
+	}
+}
+
+// Throttle guards a processing endpoint with the client rate limit and the
+// daily quota of the tenant.
+func (this *HTTPService) Throttle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		now := time.Now()
+
+		if this.config.RateLimit != nil && this.config.RateLimit.RequestsPerMinute > 0 {
+			remaining, delay := this.limiter.Reserve(this.clientKey(request), now)
+			writer.Header().Set("X-RateLimit-Limit", strconv.Itoa(this.limiter.burst()))
+			writer.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if delay > 0 {
+				retryAfter(writer, delay)
+				this.ResponseError(ErrRateLimited, writer, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if charge := this.newRequestQuota(writer, request); charge != nil {
+			// the job is charged by StartJob once processing starts, requests
+			// rejected before that only count the bytes they sent
+			err := charge.Check(request.ContentLength, now)
+			if err != nil {
+				this.ResponseError(err, writer, http.StatusTooManyRequests)
+				return
+			}
+			if request.Body != nil {
+				request.Body = charge.ReadCloser(request.Body)
+			}
+			request = request.WithContext(withRequestQuota(request.Context(), charge))
+		}
+
+		handler(writer, request)
+	}
+}
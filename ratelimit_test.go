@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	limiter := NewRateLimiter(&RateLimitConfig{RequestsPerMinute: 60, Burst: 2})
+	now := time.Now()
+
+	if remaining, delay := limiter.Reserve("a", now); delay != 0 || remaining != 1 {
+		t.Errorf("unexpected first reserve %d %v", remaining, delay)
+	}
+	if remaining, delay := limiter.Reserve("a", now); delay != 0 || remaining != 0 {
+		t.Errorf("unexpected second reserve %d %v", remaining, delay)
+	}
+	if _, delay := limiter.Reserve("a", now); delay != time.Second {
+		t.Errorf("expected to wait a second, got %v", delay)
+	}
+	// other clients have their own bucket
+	if _, delay := limiter.Reserve("b", now); delay != 0 {
+		t.Errorf("unexpected delay for b %v", delay)
+	}
+	if _, delay := limiter.Reserve("a", now.Add(time.Second)); delay != 0 {
+		t.Errorf("bucket not refilled, delay %v", delay)
+	}
+}
+
+func TestHTTPService_Throttle(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		RateLimit: &RateLimitConfig{RequestsPerMinute: 1, Burst: 3},
+		Tenants: map[string]*TenantConfig{
+			"acme": &TenantConfig{Quota: &TenantQuota{DailyJobs: 5, DailyBytes: 10}},
+		},
+	})
+	handler := httpServer.Throttle(func(writer http.ResponseWriter, request *http.Request) {
+		ioutil.ReadAll(request.Body)
+		httpServer.startJob(request)
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/s3", bytes.NewBufferString(body))
+		req = req.WithContext(withTenant(req.Context(), &requestTenant{name: "acme"}))
+		writer := httptest.NewRecorder()
+		handler(writer, req)
+		return writer
+	}
+
+	writer := send("123456")
+	if writer.Code != http.StatusOK || writer.Header().Get("X-Quota-Jobs-Remaining") != "4" ||
+		writer.Header().Get("X-RateLimit-Remaining") != "2" {
+		t.Errorf("unexpected response %d %v", writer.Code, writer.Header())
+	}
+
+	// 6 of 10 bytes used
+	writer = send("12345")
+	if writer.Code != http.StatusTooManyRequests || writer.Header().Get("X-Quota-Bytes-Remaining") != "4" ||
+		len(writer.Header().Get("Retry-After")) <= 0 {
+		t.Errorf("expected byte quota to be exceeded, got %d %v", writer.Code, writer.Header())
+	}
+
+	send("")
+	writer = send("")
+	if writer.Code != http.StatusTooManyRequests || writer.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected rate limit, got %d %v", writer.Code, writer.Header())
+	}
+}
+
+func TestHTTPService_Throttle_ChargesStartedJobs(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		Tenants: map[string]*TenantConfig{
+			"acme": &TenantConfig{Quota: &TenantQuota{DailyJobs: 1, DailyBytes: 10}},
+		},
+	})
+	rejected := httpServer.Throttle(func(writer http.ResponseWriter, request *http.Request) {
+		httpServer.ResponseError(errors.New("invalid"), writer, http.StatusBadRequest)
+	})
+	started := httpServer.Throttle(func(writer http.ResponseWriter, request *http.Request) {
+		httpServer.createTask(request, TASK_KIND_SPLIT)
+		// bytes fetched server-side count as well
+		reader := httpServer.newWorker(request).quota.Reader(strings.NewReader("123456"))
+		if _, err := ioutil.ReadAll(reader); err != nil {
+			httpServer.ResponseError(err, writer, 500)
+		}
+	})
+
+	send := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/s3/url", strings.NewReader(body))
+		// chunked bodies have no length to check up front
+		req.ContentLength = -1
+		req = req.WithContext(withTenant(req.Context(), &requestTenant{name: "acme"}))
+		writer := httptest.NewRecorder()
+		handler(writer, req)
+		return writer
+	}
+
+	for i := 0; i < 3; i++ {
+		if writer := send(rejected, ""); writer.Code != http.StatusBadRequest {
+			t.Errorf("rejected request %d: unexpected status %d", i, writer.Code)
+		}
+	}
+
+	writer := send(started, "")
+	if writer.Code != http.StatusOK || writer.Header().Get("X-Quota-Jobs-Remaining") != "0" ||
+		writer.Header().Get("X-Quota-Bytes-Remaining") != "10" {
+		t.Errorf("unexpected response %d %v", writer.Code, writer.Header())
+	}
+	usage, _ := httpServer.quotas.Check("acme", &TenantQuota{}, 0, time.Now())
+	if usage.jobs != 1 || usage.bytes != 6 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	if writer := send(started, ""); writer.Code != http.StatusTooManyRequests {
+		t.Errorf("expected job quota to be exceeded, got %d", writer.Code)
+	}
+}
+
+func TestRequestQuota_Reader(t *testing.T) {
+	quota := &requestQuota{tracker: NewQuotaTracker(), tenant: "acme", quota: &TenantQuota{DailyBytes: 4}}
+	_, err := ioutil.ReadAll(quota.Reader(strings.NewReader("123456")))
+	if err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	var missing *requestQuota
+	if data, err := ioutil.ReadAll(missing.Reader(strings.NewReader("123456"))); err != nil || len(data) != 6 {
+		t.Errorf("unexpected read %q %v", data, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const STORAGE_S3 = "s3"
+
+const STORAGE_OSS = "oss"
+
+var ErrUnknownStorage = errors.New("unknown storage backend")
+
+// swagger:model DirectUploadParams
+type DirectUploadParams struct {
+	// 存储 s3/oss
+	Storage string `json:"storage"`
+	// 直传参数，s3 为 S3PresignedPost，oss 为 OSS 表单参数
+	Params interface{} `json:"params"`
+}
+
+// fileOpener opens the input file of a form field, either an uploaded file
+// or an object already in storage.
+type fileOpener func(field string) (multipart.File, *multipart.FileHeader, error)
+
+// objectFile is a storage object fetched into the temp path, it is removed
+// once closed.
+type objectFile struct {
+	*os.File
+}
+
+func (this *objectFile) Close() error {
+	err := this.File.Close()
+	os.Remove(this.Name())
+
+	return err
+}
+
+// storageBackend resolves the backend source objects are read from: the
+// requested one, else Config.SourceStorage, else S3 when configured.
+func (this *Worker) storageBackend(backend string) (string, error) {
+	if len(backend) <= 0 {
+		backend = this.Conf.SourceStorage
+	}
+	if len(backend) <= 0 {
+		backend = STORAGE_S3
+		if this.Conf.S3 == nil && this.Conf.OSS != nil {
+			backend = STORAGE_OSS
+		}
+	}
+
+	switch backend {
+	case STORAGE_S3:
+		if this.Conf.S3 == nil {
+			return "", fmt.Errorf("%w: %s is not configured", ErrUnknownStorage, backend)
+		}
+	case STORAGE_OSS:
+		if this.Conf.OSS == nil {
+			return "", fmt.Errorf("%w: %s is not configured", ErrUnknownStorage, backend)
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownStorage, backend)
+	}
+
+	return backend, nil
+}
+
+func (this *Worker) SourceStorage(backend string) (IStorage, error) {
+	backend, err := this.storageBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == STORAGE_OSS {
+		storage, err := NewOSSStorage(this.Conf.OSS)
+		if err != nil {
+			return nil, err
+		}
+		return NewMeteredStorage(STORAGE_OSS, storage), nil
+	}
+
+	return NewS3Storage(this.Conf.S3)
+}
+
+// objectKey makes key relative to the storage prefix, keys returned by
+// /oss/params include the prefix while IStorage.Get adds it again.
+func objectKey(prefix string, key string) string {
+	key = strings.TrimLeft(path.Clean("/"+key), "/")
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) > 0 && strings.HasPrefix(key, prefix+"/") {
+		key = strings.TrimPrefix(key, prefix+"/")
+	}
+
+	return "/" + key
+}
+
+func (this *Worker) storagePrefix(backend string) string {
+	if backend == STORAGE_OSS {
+		return this.Conf.OSS.PrefixPath
+	}
+
+	return this.Conf.S3.PrefixPath
+}
+
+// FetchObject copies the object key of backend into the temp path, so it
+// can be read more than once like an uploaded file.
+func (this *Worker) FetchObject(backend string, key string) (multipart.File, *multipart.FileHeader, error) {
+	if len(strings.Trim(key, "/")) <= 0 {
+		return nil, nil, http.ErrMissingFile
+	}
+	backend, err := this.storageBackend(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	storage, err := this.SourceStorage(backend)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+
+	log.Infof("fetch %s:%s", backend, key)
+
+	return this.fetchObject(storage, objectKey(this.storagePrefix(backend), key))
+}
+
+func (this *Worker) fetchObject(storage IStorage, key string) (multipart.File, *multipart.FileHeader, error) {
+	reader, err := storage.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	err = os.MkdirAll(this.Conf.TempPath, os.ModePerm)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	file, err := os.Create(filepath.Join(this.Conf.TempPath, uuid.NewV4().String()+path.Ext(key)))
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	object := &objectFile{File: file}
+
+	size, err := io.Copy(file, this.quota.Reader(reader))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Error(err)
+		object.Close()
+		return nil, nil, err
+	}
+
+	return object, &multipart.FileHeader{Filename: path.Base(key), Size: size}, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestObjectKey(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+		expect string
+	}{
+		{"spin360", "spin360/a.mp4", "/a.mp4"},
+		{"/spin360", "/spin360/a.mp4", "/a.mp4"},
+		{"spin360", "a.mp4", "/a.mp4"},
+		{"spin360", "spin360x/a.mp4", "/spin360x/a.mp4"},
+		{"", "dir/../a.mp4", "/a.mp4"},
+		{"", "../../etc/passwd", "/etc/passwd"},
+	}
+
+	for _, c := range cases {
+		if key := objectKey(c.prefix, c.key); key != c.expect {
+			t.Errorf("objectKey(%q, %q) = %q, expected %q", c.prefix, c.key, key, c.expect)
+		}
+	}
+}
+
+func TestWorker_StorageBackend(t *testing.T) {
+	worker := NewWorker(&Config{OSS: &OSSConfig{}})
+	if backend, err := worker.storageBackend(""); err != nil || backend != STORAGE_OSS {
+		t.Errorf("expected the only configured backend, got %q %v", backend, err)
+	}
+	if _, err := worker.storageBackend(STORAGE_S3); err == nil {
+		t.Error("expected unconfigured s3 to fail")
+	}
+
+	worker = NewWorker(&Config{S3: &S3Config{}, OSS: &OSSConfig{}, SourceStorage: STORAGE_OSS})
+	if backend, _ := worker.storageBackend(""); backend != STORAGE_OSS {
+		t.Errorf("expected source_storage, got %q", backend)
+	}
+	if backend, _ := worker.storageBackend(STORAGE_S3); backend != STORAGE_S3 {
+		t.Errorf("expected requested backend, got %q", backend)
+	}
+	if _, err := worker.storageBackend("ftp"); err == nil {
+		t.Error("expected unknown backend to fail")
+	}
+}
+
+func TestWorker_FetchObject(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	storage := newMemoryStorage()
+	storage.objects["/a.mp4"] = "video"
+	worker := NewWorker(&Config{TempPath: tempPath})
+
+	if _, _, err := worker.fetchObject(storage, "/b.mp4"); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	file, header, err := worker.fetchObject(storage, "/a.mp4")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	data, _ := ioutil.ReadAll(file)
+	if string(data) != "video" || header.Size != 5 || header.Filename != "a.mp4" {
+		t.Errorf("unexpected object %q %+v", data, header)
+	}
+
+	file.Close()
+	if files, _ := ioutil.ReadDir(tempPath); len(files) != 0 {
+		t.Errorf("temp file not removed on close: %d files", len(files))
+	}
+}
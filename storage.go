@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -19,6 +21,16 @@ type S3Storage struct {
 	session *session.Session
 }
 
+var ErrObjectNotFound = errors.New("object not found")
+
+// content types that can not be sniffed from the file header
+var extensionContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".json": "application/json",
+	".svg":  "image/svg+xml",
+}
+
 type UploadOptions struct {
 	ContentType string
 }
@@ -27,6 +39,8 @@ type IStorage interface {
 	Upload(localPath string, Key string) (string, string, error)
 	PutContent(content string, Key string, opt *UploadOptions) (string, string, error)
 	Get(Key string) (io.Reader, error)
+	// Head returns ErrObjectNotFound when Key does not exist
+	Head(Key string) error
 	URL(Key string) string
 }
 
@@ -40,10 +54,10 @@ func NewS3Storage(conf *S3Config) (IStorage, error) {
 		return nil, err
 	}
 
-	return &S3Storage{
+	return NewMeteredStorage(STORAGE_S3, &S3Storage{
 		Conf:    conf,
 		session: sess,
-	}, nil
+	}), nil
 }
 
 func (this *S3Storage) Get(Key string) (io.Reader, error) {
@@ -56,6 +70,9 @@ func (this *S3Storage) Get(Key string) (io.Reader, error) {
 		Key: aws.String(path),
 	})
 
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrObjectNotFound
+	}
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -64,7 +81,28 @@ func (this *S3Storage) Get(Key string) (io.Reader, error) {
 	return out.Body, nil
 }
 
+func (this *S3Storage) Head(Key string) error {
+	path := filepath.ToSlash(filepath.Join(this.Conf.PrefixPath, Key))
+
+	svc := s3.New(this.session, aws.NewConfig())
+
+	_, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(this.Conf.Bucket),
+		Key:    aws.String(path),
+	})
+	// HEAD responses have no body, a missing key is reported as NotFound
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		return ErrObjectNotFound
+	}
+
+	return err
+}
+
 func (this *S3Storage) GetFileContentType(localPath string) (string, error) {
+	if contentType, ok := extensionContentTypes[strings.ToLower(filepath.Ext(localPath))]; ok {
+		return contentType, nil
+	}
+
 	file, err := os.Open(localPath)
 	if err != nil {
 		return "", err
@@ -132,8 +170,11 @@ func (this *S3Storage) PutContent(content string, Key string, opt *UploadOptions
 		ACL:         aws.String("public-read"),
 		ContentType: aws.String(contentType),
 	})
+	if err != nil {
+		return path, "", err
+	}
 
-	return path, info.Location, err
+	return path, info.Location, nil
 }
 
 func (this *S3Storage) URL(Key string) string {
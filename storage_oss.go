@@ -16,6 +16,7 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -40,8 +41,8 @@ type OSSStorage struct {
 }
 
 type OSSWebPostConfigStruct struct {
-	Expiration string     `json:"expiration"`
-	Conditions [][]string `json:"conditions"`
+	Expiration string          `json:"expiration"`
+	Conditions [][]interface{} `json:"conditions"`
 }
 
 type OSSWebFormMultipart struct {
@@ -72,19 +73,23 @@ func NewOSSStorage(conf *OSSConfig) (*OSSStorage, error) {
 	}, nil
 }
 
-func (this *OSSStorage) GenerateFormMultipart(UploadFileName string, expire time.Duration, callback string) (*OSSWebFormMultipart, error) {
-
-	callbackParam := OSSCallbackParam{
-		CallbackUrl:      fmt.Sprintf("%s;https://aliyun-oss.mixmedia.com/", callback),
-		CallbackBody:     `{"mimeType":${mimeType}, "size":${size}, "filename":${object}, "bucket":${bucket}}`,
-		CallbackBodyType: "application/json",
-	}
+// GenerateFormMultipart signs a browser upload under the prefix, OSS posts
+// the result to callback when set and enforces the size limits of conf.
+func (this *OSSStorage) GenerateFormMultipart(UploadFileName string, expire time.Duration, callback string, conf *OSSUploadConfig) (*OSSWebFormMultipart, error) {
+	base64CallBackParam := ""
+	if len(callback) > 0 {
+		callbackParam := OSSCallbackParam{
+			CallbackUrl:      callback,
+			CallbackBody:     `{"mimeType":${mimeType}, "size":${size}, "filename":${object}, "bucket":${bucket}}`,
+			CallbackBodyType: "application/json",
+		}
 
-	jsonCallBackParam, err := json.Marshal(callbackParam)
-	if err != nil {
-		return nil, err
+		jsonCallBackParam, err := json.Marshal(callbackParam)
+		if err != nil {
+			return nil, err
+		}
+		base64CallBackParam = base64.StdEncoding.EncodeToString(jsonCallBackParam)
 	}
-	base64CallBackParam := base64.StdEncoding.EncodeToString(jsonCallBackParam)
 
 	loc, err := time.LoadLocation("Asia/Shanghai")
 	if err != nil {
@@ -92,16 +97,26 @@ func (this *OSSStorage) GenerateFormMultipart(UploadFileName string, expire time
 	}
 	expireAt := time.Now().In(loc).Add(expire)
 
+	// the trailing slash keeps "spin360/acme" from signing "spin360/acme2/..."
+	keyPrefix := strings.TrimSuffix(this.Conf.PrefixPath, "/") + "/"
 	uploadOptions := OSSWebPostConfigStruct{
 		Expiration: expireAt.Format("2006-01-02T15:04:05Z"),
-		Conditions: [][]string{
-			[]string{
+		Conditions: [][]interface{}{
+			[]interface{}{
 				"starts-with",
 				"$key",
-				this.Conf.PrefixPath,
+				keyPrefix,
 			},
 		},
 	}
+	if conf != nil && (conf.MinSize > 0 || conf.MaxSize > 0) {
+		maxSize := conf.MaxSize
+		if maxSize <= 0 {
+			maxSize = math.MaxInt64
+		}
+		uploadOptions.Conditions = append(uploadOptions.Conditions,
+			[]interface{}{"content-length-range", conf.MinSize, maxSize})
+	}
 
 	jsonOpions, err := json.Marshal(uploadOptions)
 	if err != nil {
@@ -117,7 +132,7 @@ func (this *OSSStorage) GenerateFormMultipart(UploadFileName string, expire time
 	}
 	base64signedUploadOptions := base64.StdEncoding.EncodeToString(signHash.Sum(nil))
 
-	filename := fmt.Sprintf("%s/%s%s", this.Conf.PrefixPath, uuid.NewV4(), filepath.Ext(UploadFileName))
+	filename := fmt.Sprintf("%s%s%s", keyPrefix, uuid.NewV4(), filepath.Ext(UploadFileName))
 
 	return &OSSWebFormMultipart{
 		AccessKeyId:   this.Conf.AccessKey,
@@ -140,6 +155,10 @@ func VerifyWebUploadCallback(req *http.Request) (bool, error) {
 		log.Error(err)
 		return false, err
 	}
+	// anyone can sign with a key of their own, only trust the OSS ones
+	if !isOSSPublicKeyURL(string(publicKeyURL)) {
+		return false, fmt.Errorf("untrusted public key url %s", publicKeyURL)
+	}
 	client := http.Client{
 		Timeout: 10 * time.Second,
 	}
@@ -284,6 +303,9 @@ func (this *OSSStorage) Get(Key string) (io.Reader, error) {
 	remoteKey := filepath.ToSlash(fmt.Sprintf("%s%s", this.Conf.PrefixPath, Key))
 
 	reader, err := bucket.GetObject(remoteKey)
+	if serr, ok := err.(oss.ServiceError); ok && serr.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -292,6 +314,24 @@ func (this *OSSStorage) Get(Key string) (io.Reader, error) {
 	return reader, nil
 }
 
+func (this *OSSStorage) Head(Key string) error {
+	bucket, err := this.client.Bucket(this.Conf.Bucket)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	exist, err := bucket.IsObjectExist(filepath.ToSlash(fmt.Sprintf("%s%s", this.Conf.PrefixPath, Key)))
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return ErrObjectNotFound
+	}
+
+	return nil
+}
+
 func (this *OSSStorage) URL(Key string) string {
 	return filepath.ToSlash(fmt.Sprintf("//%s.%s%s%s", this.Conf.Bucket, this.Conf.EndPoint, this.Conf.PrefixPath, Key))
 }
\ No newline at end of file
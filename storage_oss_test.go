@@ -24,7 +24,7 @@ func Test_GenerateFormMultipart(t *testing.T) {
 		return
 	}
 
-	params, err := oss.GenerateFormMultipart("HelloWorld.png", 10*time.Minute, "http://127.0.0.1/")
+	params, err := oss.GenerateFormMultipart("HelloWorld.png", 10*time.Minute, "http://127.0.0.1/", nil)
 	if err != nil {
 		t.Error(err)
 		t.Fail()
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const S3_POST_ALGORITHM = "AWS4-HMAC-SHA256"
+
+const S3_POST_EXPIRE = time.Hour
+
+// largest object S3 accepts in a single POST
+const S3_POST_MAX_SIZE = 5 << 30
+
+var ErrContentTypeNotAllowed = errors.New("content type is not allowed")
+
+// S3UploadConfig limits browser direct uploads signed by /s3/params.
+type S3UploadConfig struct {
+	// accepted content type prefixes, e.g. "video/", any when empty
+	ContentTypes []string `json:"content_types"`
+	// size limits in bytes, 0 is unlimited
+	MinSize int64 `json:"min_size"`
+	MaxSize int64 `json:"max_size"`
+	// seconds the policy is valid, default 3600
+	Expire int `json:"expire"`
+}
+
+// swagger:model S3PresignedPost
+type S3PresignedPost struct {
+	// 表单提交地址（POST multipart/form-data）
+	URL string `json:"url"`
+	// 须原样提交的表单字段，文件字段 file 放在最后
+	Fields map[string]string `json:"fields"`
+	// 上传后的对象 key，可用于 /s3/key
+	Key string `json:"key"`
+	// 过期时间
+	Expires time.Time `json:"expires"`
+}
+
+func (this *S3UploadConfig) expire() time.Duration {
+	if this.Expire > 0 {
+		return time.Duration(this.Expire) * time.Second
+	}
+
+	return S3_POST_EXPIRE
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// sigV4Key derives the AWS signature version 4 signing key.
+func sigV4Key(secret string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+
+	return hmacSHA256(key, "aws4_request")
+}
+
+// PresignPost signs a browser form upload of filename under the prefix, the
+// content type is pinned when given and must match conf when it is limited.
+func (this *S3Storage) PresignPost(filename string, contentType string, conf *S3UploadConfig, now time.Time) (*S3PresignedPost, error) {
+	if conf == nil {
+		conf = &S3UploadConfig{}
+	}
+	if len(conf.ContentTypes) > 0 && !matchContentType(contentType, conf.ContentTypes) {
+		return nil, fmt.Errorf("%w: %q", ErrContentTypeNotAllowed, contentType)
+	}
+
+	prefix := strings.Trim(filepath.ToSlash(this.Conf.PrefixPath), "/")
+	keyPrefix := ""
+	if len(prefix) > 0 {
+		keyPrefix = prefix + "/"
+	}
+	key := keyPrefix + uuid.NewV4().String() + strings.ToLower(path.Ext(filename))
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	expires := now.Add(conf.expire())
+	fields := map[string]string{
+		"key":              key,
+		"acl":              "public-read",
+		"x-amz-algorithm":  S3_POST_ALGORITHM,
+		"x-amz-credential": fmt.Sprintf("%s/%s/%s/s3/aws4_request", this.Conf.AccessKey, date, this.Conf.Region),
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	conditions := []interface{}{
+		map[string]string{"bucket": this.Conf.Bucket},
+		[]interface{}{"starts-with", "$key", keyPrefix},
+		map[string]string{"acl": fields["acl"]},
+		map[string]string{"x-amz-algorithm": fields["x-amz-algorithm"]},
+		map[string]string{"x-amz-credential": fields["x-amz-credential"]},
+		map[string]string{"x-amz-date": fields["x-amz-date"]},
+	}
+	if len(contentType) > 0 {
+		fields["Content-Type"] = contentType
+		conditions = append(conditions, map[string]string{"Content-Type": contentType})
+	}
+	if conf.MinSize > 0 || conf.MaxSize > 0 {
+		maxSize := conf.MaxSize
+		if maxSize <= 0 {
+			maxSize = S3_POST_MAX_SIZE
+		}
+		conditions = append(conditions, []interface{}{"content-length-range", conf.MinSize, maxSize})
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": expires.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+	signingKey := sigV4Key(this.Conf.SecretKey, date, this.Conf.Region, "s3")
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, fields["policy"]))
+
+	return &S3PresignedPost{
+		// path style, bucket names containing dots break virtual hosted TLS
+		URL:     fmt.Sprintf("https://s3.%s.amazonaws.com/%s", this.Conf.Region, this.Conf.Bucket),
+		Fields:  fields,
+		Key:     key,
+		Expires: expires,
+	}, nil
+}
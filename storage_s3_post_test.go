@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4Key(t *testing.T) {
+	// example from the AWS signature version 4 documentation
+	key := sigV4Key("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if hex.EncodeToString(key) != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("unexpected signing key %x", key)
+	}
+}
+
+func TestS3Storage_PresignPost(t *testing.T) {
+	s3 := &S3Storage{Conf: &S3Config{
+		AccessKey:  "AKIAEXAMPLE",
+		SecretKey:  "secret",
+		Bucket:     "s3.test.example.com",
+		Region:     "ap-east-1",
+		PrefixPath: "/spin360",
+	}}
+	conf := &S3UploadConfig{ContentTypes: []string{"video/"}, MaxSize: 1000}
+	now := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	if _, err := s3.PresignPost("a.jpg", "image/jpeg", conf, now); !errors.Is(err, ErrContentTypeNotAllowed) {
+		t.Errorf("expected ErrContentTypeNotAllowed, got %v", err)
+	}
+
+	post, err := s3.PresignPost("Spin.MP4", "video/mp4", conf, now)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if post.URL != "https://s3.ap-east-1.amazonaws.com/s3.test.example.com" ||
+		!strings.HasPrefix(post.Key, "spin360/") || !strings.HasSuffix(post.Key, ".mp4") ||
+		post.Fields["key"] != post.Key || post.Fields["Content-Type"] != "video/mp4" ||
+		post.Fields["x-amz-credential"] != "AKIAEXAMPLE/20200501/ap-east-1/s3/aws4_request" {
+		t.Errorf("unexpected post %+v", post)
+	}
+
+	signingKey := sigV4Key("secret", "20200501", "ap-east-1", "s3")
+	if post.Fields["x-amz-signature"] != hex.EncodeToString(hmacSHA256(signingKey, post.Fields["policy"])) {
+		t.Error("signature does not match the policy")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(post.Fields["policy"])
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	policy := struct {
+		Expiration string        `json:"expiration"`
+		Conditions []interface{} `json:"conditions"`
+	}{}
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if policy.Expiration != "2020-05-01T09:00:00.000Z" {
+		t.Errorf("unexpected expiration %s", policy.Expiration)
+	}
+	if !strings.Contains(string(data), `["starts-with","$key","spin360/"]`) ||
+		!strings.Contains(string(data), `["content-length-range",0,1000]`) ||
+		!strings.Contains(string(data), `{"Content-Type":"video/mp4"}`) {
+		t.Errorf("unexpected conditions %s", data)
+	}
+}
+
+func TestHTTPService_GetUploadParams(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		S3:            &S3Config{Bucket: "bucket", Region: "ap-east-1", PrefixPath: "/spin360"},
+		S3Upload:      &S3UploadConfig{ContentTypes: []string{"video/"}},
+		SourceStorage: STORAGE_S3,
+	})
+	handler := httpServer.getHTTPHandler()
+
+	cases := []struct {
+		query  string
+		status int
+	}{
+		{"filename=a.mp4&contentType=video/mp4", http.StatusOK},
+		{"filename=a.jpg&contentType=image/jpeg", http.StatusBadRequest},
+		{"filename=a.mp4&storage=oss", http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/upload/params?"+c.query, nil)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		if writer.Code != c.status {
+			t.Errorf("case %d: expected %d, got %d", i, c.status, writer.Code)
+			continue
+		}
+		if c.status == http.StatusOK && !strings.Contains(writer.Body.String(), `"storage":"s3"`) {
+			t.Errorf("case %d: unexpected body %s", i, writer.Body.String())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+)
+
+const TENANT_HEADER = "X-Tenant-ID"
+
+// API keys with this tenant may pick any tenant with TENANT_HEADER
+const TENANT_ANY = "*"
+
+var tenantPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,62}$`)
+
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+var ErrTenantMismatch = errors.New("credentials do not belong to this tenant")
+
+type tenantContextKey struct{}
+
+type TenantQuota struct {
+	// processing jobs a tenant may start per day, 0 is unlimited
+	DailyJobs int `json:"daily_jobs"`
+	// uploaded bytes a tenant may send per day, 0 is unlimited
+	DailyBytes int64 `json:"daily_bytes"`
+}
+
+// TenantConfig overrides the storage of a tenant, empty fields fall back
+// to the global config and an empty prefix becomes {global prefix}/{tenant}.
+type TenantConfig struct {
+	S3    *S3Config    `json:"s3"`
+	OSS   *OSSConfig   `json:"aliyun-oss"`
+	Quota *TenantQuota `json:"quota"`
+}
+
+type requestTenant struct {
+	name string
+	conf *Config
+}
+
+func withTenant(ctx context.Context, tenant *requestTenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+func getTenant(ctx context.Context) *requestTenant {
+	tenant, _ := ctx.Value(tenantContextKey{}).(*requestTenant)
+
+	return tenant
+}
+
+// GetTenant is the tenant a request was resolved to, "" for the default one.
+func GetTenant(ctx context.Context) string {
+	if tenant := getTenant(ctx); tenant != nil {
+		return tenant.name
+	}
+
+	return ""
+}
+
+func tenantString(value string, fallback string) string {
+	if len(value) > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+func tenantS3Config(base *S3Config, override *S3Config, tenant string) *S3Config {
+	conf := &S3Config{}
+	if base != nil {
+		*conf = *base
+	}
+	if override == nil {
+		override = &S3Config{}
+	}
+
+	return &S3Config{
+		AccessKey:   tenantString(override.AccessKey, conf.AccessKey),
+		SecretKey:   tenantString(override.SecretKey, conf.SecretKey),
+		Bucket:      tenantString(override.Bucket, conf.Bucket),
+		Region:      tenantString(override.Region, conf.Region),
+		PrefixPath:  tenantString(override.PrefixPath, path.Join("/", conf.PrefixPath, tenant)),
+		VR360Prefix: tenantString(override.VR360Prefix, path.Join("/", conf.VR360Prefix, tenant)),
+	}
+}
+
+func tenantOSSConfig(base *OSSConfig, override *OSSConfig, tenant string) *OSSConfig {
+	if base == nil && override == nil {
+		return nil
+	}
+	conf := &OSSConfig{}
+	if base != nil {
+		*conf = *base
+	}
+	if override == nil {
+		override = &OSSConfig{}
+	}
+
+	return &OSSConfig{
+		AccessKey:  tenantString(override.AccessKey, conf.AccessKey),
+		SecretKey:  tenantString(override.SecretKey, conf.SecretKey),
+		Bucket:     tenantString(override.Bucket, conf.Bucket),
+		EndPoint:   tenantString(override.EndPoint, conf.EndPoint),
+		PrefixPath: tenantString(override.PrefixPath, path.Join(conf.PrefixPath, tenant)),
+	}
+}
+
+// ForTenant returns the config a tenant's requests run with, its storage
+// is scoped to the tenant so configs, assets and hashes do not collide.
+func (this *Config) ForTenant(tenant string) (*Config, error) {
+	if len(tenant) <= 0 {
+		return this, nil
+	}
+	tenantConf, ok := this.Tenants[tenant]
+	if !ok || !tenantPattern.MatchString(tenant) {
+		return nil, ErrUnknownTenant
+	}
+	if tenantConf == nil {
+		tenantConf = &TenantConfig{}
+	}
+
+	conf := *this
+	conf.S3 = tenantS3Config(this.S3, tenantConf.S3, tenant)
+	conf.OSS = tenantOSSConfig(this.OSS, tenantConf.OSS, tenant)
+
+	return &conf, nil
+}
+
+// TenantQuota is the quota of tenant, nil when unlimited.
+func (this *Config) TenantQuota(tenant string) *TenantQuota {
+	if tenantConf, ok := this.Tenants[tenant]; ok && tenantConf != nil {
+		return tenantConf.Quota
+	}
+
+	return nil
+}
+
+// ResolveTenant is the router middleware picking the tenant of a request.
+// Credentials keep their own tenant, the default one when they have none,
+// only admin API keys and requests without credentials use the header.
+func (this *HTTPService) ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		tenant := request.Header.Get(TENANT_HEADER)
+		if principal := GetPrincipal(request.Context()); principal != nil && !principal.AnyTenant {
+			if len(tenant) > 0 && tenant != principal.Tenant {
+				this.ResponseError(ErrTenantMismatch, writer, http.StatusForbidden)
+				return
+			}
+			tenant = principal.Tenant
+		}
+
+		conf, err := this.config.ForTenant(tenant)
+		if err != nil {
+			this.ResponseError(err, writer, http.StatusForbidden)
+			return
+		}
+
+		ctx := withTenant(request.Context(), &requestTenant{name: tenant, conf: conf})
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// requestConfig is the config scoped to the tenant of request.
+func (this *HTTPService) requestConfig(request *http.Request) *Config {
+	if tenant := getTenant(request.Context()); tenant != nil {
+		return tenant.conf
+	}
+
+	return this.config
+}
+
+func (this *HTTPService) newWorker(request *http.Request) *Worker {
+	worker := NewWorker(this.requestConfig(request))
+	worker.quota = getRequestQuota(request.Context())
+
+	return worker
+}
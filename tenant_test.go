@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_ForTenant(t *testing.T) {
+	conf := &Config{
+		S3:  &S3Config{AccessKey: "key", Bucket: "shared", PrefixPath: "/spin360", VR360Prefix: "/vr360"},
+		OSS: &OSSConfig{Bucket: "oss", PrefixPath: "spin360"},
+		Tenants: map[string]*TenantConfig{
+			"acme": nil,
+			"globex": &TenantConfig{
+				S3: &S3Config{AccessKey: "globex-key", Bucket: "globex", PrefixPath: "/media"},
+			},
+		},
+	}
+
+	acme, err := conf.ForTenant("acme")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if acme.S3.PrefixPath != "/spin360/acme" || acme.S3.VR360Prefix != "/vr360/acme" ||
+		acme.S3.Bucket != "shared" || acme.OSS.PrefixPath != "spin360/acme" {
+		t.Errorf("unexpected acme storage %+v %+v", acme.S3, acme.OSS)
+	}
+
+	globex, err := conf.ForTenant("globex")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if globex.S3.AccessKey != "globex-key" || globex.S3.Bucket != "globex" ||
+		globex.S3.PrefixPath != "/media" || globex.S3.VR360Prefix != "/vr360/globex" {
+		t.Errorf("unexpected globex storage %+v", globex.S3)
+	}
+	if conf.S3.PrefixPath != "/spin360" {
+		t.Errorf("global config was modified %+v", conf.S3)
+	}
+
+	if _, err := conf.ForTenant("initech"); err != ErrUnknownTenant {
+		t.Errorf("expected ErrUnknownTenant, got %v", err)
+	}
+	if def, err := conf.ForTenant(""); err != nil || def != conf {
+		t.Errorf("default tenant should use the global config, %v", err)
+	}
+}
+
+func TestHTTPService_ResolveTenant(t *testing.T) {
+	httpServer := NewHTTP(&Config{
+		S3: &S3Config{},
+		Auth: &AuthConfig{
+			APIKeys: []*APIKeyConfig{
+				&APIKeyConfig{Name: "acme", Key: "acme-key", Scopes: []string{SCOPE_UPLOAD}, Tenant: "acme"},
+				&APIKeyConfig{Name: "admin", Key: "admin-key", Scopes: []string{SCOPE_UPLOAD}, Tenant: TENANT_ANY},
+				&APIKeyConfig{Name: "default", Key: "default-key", Scopes: []string{SCOPE_UPLOAD}},
+			},
+			JWT: &JWTConfig{HS256Secret: "secret"},
+		},
+		Tenants: map[string]*TenantConfig{"acme": nil, "globex": nil},
+	})
+	handler := httpServer.getHTTPHandler()
+
+	// a task started by acme
+	req := httptest.NewRequest(http.MethodGet, "/task", nil)
+	req = req.WithContext(withTenant(req.Context(), &requestTenant{name: "acme"}))
+	task := httpServer.createTask(req, TASK_KIND_SPLIT)
+
+	token := signTestJWT(t, JWT_ALG_HS256, map[string]interface{}{
+		"sub": "user", "scope": SCOPE_UPLOAD, "exp": time.Now().Add(time.Hour).Unix(),
+	}, hs256Signer("secret"))
+
+	cases := []struct {
+		key    string
+		tenant string
+		status int
+	}{
+		{"acme-key", "", http.StatusOK},
+		{"acme-key", "acme", http.StatusOK},
+		{"acme-key", "globex", http.StatusForbidden},
+		{"admin-key", "acme", http.StatusOK},
+		// other tenants and the default tenant do not see the task
+		{"admin-key", "globex", http.StatusInternalServerError},
+		{"admin-key", "", http.StatusInternalServerError},
+		{"admin-key", "initech", http.StatusForbidden},
+		// credentials without a tenant stay on the default tenant
+		{"default-key", "acme", http.StatusForbidden},
+		{"default-key", "", http.StatusInternalServerError},
+		{"Bearer " + token, "acme", http.StatusForbidden},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/task?id="+task.ID, nil)
+		if strings.HasPrefix(c.key, "Bearer ") {
+			req.Header.Set("Authorization", c.key)
+		} else {
+			req.Header.Set(API_KEY_HEADER, c.key)
+		}
+		if len(c.tenant) > 0 {
+			req.Header.Set(TENANT_HEADER, c.tenant)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		if writer.Code != c.status {
+			t.Errorf("case %d: expected %d, got %d", i, c.status, writer.Code)
+		}
+	}
+}
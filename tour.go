@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const TOUR_PREFIX = "tour"
+
+// swagger:parameters tourParams
+type TourParams struct {
+	//in: body
+	Body *PannellumTour
+}
+
+//
+// Pannellum 多场景导览配置
+//
+// swagger:model PannellumTour
+type PannellumTour struct {
+	// 导览全局配置
+	//
+	// required: true
+	Default *TourDefault `json:"default"`
+	// 场景配置, key 为场景ID
+	//
+	// required: true
+	Scenes map[string]*TourScene `json:"scenes"`
+}
+
+type TourDefault struct {
+	// 初始场景ID
+	//
+	// required: true
+	FirstScene string `json:"firstScene"`
+	// 作者
+	Author string `json:"author,omitempty"`
+	// 场景切换淡入时间（毫秒）
+	SceneFadeDuration int `json:"sceneFadeDuration,omitempty"`
+}
+
+type TourScene struct {
+	// 场景标题
+	Title string `json:"title,omitempty"`
+	// 初始水平视场角
+	Hfov float64 `json:"hfov,omitempty"`
+	// 初始视角
+	Pitch float64 `json:"pitch,omitempty"`
+	// 初始视角
+	Yaw float64 `json:"yaw,omitempty"`
+	*PannellumConfig
+}
+
+// swagger:parameters tourSceneParams
+type TourSceneParams struct {
+	//in: body
+	Body *TourSceneRequest
+}
+
+// swagger:model TourSceneRequest
+type TourSceneRequest struct {
+	// 场景ID
+	//
+	// required: true
+	SceneId string `json:"sceneId"`
+	// 场景标题
+	Title string `json:"title"`
+	// /vr360/s3 返回的配置 URL 或配置 hash
+	//
+	// required: true
+	Config string `json:"config"`
+	// 初始水平视场角
+	Hfov float64 `json:"hfov"`
+	// 初始视角
+	Pitch float64 `json:"pitch"`
+	// 初始视角
+	Yaw float64 `json:"yaw"`
+	// 是否设为初始场景
+	First bool `json:"first"`
+}
+
+// swagger:parameters tourLinkParams
+type TourLinkParams struct {
+	//in: body
+	Body *TourLinkRequest
+}
+
+// swagger:model TourLinkRequest
+type TourLinkRequest struct {
+	// 热点所在场景ID
+	//
+	// required: true
+	From string `json:"from"`
+	// 目标场景ID
+	//
+	// required: true
+	To string `json:"to"`
+	// 热点说明文字, 可为字符串或 locale 到文字的映射
+	Text LocalizedText `json:"text"`
+	// 热点三维坐标
+	Pitch float64 `json:"pitch"`
+	// 热点三维坐标
+	Yaw float64 `json:"yaw"`
+	// 切换后的视角
+	TargetPitch *float64 `json:"targetPitch"`
+	// 切换后的视角
+	TargetYaw *float64 `json:"targetYaw"`
+}
+
+func NewPannellumTour() *PannellumTour {
+	return &PannellumTour{
+		Default: &TourDefault{},
+		Scenes:  make(map[string]*TourScene),
+	}
+}
+
+func (this *PannellumTour) Validate() error {
+	if this.Default == nil {
+		this.Default = &TourDefault{}
+	}
+	if this.Scenes == nil {
+		this.Scenes = make(map[string]*TourScene)
+	}
+	if len(this.Scenes) <= 0 {
+		return nil
+	}
+
+	if _, ok := this.Scenes[this.Default.FirstScene]; !ok {
+		return fmt.Errorf("first scene %q not found", this.Default.FirstScene)
+	}
+	for id, scene := range this.Scenes {
+		if scene == nil || scene.PannellumConfig == nil {
+			return fmt.Errorf("scene %q has no panorama", id)
+		}
+		for _, hotSpot := range scene.HotSpot {
+			// links to other panoramas are not part of the tour
+			if hotSpot.Type != TYPE_PANNELLUM_HOTSPOT_SCENE || hotSpot.Target != nil {
+				continue
+			}
+			if _, ok := this.Scenes[hotSpot.SceneId]; !ok {
+				return fmt.Errorf("scene %q links to unknown scene %q", id, hotSpot.SceneId)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ConfigHash extracts the config hash from a /vr360/s3 result URL, plain
+// hashes are returned untouched.
+func ConfigHash(config string) string {
+	return strings.TrimSuffix(path.Base(config), ".json")
+}
+
+func (this *Worker) getTourKey(hash string) string {
+	return fmt.Sprintf("%s/%s.json", TOUR_PREFIX, hash)
+}
+
+func (this *Worker) SaveTour(tour *PannellumTour, hashArgs ...string) (string, error) {
+	hash := fmt.Sprintf("%s", uuid.NewV4())
+	if len(hashArgs) > 0 {
+		hash = hashArgs[0]
+	}
+	err := ValidateConfigHash(hash)
+	if err != nil {
+		return "", err
+	}
+
+	err = tour.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	s3, err := NewS3Storage(this.GetVR360S3Config())
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	err = encoder.Encode(tour)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	_, url, err := s3.PutContent(buf.String(), this.getTourKey(hash), &UploadOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	return url, nil
+}
+
+func (this *Worker) GetTour(hash string) (*PannellumTour, error) {
+	err := ValidateConfigHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s3, err := NewS3Storage(this.GetVR360S3Config())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	reader, err := s3.Get(this.getTourKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	tour := NewPannellumTour()
+	decoder := json.NewDecoder(reader)
+	err = decoder.Decode(tour)
+	if err != nil {
+		return nil, err
+	}
+
+	return tour, nil
+}
+
+func (this *Worker) AddTourScene(hash string, req *TourSceneRequest) (string, error) {
+	if len(req.SceneId) <= 0 || len(req.Config) <= 0 {
+		return "", fmt.Errorf("sceneId and config are required")
+	}
+
+	tour, err := this.GetTour(hash)
+	if err != nil {
+		return "", err
+	}
+
+	conf, err := this.GetVR360Config(ConfigHash(req.Config), 0)
+	if err != nil {
+		return "", err
+	}
+
+	tour.Scenes[req.SceneId] = &TourScene{
+		Title:           req.Title,
+		Hfov:            req.Hfov,
+		Pitch:           req.Pitch,
+		Yaw:             req.Yaw,
+		PannellumConfig: conf,
+	}
+	if req.First || len(tour.Default.FirstScene) <= 0 {
+		tour.Default.FirstScene = req.SceneId
+	}
+
+	return this.SaveTour(tour, hash)
+}
+
+func (this *Worker) LinkTourScenes(hash string, req *TourLinkRequest) (string, error) {
+	tour, err := this.GetTour(hash)
+	if err != nil {
+		return "", err
+	}
+
+	scene, ok := tour.Scenes[req.From]
+	if !ok || scene.PannellumConfig == nil {
+		return "", fmt.Errorf("scene %q not found", req.From)
+	}
+	if _, ok := tour.Scenes[req.To]; !ok {
+		return "", fmt.Errorf("scene %q not found", req.To)
+	}
+
+	scene.HotSpot = append(scene.HotSpot, &PannellumHotSpot{
+		Id:          fmt.Sprintf("%s", uuid.NewV4()),
+		Type:        TYPE_PANNELLUM_HOTSPOT_SCENE,
+		Text:        req.Text,
+		Pitch:       req.Pitch,
+		Yaw:         req.Yaw,
+		SceneId:     req.To,
+		TargetPitch: req.TargetPitch,
+		TargetYaw:   req.TargetYaw,
+	})
+
+	return this.SaveTour(tour, hash)
+}
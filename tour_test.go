@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPannellumTour_Validate(t *testing.T) {
+	tour := NewPannellumTour()
+	err := tour.Validate()
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	tour.Default.FirstScene = "hall"
+	tour.Scenes["hall"] = &TourScene{
+		Title: "Hall",
+		PannellumConfig: &PannellumConfig{
+			Type: "multires",
+			HotSpot: []*PannellumHotSpot{
+				&PannellumHotSpot{Type: TYPE_PANNELLUM_HOTSPOT_SCENE, SceneId: "kitchen"},
+			},
+		},
+	}
+	if tour.Validate() == nil {
+		t.Error("expected unknown scene error")
+	}
+
+	tour.Scenes["kitchen"] = &TourScene{PannellumConfig: &PannellumConfig{Type: "multires"}}
+	err = tour.Validate()
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	data, err := json.Marshal(tour)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	for _, expected := range []string{`"firstScene":"hall"`, `"title":"Hall","type":"multires"`, `"sceneId":"kitchen"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("missing %s in %s", expected, data)
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	cases := map[string]string{
+		"https://s3.ap-east-1.amazonaws.com/bucket/vr360/abc.json": "abc",
+		"abc": "abc",
+	}
+	for config, expected := range cases {
+		if hash := ConfigHash(config); hash != expected {
+			t.Errorf("%s: got %s, expected %s", config, hash, expected)
+		}
+	}
+}
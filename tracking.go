@@ -0,0 +1,491 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	TRACK_PATH_LINEAR = "linear"
+	TRACK_PATH_SINE   = "sine"
+
+	TRACK_DEFAULT_CENTER_X      = 0.5
+	TRACK_DEFAULT_ROTATION      = 360
+	TRACK_DEFAULT_TEMPLATE_SIZE = 32
+	TRACK_DEFAULT_SEARCH_RADIUS = 24
+	// frames are matched at this width, template and search sizes are
+	// measured in these pixels
+	TRACK_IMAGE_WIDTH = 480
+	TRACK_MIN_SCORE   = 0.6
+	// frames downloaded for one refinement
+	TRACK_MAX_PAGES = 360
+)
+
+var trackPaths = []string{TRACK_PATH_LINEAR, TRACK_PATH_SINE}
+
+// swagger:parameters hotSpotTrackParams
+type HotSpotTrackParams struct {
+	//in: body
+	Body *HotSpotTrackRequest
+}
+
+// 根据关键帧生成热点在每一帧上的坐标
+//
+// swagger:model HotSpotTrackRequest
+type HotSpotTrackRequest struct {
+	// spin360 配置hash, 提供时帧数和帧图片取自配置
+	Hash string `json:"hash"`
+	// 帧数, 未提供 hash 时必填
+	Frames int `json:"frames"`
+	// 一个或两个关键帧坐标
+	//
+	// required: true
+	Keyframes []*HotSpotCoordinates `json:"keyframes"`
+	// 插值路径, 可能值 "linear", "sine"
+	//
+	// enum:
+	//	- linear
+	//	- sine
+	Path string `json:"path"`
+	// sine 路径的转轴 X 坐标, 单位与关键帧相同, normalized 时默认 0.5
+	CenterX *float64 `json:"cx"`
+	// 全部帧对应的旋转角度, 默认 360
+	Rotation float64 `json:"rotation"`
+	// 是否使用模板匹配修正插值结果
+	Refine bool `json:"refine"`
+	// 帧图片URL, 未提供 hash 且 refine 为 true 时必填, refine 时最多 360 张
+	Pages []string `json:"pages"`
+	// 模板边长（像素）
+	TemplateSize int `json:"templateSize"`
+	// 搜索半径（像素）
+	SearchRadius int `json:"searchRadius"`
+}
+
+func (this *HotSpotTrackRequest) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if len(this.Path) <= 0 {
+		this.Path = TRACK_PATH_LINEAR
+	}
+	if this.Rotation == 0 {
+		this.Rotation = TRACK_DEFAULT_ROTATION
+	}
+	if this.TemplateSize <= 0 {
+		this.TemplateSize = TRACK_DEFAULT_TEMPLATE_SIZE
+	}
+	if this.SearchRadius <= 0 {
+		this.SearchRadius = TRACK_DEFAULT_SEARCH_RADIUS
+	}
+
+	errs.OneOf("path", this.Path, trackPaths)
+	if this.Frames <= 0 {
+		errs.Add("frames", "must be positive")
+	}
+	if len(this.Keyframes) < 1 || len(this.Keyframes) > 2 {
+		errs.Add("keyframes", "one or two keyframes are required")
+	}
+	if this.Refine && len(this.Pages) != this.Frames {
+		errs.Add("pages", "one page per frame is required to refine")
+	}
+	if this.Refine && len(this.Pages) > TRACK_MAX_PAGES {
+		errs.Add("pages", "at most %d pages can be refined", TRACK_MAX_PAGES)
+	}
+
+	units := make(map[string]bool)
+	indexes := make(map[int]bool)
+	for i, keyframe := range this.Keyframes {
+		field := fmt.Sprintf("keyframes[%d]", i)
+		if keyframe == nil {
+			errs.Add(field, "is required")
+			continue
+		}
+		if keyframe.PageIndex < 0 || keyframe.PageIndex >= this.Frames {
+			errs.Add(field+".index", "must be between 0 and %d", this.Frames-1)
+		}
+		if indexes[keyframe.PageIndex] {
+			errs.Add(field+".index", "duplicate frame %d", keyframe.PageIndex)
+		}
+		indexes[keyframe.PageIndex] = true
+		errs.Coordinate(field, keyframe)
+		units[keyframe.unit()] = true
+	}
+	if len(units) > 1 {
+		errs.Add("keyframes", "normalized and pixel coordinates cannot be mixed")
+	}
+	if this.Path == TRACK_PATH_SINE && this.CenterX == nil {
+		if units[COORDINATE_UNIT_PIXEL] {
+			errs.Add("cx", "is required for pixel coordinates")
+		} else {
+			cx := float64(TRACK_DEFAULT_CENTER_X)
+			this.CenterX = &cx
+		}
+	}
+
+	return errs
+}
+
+// roundCoordinate keeps the value inside the image and drops the noise of
+// the path math.
+func roundCoordinate(value float64, unit string) float64 {
+	if value < 0 {
+		value = 0
+	}
+	if unit == COORDINATE_UNIT_NORMALIZED {
+		if value > 1 {
+			value = 1
+		}
+		return math.Round(value*10000) / 10000
+	}
+
+	return math.Round(value*100) / 100
+}
+
+type trackPoint struct {
+	frame int
+	x     float64
+	y     float64
+}
+
+// InterpolateHotSpot spreads the keyframes over all frames. With a linear
+// path a single keyframe stays in place and two keyframes are joined by a
+// straight line, wrapping around when the frames form a full turn. With a
+// sine path the hotspot orbits the vertical axis at cx; a single keyframe is
+// taken as the frame where the hotspot is furthest from the axis.
+func InterpolateHotSpot(req *HotSpotTrackRequest) ([]*HotSpotCoordinates, error) {
+	if errs := req.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	keys := make([]*trackPoint, 0, len(req.Keyframes))
+	unit := req.Keyframes[0].unit()
+	for _, keyframe := range req.Keyframes {
+		keys = append(keys, &trackPoint{frame: keyframe.PageIndex, x: keyframe.X, y: keyframe.Y})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].frame < keys[j].frame
+	})
+
+	n := req.Frames
+	loop := math.Abs(req.Rotation) >= 360
+	points := make([]*trackPoint, n)
+	switch {
+	case len(keys) == 1:
+		if req.Path == TRACK_PATH_SINE {
+			cx := *req.CenterX
+			step := req.Rotation * math.Pi / 180 / float64(n)
+			amplitude := keys[0].x - cx
+			phase := math.Pi/2 - step*float64(keys[0].frame)
+			for i := range points {
+				points[i] = &trackPoint{frame: i, x: cx + amplitude*math.Sin(step*float64(i)+phase), y: keys[0].y}
+			}
+			break
+		}
+		for i := range points {
+			points[i] = &trackPoint{frame: i, x: keys[0].x, y: keys[0].y}
+		}
+	case req.Path == TRACK_PATH_SINE:
+		err := sinePath(req, keys[0], keys[1], points)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		a, b := keys[0], keys[1]
+		for i := range points {
+			points[i] = linearPoint(a, b, i, n, loop)
+		}
+	}
+
+	list := make([]*HotSpotCoordinates, 0, n)
+	for _, point := range points {
+		list = append(list, &HotSpotCoordinates{
+			PageIndex: point.frame,
+			X:         roundCoordinate(point.x, unit),
+			Y:         roundCoordinate(point.y, unit),
+			Unit:      unit,
+		})
+	}
+
+	return list, nil
+}
+
+func linearPoint(a *trackPoint, b *trackPoint, i int, n int, loop bool) *trackPoint {
+	lerp := func(from *trackPoint, to *trackPoint, t float64) *trackPoint {
+		return &trackPoint{frame: i, x: from.x + (to.x-from.x)*t, y: from.y + (to.y-from.y)*t}
+	}
+
+	switch {
+	case i >= a.frame && i <= b.frame:
+		return lerp(a, b, float64(i-a.frame)/float64(b.frame-a.frame))
+	case !loop && i < a.frame:
+		return lerp(a, a, 0)
+	case !loop:
+		return lerp(b, b, 0)
+	}
+
+	// back from b to a across the end of the sequence
+	span := n - b.frame + a.frame
+	offset := i - b.frame
+	if i < a.frame {
+		offset = n - b.frame + i
+	}
+
+	return lerp(b, a, float64(offset)/float64(span))
+}
+
+// sinePath fits x = cx + A*sin(t+phase) and y = cy + B*cos(t+phase) through
+// two keyframes, the y term models the ellipse seen from an elevated camera.
+func sinePath(req *HotSpotTrackRequest, a *trackPoint, b *trackPoint, points []*trackPoint) error {
+	cx := *req.CenterX
+	step := req.Rotation * math.Pi / 180 / float64(len(points))
+	ta := step * float64(a.frame)
+	d := step * float64(b.frame-a.frame)
+	if math.Abs(math.Sin(d)) < 1e-6 {
+		return ValidationErrors{&ValidationError{Field: "keyframes",
+			Message: "keyframes half or full turn apart do not define a sine path"}}
+	}
+
+	u := a.x - cx
+	v := b.x - cx
+	// A*sin(ta+phase) = u, A*cos(ta+phase) = (v - u*cos d) / sin d
+	c := (v - u*math.Cos(d)) / math.Sin(d)
+	amplitude := math.Hypot(u, c)
+	phase := math.Atan2(u, c) - ta
+
+	ca := math.Cos(ta + phase)
+	cb := math.Cos(ta + d + phase)
+	ry, cy := 0.0, (a.y+b.y)/2
+	if math.Abs(ca-cb) > 1e-6 {
+		ry = (a.y - b.y) / (ca - cb)
+		cy = a.y - ry*ca
+	}
+
+	for i := range points {
+		t := step*float64(i) + phase
+		points[i] = &trackPoint{frame: i, x: cx + amplitude*math.Sin(t), y: cy + ry*math.Cos(t)}
+	}
+
+	return nil
+}
+
+// lumaImage is a frame downscaled to the tracking width, srcWidth and
+// srcHeight keep the size of the original frame.
+type lumaImage struct {
+	width     int
+	height    int
+	srcWidth  int
+	srcHeight int
+	pix       []float64
+}
+
+func newLumaImage(src image.Image) *lumaImage {
+	srcSize := src.Bounds().Size()
+	if src.Bounds().Dx() > TRACK_IMAGE_WIDTH {
+		src = imaging.Resize(src, TRACK_IMAGE_WIDTH, 0, imaging.Box)
+	}
+	gray := imaging.Grayscale(src)
+	bounds := gray.Bounds()
+
+	luma := &lumaImage{
+		width:     bounds.Dx(),
+		height:    bounds.Dy(),
+		srcWidth:  srcSize.X,
+		srcHeight: srcSize.Y,
+		pix:       make([]float64, bounds.Dx()*bounds.Dy()),
+	}
+	for y := 0; y < luma.height; y++ {
+		for x := 0; x < luma.width; x++ {
+			luma.pix[y*luma.width+x] = float64(gray.Pix[y*gray.Stride+x*4])
+		}
+	}
+
+	return luma
+}
+
+// patch returns the size x size block centred on (cx, cy), or nil if it
+// does not fit in the image.
+func (this *lumaImage) patch(cx int, cy int, size int) []float64 {
+	x0, y0 := cx-size/2, cy-size/2
+	if x0 < 0 || y0 < 0 || x0+size > this.width || y0+size > this.height {
+		return nil
+	}
+
+	block := make([]float64, 0, size*size)
+	for y := y0; y < y0+size; y++ {
+		block = append(block, this.pix[y*this.width+x0:y*this.width+x0+size]...)
+	}
+
+	return block
+}
+
+// ncc is the normalized cross correlation of two equally sized blocks.
+func ncc(a []float64, b []float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= float64(len(a))
+	meanB /= float64(len(b))
+
+	var num, varA, varB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		num += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA <= 0 || varB <= 0 {
+		return 0
+	}
+
+	return num / math.Sqrt(varA*varB)
+}
+
+// RefineHotSpotTrack moves each interpolated position to the best template
+// match of the nearest keyframe within the search radius, positions without
+// a confident match keep their interpolated value.
+func RefineHotSpotTrack(req *HotSpotTrackRequest, frames []image.Image, track []*HotSpotCoordinates) ([]*HotSpotCoordinates, error) {
+	lumas := make([]*lumaImage, len(frames))
+	for i, frame := range frames {
+		lumas[i] = newLumaImage(frame)
+	}
+
+	return refineHotSpotTrack(req, lumas, track)
+}
+
+func refineHotSpotTrack(req *HotSpotTrackRequest, lumas []*lumaImage, track []*HotSpotCoordinates) ([]*HotSpotCoordinates, error) {
+	if len(lumas) != len(track) {
+		return nil, fmt.Errorf("got %d frames for %d positions", len(lumas), len(track))
+	}
+
+	// positions are converted to and from the downscaled frame of the same index
+	unit := req.Keyframes[0].unit()
+	toPixel := func(i int, v float64, horizontal bool) float64 {
+		size, scaledSize := lumas[i].srcHeight, lumas[i].height
+		if horizontal {
+			size, scaledSize = lumas[i].srcWidth, lumas[i].width
+		}
+		if unit == COORDINATE_UNIT_NORMALIZED {
+			return v * float64(scaledSize)
+		}
+		return v * float64(scaledSize) / float64(size)
+	}
+	fromPixel := func(i int, px float64, horizontal bool) float64 {
+		size, scaledSize := lumas[i].srcHeight, lumas[i].height
+		if horizontal {
+			size, scaledSize = lumas[i].srcWidth, lumas[i].width
+		}
+		if unit == COORDINATE_UNIT_NORMALIZED {
+			return roundCoordinate(px/float64(scaledSize), unit)
+		}
+		return roundCoordinate(px*float64(size)/float64(scaledSize), unit)
+	}
+
+	templates := make(map[int][]float64)
+	for _, keyframe := range req.Keyframes {
+		i := keyframe.PageIndex
+		x := int(math.Round(toPixel(i, keyframe.X, true)))
+		y := int(math.Round(toPixel(i, keyframe.Y, false)))
+		template := lumas[i].patch(x, y, req.TemplateSize)
+		if template == nil {
+			return nil, fmt.Errorf("keyframe %d is too close to the image border to refine", i)
+		}
+		templates[i] = template
+	}
+
+	loop := math.Abs(req.Rotation) >= 360
+	result := make([]*HotSpotCoordinates, len(track))
+	for i, coordinate := range track {
+		result[i] = coordinate
+		if _, ok := templates[i]; ok {
+			continue
+		}
+
+		template := templates[nearestKeyframe(req.Keyframes, i, len(track), loop)]
+		px := int(math.Round(toPixel(i, coordinate.X, true)))
+		py := int(math.Round(toPixel(i, coordinate.Y, false)))
+		bestScore, bestX, bestY := TRACK_MIN_SCORE, -1, -1
+		for dy := -req.SearchRadius; dy <= req.SearchRadius; dy++ {
+			for dx := -req.SearchRadius; dx <= req.SearchRadius; dx++ {
+				block := lumas[i].patch(px+dx, py+dy, req.TemplateSize)
+				if block == nil {
+					continue
+				}
+				if score := ncc(template, block); score > bestScore {
+					bestScore, bestX, bestY = score, px+dx, py+dy
+				}
+			}
+		}
+		if bestX < 0 {
+			continue
+		}
+
+		result[i] = &HotSpotCoordinates{
+			PageIndex: coordinate.PageIndex,
+			X:         fromPixel(i, float64(bestX), true),
+			Y:         fromPixel(i, float64(bestY), false),
+			Unit:      unit,
+		}
+	}
+
+	return result, nil
+}
+
+func nearestKeyframe(keyframes []*HotSpotCoordinates, frame int, n int, loop bool) int {
+	best, bestDistance := keyframes[0].PageIndex, n+1
+	for _, keyframe := range keyframes {
+		distance := int(math.Abs(float64(keyframe.PageIndex - frame)))
+		if loop && n-distance < distance {
+			distance = n - distance
+		}
+		if distance < bestDistance {
+			best, bestDistance = keyframe.PageIndex, distance
+		}
+	}
+
+	return best
+}
+
+// TrackHotSpot interpolates a hotspot over all frames and optionally refines
+// the positions on the frame images.
+func (this *Worker) TrackHotSpot(req *HotSpotTrackRequest) ([]*HotSpotCoordinates, error) {
+	if len(req.Hash) > 0 {
+		conf, err := this.GetConfig(req.Hash, 0)
+		if err != nil {
+			return nil, err
+		}
+		req.Frames = len(conf.Pages)
+		if len(req.Pages) <= 0 {
+			for _, page := range conf.Pages {
+				req.Pages = append(req.Pages, page.ImageURL)
+			}
+		}
+	}
+
+	track, err := InterpolateHotSpot(req)
+	if err != nil || !req.Refine {
+		return track, err
+	}
+
+	// only the downscaled frames are kept, one full frame is decoded at a time
+	lumas := make([]*lumaImage, 0, len(req.Pages))
+	for _, page := range req.Pages {
+		reader, err := this.DownloadRemoteFile(page, "image/")
+		if err != nil {
+			return nil, err
+		}
+		frame, _, err := image.Decode(reader)
+		reader.Close()
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		lumas = append(lumas, newLumaImage(frame))
+	}
+
+	return refineHotSpotTrack(req, lumas, track)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestInterpolateHotSpot_Linear(t *testing.T) {
+	track, err := InterpolateHotSpot(&HotSpotTrackRequest{
+		Frames: 8,
+		Keyframes: []*HotSpotCoordinates{
+			&HotSpotCoordinates{PageIndex: 4, X: 0.6, Y: 0.2},
+			&HotSpotCoordinates{PageIndex: 0, X: 0.2, Y: 0.2},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	expected := []float64{0.2, 0.3, 0.4, 0.5, 0.6, 0.5, 0.4, 0.3}
+	for i, x := range expected {
+		if track[i].PageIndex != i || track[i].X != x || track[i].Y != 0.2 {
+			t.Errorf("frame %d: expected %g, got %+v", i, x, track[i])
+		}
+	}
+}
+
+func TestInterpolateHotSpot_Sine(t *testing.T) {
+	req := &HotSpotTrackRequest{
+		Frames: 36,
+		Path:   TRACK_PATH_SINE,
+		Keyframes: []*HotSpotCoordinates{
+			&HotSpotCoordinates{PageIndex: 0, X: 0.7, Y: 0.4},
+			&HotSpotCoordinates{PageIndex: 5, X: 0.55, Y: 0.42},
+		},
+	}
+	track, err := InterpolateHotSpot(req)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if len(track) != 36 || track[0].X != 0.7 || track[5].X != 0.55 || track[5].Y != 0.42 {
+		t.Errorf("keyframes not on the path: %+v %+v", track[0], track[5])
+	}
+
+	// half a turn later the hotspot is mirrored around the axis
+	if track[18].X != 0.3 {
+		t.Errorf("expected mirrored position 0.3, got %g", track[18].X)
+	}
+
+	req.Keyframes[1].PageIndex = 18
+	_, err = InterpolateHotSpot(req)
+	if _, ok := err.(ValidationErrors); !ok {
+		t.Errorf("expected ValidationErrors for opposite keyframes, got %v", err)
+	}
+}
+
+func TestInterpolateHotSpot_Invalid(t *testing.T) {
+	_, err := InterpolateHotSpot(&HotSpotTrackRequest{
+		Frames: 4,
+		Path:   "spiral",
+		Keyframes: []*HotSpotCoordinates{
+			&HotSpotCoordinates{PageIndex: 5, X: 0.1, Y: 0.1},
+			&HotSpotCoordinates{PageIndex: 1, X: 10, Y: 10, Unit: COORDINATE_UNIT_PIXEL},
+		},
+	})
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Errorf("expected ValidationErrors, got %v", err)
+		return
+	}
+	for _, field := range []string{"path", "keyframes[0].index", "keyframes"} {
+		if !hasFieldError(errs, field) {
+			t.Errorf("missing error for %s in %s", field, errs)
+		}
+	}
+
+	pages := make([]string, TRACK_MAX_PAGES+1)
+	_, err = InterpolateHotSpot(&HotSpotTrackRequest{
+		Frames:    len(pages),
+		Refine:    true,
+		Pages:     pages,
+		Keyframes: []*HotSpotCoordinates{&HotSpotCoordinates{PageIndex: 0, X: 0.1, Y: 0.1}},
+	})
+	if errs, ok := err.(ValidationErrors); !ok || !hasFieldError(errs, "pages") {
+		t.Errorf("expected a pages error, got %v", err)
+	}
+}
+
+func TestRefineHotSpotTrack(t *testing.T) {
+	// a textured marker that does not move at constant speed
+	marker := imaging.New(16, 16, color.White)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			marker.Set(x, y, color.Gray{Y: uint8((x*37 + y*11) % 256)})
+		}
+	}
+	positions := []int{40, 45, 62, 70}
+	frames := make([]image.Image, len(positions))
+	for i, x := range positions {
+		frame := imaging.New(200, 100, color.Gray{Y: 128})
+		frames[i] = imaging.Paste(frame, marker, image.Pt(x-8, 50-8))
+	}
+
+	req := &HotSpotTrackRequest{
+		Frames:       4,
+		Rotation:     90,
+		TemplateSize: 16,
+		SearchRadius: 8,
+		Keyframes: []*HotSpotCoordinates{
+			&HotSpotCoordinates{PageIndex: 0, X: 40, Y: 50, Unit: COORDINATE_UNIT_PIXEL},
+			&HotSpotCoordinates{PageIndex: 3, X: 70, Y: 50, Unit: COORDINATE_UNIT_PIXEL},
+		},
+	}
+	track, err := InterpolateHotSpot(req)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if track[1].X != 50 || track[2].X != 60 {
+		t.Errorf("unexpected interpolation %g %g", track[1].X, track[2].X)
+	}
+
+	refined, err := RefineHotSpotTrack(req, frames, track)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	for i, coordinate := range refined {
+		if coordinate.X != float64(positions[i]) || coordinate.Y != 50 {
+			t.Errorf("frame %d: expected %d,50 got %g,%g", i, positions[i], coordinate.X, coordinate.Y)
+		}
+	}
+}
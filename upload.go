@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const TUS_VERSION = "1.0.0"
+
+const TUS_EXTENSIONS = "creation,termination,expiration"
+
+const UPLOAD_DIR = "uploads"
+
+// default limits of resumable uploads
+const UPLOAD_MAX_SIZE = 20 << 30
+
+const UPLOAD_EXPIRE = 24 * time.Hour
+
+// form fields referencing a finished upload end with this suffix, e.g.
+// video_upload_id instead of the video file
+const UPLOAD_ID_FIELD_SUFFIX = "_upload_id"
+
+var uploadIdPattern = regexp.MustCompile(`^[a-f0-9-]{36}$`)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+var ErrUploadOffset = errors.New("upload offset does not match")
+
+var ErrUploadBusy = errors.New("upload is being written")
+
+var ErrUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+var ErrUploadIncomplete = errors.New("upload is not complete")
+
+type UploadConfig struct {
+	// maximum size of one upload in bytes
+	MaxSize int64 `json:"max_size"`
+	// hours an upload is kept after it was last written
+	ExpireHours int `json:"expire_hours"`
+}
+
+// swagger:model UploadInfo
+type UploadInfo struct {
+	// 上传ID
+	Id string `json:"id"`
+	// 文件总字节数
+	Length int64 `json:"length"`
+	// 已接收字节数
+	Offset int64 `json:"offset"`
+	// Upload-Metadata 中的键值
+	Metadata map[string]string `json:"metadata"`
+	// 过期时间
+	Expires time.Time `json:"expires"`
+	Tenant  string    `json:"tenant,omitempty"`
+}
+
+func (this *UploadInfo) Completed() bool {
+	return this.Offset >= this.Length
+}
+
+// UploadStore keeps resumable uploads as {id}.bin data and {id}.json info
+// files, the offset is the size of the data file so it survives restarts.
+type UploadStore struct {
+	dir    string
+	conf   *UploadConfig
+	lock   sync.Mutex
+	active map[string]bool
+}
+
+func NewUploadStore(tempPath string, conf *UploadConfig) *UploadStore {
+	if conf == nil {
+		conf = &UploadConfig{}
+	}
+
+	return &UploadStore{
+		dir:    filepath.Join(tempPath, UPLOAD_DIR),
+		conf:   conf,
+		active: make(map[string]bool),
+	}
+}
+
+func (this *UploadStore) MaxSize() int64 {
+	if this.conf.MaxSize > 0 {
+		return this.conf.MaxSize
+	}
+
+	return UPLOAD_MAX_SIZE
+}
+
+func (this *UploadStore) expire() time.Duration {
+	if this.conf.ExpireHours > 0 {
+		return time.Duration(this.conf.ExpireHours) * time.Hour
+	}
+
+	return UPLOAD_EXPIRE
+}
+
+func (this *UploadStore) dataPath(id string) string {
+	return filepath.Join(this.dir, id+".bin")
+}
+
+func (this *UploadStore) infoPath(id string) string {
+	return filepath.Join(this.dir, id+".json")
+}
+
+func (this *UploadStore) writeInfo(info *UploadInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return ioutil.WriteFile(this.infoPath(info.Id), data, 0644)
+}
+
+// ParseUploadMetadata decodes the Upload-Metadata header, a comma separated
+// list of keys each followed by a base64 value.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) <= 0 {
+			continue
+		}
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid metadata %q", pair)
+		}
+		value := ""
+		if len(parts) == 2 {
+			data, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata %q", pair)
+			}
+			value = string(data)
+		}
+		metadata[parts[0]] = value
+	}
+
+	return metadata, nil
+}
+
+func EncodeUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, fmt.Sprintf("%s %s", key, base64.StdEncoding.EncodeToString([]byte(value))))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// prune removes uploads that expired.
+func (this *UploadStore) prune(now time.Time) {
+	files, err := filepath.Glob(filepath.Join(this.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		info, err := this.load(id)
+		if err == nil && now.After(info.Expires) {
+			this.remove(id)
+		}
+	}
+}
+
+func (this *UploadStore) Create(length int64, metadata map[string]string, tenant string) (*UploadInfo, error) {
+	if length > this.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	err := os.MkdirAll(this.dir, os.ModePerm)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	this.prune(time.Now())
+
+	info := &UploadInfo{
+		Id:       uuid.NewV4().String(),
+		Length:   length,
+		Metadata: metadata,
+		Expires:  time.Now().Add(this.expire()).UTC(),
+		Tenant:   tenant,
+	}
+	file, err := os.Create(this.dataPath(info.Id))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	file.Close()
+
+	err = this.writeInfo(info)
+	if err != nil {
+		os.Remove(this.dataPath(info.Id))
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (this *UploadStore) load(id string) (*UploadInfo, error) {
+	if !uploadIdPattern.MatchString(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := ioutil.ReadFile(this.infoPath(id))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	info := new(UploadInfo)
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	stat, err := os.Stat(this.dataPath(id))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+	info.Offset = stat.Size()
+
+	return info, nil
+}
+
+// Info loads an upload, uploads of other tenants are not found.
+func (this *UploadStore) Info(id string, tenant string) (*UploadInfo, error) {
+	info, err := this.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Tenant != tenant {
+		return nil, ErrUploadNotFound
+	}
+
+	return info, nil
+}
+
+func (this *UploadStore) acquire(id string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.active[id] {
+		return false
+	}
+	this.active[id] = true
+
+	return true
+}
+
+func (this *UploadStore) release(id string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	delete(this.active, id)
+}
+
+// Append writes a chunk at offset, a broken connection keeps what was
+// received so the client can resume from the new offset.
+func (this *UploadStore) Append(id string, tenant string, offset int64, src io.Reader) (*UploadInfo, error) {
+	if !this.acquire(id) {
+		return nil, ErrUploadBusy
+	}
+	defer this.release(id)
+
+	info, err := this.Info(id, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if offset != info.Offset {
+		return info, ErrUploadOffset
+	}
+
+	file, err := os.OpenFile(this.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer file.Close()
+
+	// one byte more than allowed tells an oversized chunk apart
+	written, err := io.Copy(file, io.LimitReader(src, info.Length-info.Offset+1))
+	info.Offset += written
+	if info.Offset > info.Length {
+		file.Truncate(info.Length)
+		info.Offset = info.Length
+		return info, ErrUploadTooLarge
+	}
+
+	info.Expires = time.Now().Add(this.expire()).UTC()
+	if infoErr := this.writeInfo(info); infoErr != nil {
+		return info, infoErr
+	}
+	if err != nil {
+		log.Error(err)
+		return info, err
+	}
+
+	return info, nil
+}
+
+func (this *UploadStore) remove(id string) {
+	os.Remove(this.dataPath(id))
+	os.Remove(this.infoPath(id))
+}
+
+func (this *UploadStore) Delete(id string, tenant string) error {
+	if !this.acquire(id) {
+		return ErrUploadBusy
+	}
+	defer this.release(id)
+
+	_, err := this.Info(id, tenant)
+	if err != nil {
+		return err
+	}
+	this.remove(id)
+
+	return nil
+}
+
+// Open returns a finished upload as a form file.
+func (this *UploadStore) Open(id string, tenant string) (multipart.File, *multipart.FileHeader, error) {
+	info, err := this.Info(id, tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.Completed() {
+		return nil, nil, ErrUploadIncomplete
+	}
+
+	file, err := os.Open(this.dataPath(id))
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	name := info.Metadata["filename"]
+	if len(name) <= 0 {
+		name = id
+	}
+	header := &multipart.FileHeader{
+		Filename: name,
+		Size:     info.Length,
+		Header:   make(map[string][]string),
+	}
+	if fileType := info.Metadata["filetype"]; len(fileType) > 0 {
+		header.Header.Set("Content-Type", fileType)
+	}
+
+	return file, header, nil
+}
+
+// formFile returns the uploaded file of field, or the finished resumable
+// upload referenced by {field}_upload_id.
+func (this *HTTPService) formFile(request *http.Request, field string) (multipart.File, *multipart.FileHeader, error) {
+	if id := request.FormValue(field + UPLOAD_ID_FIELD_SUFFIX); len(id) > 0 {
+		return this.uploads.Open(id, GetTenant(request.Context()))
+	}
+
+	return request.FormFile(field)
+}
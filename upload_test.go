@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata, err := ParseUploadMetadata("filename c3Bpbi5tcDQ=,filetype dmlkZW8vbXA0, is_confidential")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if metadata["filename"] != "spin.mp4" || metadata["filetype"] != "video/mp4" {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	if _, ok := metadata["is_confidential"]; !ok {
+		t.Errorf("key without value missing %v", metadata)
+	}
+
+	if _, err := ParseUploadMetadata("filename !!"); err == nil {
+		t.Error("expected invalid base64 to fail")
+	}
+}
+
+func TestUploadStore_Append(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	store := NewUploadStore(tempPath, &UploadConfig{MaxSize: 100})
+	if _, err := store.Create(101, nil, ""); err != ErrUploadTooLarge {
+		t.Errorf("expected ErrUploadTooLarge, got %v", err)
+	}
+
+	info, err := store.Create(10, map[string]string{"filename": "spin.mp4"}, "acme")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	info, err = store.Append(info.Id, "acme", 0, strings.NewReader("01234"))
+	if err != nil || info.Offset != 5 {
+		t.Errorf("unexpected offset %v %v", info, err)
+	}
+	// a resumed chunk must start at the received offset
+	if _, err := store.Append(info.Id, "acme", 3, strings.NewReader("34567")); err != ErrUploadOffset {
+		t.Errorf("expected ErrUploadOffset, got %v", err)
+	}
+	if _, err := store.Append(info.Id, "globex", 5, strings.NewReader("56789")); err != ErrUploadNotFound {
+		t.Errorf("expected ErrUploadNotFound for another tenant, got %v", err)
+	}
+	if _, _, err := store.Open(info.Id, "acme"); err != ErrUploadIncomplete {
+		t.Errorf("expected ErrUploadIncomplete, got %v", err)
+	}
+
+	info, err = store.Append(info.Id, "acme", 5, strings.NewReader("56789"))
+	if err != nil || !info.Completed() {
+		t.Errorf("upload not completed %v %v", info, err)
+	}
+	if _, err := store.Append(info.Id, "acme", 10, strings.NewReader("x")); err != ErrUploadTooLarge {
+		t.Errorf("expected ErrUploadTooLarge, got %v", err)
+	}
+
+	file, header, err := store.Open(info.Id, "acme")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer file.Close()
+	data, _ := ioutil.ReadAll(file)
+	if string(data) != "0123456789" || header.Filename != "spin.mp4" || header.Size != 10 {
+		t.Errorf("unexpected file %q %+v", data, header)
+	}
+
+	if err := store.Delete(info.Id, "acme"); err != nil {
+		t.Error(err)
+	}
+	if _, err := store.Info(info.Id, "acme"); err != ErrUploadNotFound {
+		t.Errorf("expected ErrUploadNotFound after delete, got %v", err)
+	}
+}
+
+func TestHTTPService_Upload(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	httpServer := NewHTTP(&Config{TempPath: tempPath, S3: &S3Config{}})
+	handler := httpServer.getHTTPHandler()
+
+	serve := func(method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer
+	}
+
+	resp := serve(http.MethodPost, "/upload", "", map[string]string{"Upload-Length": "6"})
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable, got %d", resp.Code)
+	}
+
+	resp = serve(http.MethodPost, "/upload", "", map[string]string{
+		"Tus-Resumable":   TUS_VERSION,
+		"Upload-Length":   "6",
+		"Upload-Metadata": "filename YS5qcGc=",
+	})
+	location := resp.Header().Get("Location")
+	if resp.Code != http.StatusCreated || !strings.HasPrefix(location, "/upload/") {
+		t.Errorf("unexpected create response %d %q", resp.Code, location)
+		t.Fail()
+		return
+	}
+
+	patch := func(offset string, body string) *httptest.ResponseRecorder {
+		return serve(http.MethodPatch, location, body, map[string]string{
+			"Tus-Resumable": TUS_VERSION,
+			"Upload-Offset": offset,
+			"Content-Type":  "application/offset+octet-stream",
+		})
+	}
+	if resp = patch("0", "abc"); resp.Code != http.StatusNoContent || resp.Header().Get("Upload-Offset") != "3" {
+		t.Errorf("unexpected patch response %d %v", resp.Code, resp.Header())
+	}
+	if resp = patch("0", "abc"); resp.Code != http.StatusConflict {
+		t.Errorf("expected 409 at a stale offset, got %d", resp.Code)
+	}
+
+	resp = serve(http.MethodHead, location, "", map[string]string{"Tus-Resumable": TUS_VERSION})
+	if resp.Code != http.StatusOK || resp.Header().Get("Upload-Offset") != "3" || resp.Header().Get("Upload-Length") != "6" {
+		t.Errorf("unexpected head response %d %v", resp.Code, resp.Header())
+	}
+	if resp = patch("3", "def"); resp.Code != http.StatusNoContent || resp.Header().Get("Upload-Offset") != "6" {
+		t.Errorf("unexpected patch response %d %v", resp.Code, resp.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/split?image_upload_id="+strings.TrimPrefix(location, "/upload/"), nil)
+	file, header, err := httpServer.formFile(req, "image")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	file.Close()
+	if header.Filename != "a.jpg" || header.Size != 6 {
+		t.Errorf("unexpected form file %+v", header)
+	}
+
+	resp = serve(http.MethodDelete, location, "", map[string]string{"Tus-Resumable": TUS_VERSION})
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("unexpected delete response %d", resp.Code)
+	}
+	resp = serve(http.MethodHead, location, "", map[string]string{"Tus-Resumable": TUS_VERSION})
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", resp.Code)
+	}
+}
+
+func TestHTTPService_Upload_Quota(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempPath)
+
+	httpServer := NewHTTP(&Config{TempPath: tempPath, Tenants: map[string]*TenantConfig{
+		"acme": &TenantConfig{Quota: &TenantQuota{DailyBytes: 5}},
+	}})
+	handler := httpServer.getHTTPHandler()
+
+	serve := func(method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", TUS_VERSION)
+		req.Header.Set(TENANT_HEADER, "acme")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer
+	}
+
+	if resp := serve(http.MethodPost, "/upload", "", map[string]string{"Upload-Length": "6"}); resp.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 above the byte quota, got %d", resp.Code)
+	}
+
+	resp := serve(http.MethodPost, "/upload", "", map[string]string{"Upload-Length": "4"})
+	if resp.Code != http.StatusCreated {
+		t.Errorf("unexpected create response %d", resp.Code)
+		t.Fail()
+		return
+	}
+	resp = serve(http.MethodPatch, resp.Header().Get("Location"), "abcd", map[string]string{
+		"Upload-Offset": "0",
+		"Content-Type":  "application/offset+octet-stream",
+	})
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("unexpected patch response %d", resp.Code)
+	}
+
+	// the 4 uploaded bytes are charged to the tenant
+	if resp := serve(http.MethodPost, "/upload", "", map[string]string{"Upload-Length": "2"}); resp.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the uploaded bytes are counted, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	PANNELLUM_TYPE_MULTIRES        = "multires"
+	PANNELLUM_TYPE_EQUIRECTANGULAR = "equirectangular"
+	PANNELLUM_TYPE_CUBEMAP         = "cubemap"
+)
+
+var (
+	spinHotSpotTypes = []string{HOTSPOT_TYPE_EMBED, HOTSPOT_TYPE_LINK, HOTSPOT_TYPE_TEXT,
+		HOTSPOT_TYPE_IMAGE, HOTSPOT_TYPE_VIDEO, HOTSPOT_TYPE_PRODUCT, HOTSPOT_TYPE_SCENE}
+	pannellumHotSpotTypes = []string{TYPE_PANNELLUM_HOTSPOT_EMBED, TYPE_PANNELLUM_HOTSPOT_LINK,
+		TYPE_PANNELLUM_HOTSPOT_TEXT, TYPE_PANNELLUM_HOTSPOT_SCENE, TYPE_PANNELLUM_HOTSPOT_IMAGE,
+		TYPE_PANNELLUM_HOTSPOT_VIDEO, TYPE_PANNELLUM_HOTSPOT_PRODUCT}
+	pannellumTypes     = []string{PANNELLUM_TYPE_MULTIRES, PANNELLUM_TYPE_EQUIRECTANGULAR, PANNELLUM_TYPE_CUBEMAP}
+	hotSpotTargetTypes = []string{HOTSPOT_TARGET_SPIN360, HOTSPOT_TARGET_VR360}
+
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// swagger:model ValidationError
+type ValidationError struct {
+	// 出错字段路径, 例如 hotspot[0].coordinate[1].x
+	Field string `json:"field"`
+	// 错误说明
+	Message string `json:"message"`
+}
+
+type ValidationErrors []*ValidationError
+
+func (this ValidationErrors) Error() string {
+	list := make([]string, 0, len(this))
+	for _, e := range this {
+		list = append(list, fmt.Sprintf("%s: %s", e.Field, e.Message))
+	}
+
+	return strings.Join(list, "; ")
+}
+
+func (this *ValidationErrors) Add(field string, format string, args ...interface{}) {
+	*this = append(*this, &ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (this *ValidationErrors) Required(field string, value string) bool {
+	if len(strings.TrimSpace(value)) <= 0 {
+		this.Add(field, "is required")
+		return false
+	}
+
+	return true
+}
+
+func (this *ValidationErrors) OneOf(field string, value string, list []string) {
+	for _, item := range list {
+		if item == value {
+			return
+		}
+	}
+	this.Add(field, "must be one of %s", strings.Join(list, ", "))
+}
+
+// URL accepts absolute http(s) URLs and relative references, as written by
+// the /vr360 zip output, other schemes are rejected.
+func (this *ValidationErrors) URL(field string, value string) {
+	if !this.Required(field, value) {
+		return
+	}
+
+	// Pannellum fills in placeholders like %l, they are no URL escapes
+	u, err := url.Parse(strings.Replace(value, "%", "%25", -1))
+	if err != nil {
+		this.Add(field, "invalid URL: %s", err)
+		return
+	}
+	if u.IsAbs() && (u.Scheme != "http" && u.Scheme != "https" || len(u.Host) <= 0) {
+		this.Add(field, "must be an http(s) URL")
+	}
+}
+
+func (this *ValidationErrors) Range(field string, value float64, min float64, max float64) {
+	if value < min || value > max {
+		this.Add(field, "must be between %g and %g", min, max)
+	}
+}
+
+// Coordinate checks a hotspot position, normalized values must lie within
+// the image.
+func (this *ValidationErrors) Coordinate(field string, coordinate *HotSpotCoordinates) {
+	if coordinate.IsMixed() {
+		this.Add(field, "mixes %s and %s values, migrate the config first", coordinate.axisUnit(0), coordinate.axisUnit(1))
+		return
+	}
+	unit := coordinate.unit()
+	this.OneOf(field+".unit", unit, coordinateUnits)
+
+	for i, value := range []float64{coordinate.X, coordinate.Y} {
+		axisField := field + "." + []string{"x", "y"}[i]
+		if unit == COORDINATE_UNIT_NORMALIZED {
+			this.Range(axisField, value, 0, 1)
+		} else if value < 0 {
+			this.Add(axisField, "must not be negative")
+		}
+	}
+}
+
+func (this *ValidationErrors) Images(field string, images []*HotSpotImage, defaultLocale string) {
+	if len(images) <= 0 {
+		this.Add(field, "at least one image is required")
+	}
+	for i, image := range images {
+		iField := fmt.Sprintf("%s[%d]", field, i)
+		if image == nil {
+			this.Add(iField, "is required")
+			continue
+		}
+		this.URL(iField+".url", image.URL)
+		this.Text(iField+".caption", image.Caption, defaultLocale, false)
+	}
+}
+
+func (this *ValidationErrors) Video(field string, video *HotSpotVideo) {
+	if video == nil {
+		this.Add(field, "is required")
+		return
+	}
+	this.URL(field+".url", video.URL)
+	if len(video.Poster) > 0 {
+		this.URL(field+".poster", video.Poster)
+	}
+}
+
+func (this *ValidationErrors) Product(field string, product *HotSpotProduct, defaultLocale string) {
+	if product == nil {
+		this.Add(field, "is required")
+		return
+	}
+	this.Text(field+".title", product.Title, defaultLocale, true)
+	this.Text(field+".ctaText", product.CTAText, defaultLocale, false)
+	this.Required(field+".sku", product.SKU)
+	this.URL(field+".ctaUrl", product.CTAURL)
+	if product.Price < 0 {
+		this.Add(field+".price", "must not be negative")
+	}
+	if product.Price > 0 && !currencyPattern.MatchString(product.Currency) {
+		this.Add(field+".currency", "must be an ISO 4217 code when price is set")
+	}
+	if len(product.Image) > 0 {
+		this.URL(field+".image", product.Image)
+	}
+}
+
+func (this *ValidationErrors) Target(field string, target *HotSpotTarget) {
+	if target == nil {
+		this.Add(field, "is required")
+		return
+	}
+	this.OneOf(field+".type", target.Type, hotSpotTargetTypes)
+	this.Required(field+".hash", target.Hash)
+	if target.PageIndex < 0 {
+		this.Add(field+".index", "must not be negative")
+	}
+	if target.Pitch != nil {
+		this.Range(field+".pitch", *target.Pitch, -90, 90)
+	}
+	if target.Yaw != nil {
+		this.Range(field+".yaw", *target.Yaw, -180, 180)
+	}
+}
+
+func (this *Spin360Config) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if len(this.Pages) <= 0 {
+		errs.Add("page", "at least one page is required")
+	}
+	for i, page := range this.Pages {
+		field := fmt.Sprintf("page[%d]", i)
+		if page == nil {
+			errs.Add(field, "is required")
+			continue
+		}
+		errs.URL(field+".img", page.ImageURL)
+	}
+
+	errs.Locale("defaultLocale", this.DefaultLocale)
+	ids := make(map[string]bool)
+	for i, hotSpot := range this.HotSpot {
+		field := fmt.Sprintf("hotspot[%d]", i)
+		if hotSpot == nil {
+			errs.Add(field, "is required")
+			continue
+		}
+		if len(hotSpot.Id) > 0 {
+			if ids[hotSpot.Id] {
+				errs.Add(field+".id", "duplicate id %q", hotSpot.Id)
+			}
+			ids[hotSpot.Id] = true
+		}
+		errs.OneOf(field+".type", hotSpot.Type, spinHotSpotTypes)
+		switch hotSpot.Type {
+		case HOTSPOT_TYPE_EMBED, HOTSPOT_TYPE_LINK:
+			errs.URL(field+".url", hotSpot.URL)
+		case HOTSPOT_TYPE_TEXT:
+			errs.Text(field+".text", hotSpot.Text, this.DefaultLocale, true)
+		default:
+			errs.Text(field+".text", hotSpot.Text, this.DefaultLocale, false)
+		}
+		switch hotSpot.Type {
+		case HOTSPOT_TYPE_IMAGE:
+			errs.Images(field+".images", hotSpot.Images, this.DefaultLocale)
+		case HOTSPOT_TYPE_VIDEO:
+			errs.Video(field+".video", hotSpot.Video)
+		case HOTSPOT_TYPE_PRODUCT:
+			errs.Product(field+".product", hotSpot.Product, this.DefaultLocale)
+		case HOTSPOT_TYPE_SCENE:
+			errs.Target(field+".target", hotSpot.Target)
+		}
+
+		if len(hotSpot.Coordinates) <= 0 {
+			errs.Add(field+".coordinate", "at least one coordinate is required")
+		}
+		for j, coordinate := range hotSpot.Coordinates {
+			cField := fmt.Sprintf("%s.coordinate[%d]", field, j)
+			if coordinate == nil {
+				errs.Add(cField, "is required")
+				continue
+			}
+			if coordinate.PageIndex < 0 || coordinate.PageIndex >= len(this.Pages) {
+				errs.Add(cField+".index", "must be between 0 and %d", len(this.Pages)-1)
+			}
+			errs.Coordinate(cField, coordinate)
+		}
+	}
+
+	return errs
+}
+
+func (this *PannellumConfig) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	errs.OneOf("type", this.Type, pannellumTypes)
+	switch this.Type {
+	case PANNELLUM_TYPE_MULTIRES:
+		if this.Config == nil {
+			errs.Add("multiRes", "is required for multires panoramas")
+			break
+		}
+		if len(this.Config.BasePath) > 0 {
+			errs.URL("multiRes.basePath", this.Config.BasePath)
+		}
+		errs.Required("multiRes.path", this.Config.Path)
+		errs.OneOf("multiRes.extension", this.Config.Extension, tileFormats)
+		if this.Config.TileResolution <= 0 {
+			errs.Add("multiRes.tileResolution", "must be positive")
+		}
+		if this.Config.MaxLevel <= 0 {
+			errs.Add("multiRes.maxLevel", "must be positive")
+		}
+		if this.Config.CubeResolution <= 0 {
+			errs.Add("multiRes.cubeResolution", "must be positive")
+		}
+	case PANNELLUM_TYPE_EQUIRECTANGULAR:
+		errs.URL("panorama", this.URL)
+	}
+	if this.Type != PANNELLUM_TYPE_EQUIRECTANGULAR && len(this.URL) > 0 {
+		errs.URL("panorama", this.URL)
+	}
+	for _, image := range []struct {
+		field string
+		value string
+	}{
+		{"preview", this.Preview},
+		{"thumbnail", this.Thumbnail},
+		{"placeholder", this.Placeholder},
+	} {
+		if len(image.value) > 0 {
+			errs.URL(image.field, image.value)
+		}
+	}
+
+	errs.Locale("defaultLocale", this.DefaultLocale)
+	ids := make(map[string]bool)
+	for i, hotSpot := range this.HotSpot {
+		field := fmt.Sprintf("hotSpots[%d]", i)
+		if hotSpot == nil {
+			errs.Add(field, "is required")
+			continue
+		}
+		if errs.Required(field+".id", hotSpot.Id) {
+			if ids[hotSpot.Id] {
+				errs.Add(field+".id", "duplicate id %q", hotSpot.Id)
+			}
+			ids[hotSpot.Id] = true
+		}
+		errs.OneOf(field+".type", hotSpot.Type, pannellumHotSpotTypes)
+		errs.Range(field+".pitch", hotSpot.Pitch, -90, 90)
+		errs.Range(field+".yaw", hotSpot.Yaw, -180, 180)
+		switch hotSpot.Type {
+		case TYPE_PANNELLUM_HOTSPOT_EMBED, TYPE_PANNELLUM_HOTSPOT_LINK:
+			errs.URL(field+".link", hotSpot.Link)
+		case TYPE_PANNELLUM_HOTSPOT_TEXT:
+			errs.Text(field+".text", hotSpot.Text, this.DefaultLocale, true)
+		default:
+			errs.Text(field+".text", hotSpot.Text, this.DefaultLocale, false)
+		}
+		switch hotSpot.Type {
+		case TYPE_PANNELLUM_HOTSPOT_SCENE:
+			if hotSpot.Target != nil {
+				errs.Target(field+".target", hotSpot.Target)
+			} else {
+				errs.Required(field+".sceneId", hotSpot.SceneId)
+			}
+		case TYPE_PANNELLUM_HOTSPOT_IMAGE:
+			errs.Images(field+".images", hotSpot.Images, this.DefaultLocale)
+		case TYPE_PANNELLUM_HOTSPOT_VIDEO:
+			errs.Video(field+".video", hotSpot.Video)
+		case TYPE_PANNELLUM_HOTSPOT_PRODUCT:
+			errs.Product(field+".product", hotSpot.Product, this.DefaultLocale)
+		}
+	}
+
+	return errs
+}
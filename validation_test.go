@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func hasFieldError(errs ValidationErrors, field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSpin360Config_Validate(t *testing.T) {
+	conf := &Spin360Config{
+		Pages: []*SpinPage{&SpinPage{ImageURL: "https://example.com/1.png"}},
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{
+				Type: HOTSPOT_TYPE_TEXT,
+				Text: NewLocalizedText("Hello"),
+				Coordinates: []*HotSpotCoordinates{
+					&HotSpotCoordinates{PageIndex: 0, X: 0.1, Y: 20.5, Unit: COORDINATE_UNIT_PIXEL},
+				},
+			},
+		},
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+		t.Fail()
+		return
+	}
+
+	conf.HotSpot = append(conf.HotSpot, &PageHotSpot{
+		Type: "popup",
+		URL:  "javascript:alert(1)",
+		Coordinates: []*HotSpotCoordinates{
+			&HotSpotCoordinates{PageIndex: 3, X: -1, Y: 1.2},
+		},
+	})
+	conf.HotSpot[0].Coordinates[0].X = -5
+	errs := conf.Validate()
+	for _, field := range []string{
+		"hotspot[0].coordinate[0].x",
+		"hotspot[1].type",
+		"hotspot[1].coordinate[0].index",
+		"hotspot[1].coordinate[0].x",
+		"hotspot[1].coordinate[0].y",
+	} {
+		if !hasFieldError(errs, field) {
+			t.Errorf("missing error for %s in %s", field, errs)
+		}
+	}
+}
+
+func TestPannellumConfig_Validate(t *testing.T) {
+	conf := &PannellumConfig{
+		Type: PANNELLUM_TYPE_MULTIRES,
+		Config: &MultiResConfig{
+			BasePath:       "https://example.com/vr360/abc",
+			Path:           "/%l/%s%y_%x",
+			Extension:      TILE_FORMAT_JPG,
+			TileResolution: 512,
+			MaxLevel:       3,
+			CubeResolution: 2000,
+		},
+		HotSpot: []*PannellumHotSpot{
+			&PannellumHotSpot{Id: "a", Type: TYPE_PANNELLUM_HOTSPOT_LINK, Link: "https://example.com", Pitch: 10, Yaw: -30},
+		},
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+		t.Fail()
+		return
+	}
+
+	conf.HotSpot = append(conf.HotSpot, &PannellumHotSpot{Id: "a", Type: "info", Pitch: 95, Yaw: 200})
+	errs := conf.Validate()
+	for _, field := range []string{"hotSpots[1].id", "hotSpots[1].type", "hotSpots[1].pitch", "hotSpots[1].yaw"} {
+		if !hasFieldError(errs, field) {
+			t.Errorf("missing error for %s in %s", field, errs)
+		}
+	}
+}
+
+func TestValidationErrors_URL(t *testing.T) {
+	for _, value := range []string{"https://example.com/a.jpg", "//cdn.example.com/a.jpg", "/vr360/a.jpg", "preview/preview.jpg", "tiles/%l/%s%x_%y"} {
+		errs := ValidationErrors{}
+		errs.URL("url", value)
+		if len(errs) > 0 {
+			t.Errorf("%s: unexpected %s", value, errs)
+		}
+	}
+	for _, value := range []string{"javascript:alert(1)", "ftp://example.com/a.jpg", "https:///a.jpg"} {
+		errs := ValidationErrors{}
+		errs.URL("url", value)
+		if len(errs) != 1 {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+
+	// the /vr360 zip output saves back and errors keep the field order
+	conf := &PannellumConfig{
+		Type:        PANNELLUM_TYPE_MULTIRES,
+		Config:      &MultiResConfig{BasePath: "tiles", Path: "/%l/%s%x_%y", Extension: TILE_FORMAT_JPG, TileResolution: 512, MaxLevel: 3, CubeResolution: 2000},
+		Preview:     "preview/preview.jpg",
+		Thumbnail:   "preview/thumbnail.jpg",
+		Placeholder: "preview/placeholder.jpg",
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+		t.Fail()
+		return
+	}
+	conf.Preview, conf.Thumbnail, conf.Placeholder = "data:a", "data:b", "data:c"
+	errs := conf.Validate()
+	if len(errs) != 3 || errs[0].Field != "preview" || errs[1].Field != "thumbnail" || errs[2].Field != "placeholder" {
+		t.Errorf("unexpected errors %s", errs)
+	}
+}
+
+func TestHotSpotPayload_Validate(t *testing.T) {
+	coordinates := []*HotSpotCoordinates{&HotSpotCoordinates{PageIndex: 0, X: 0.1, Y: 0.2}}
+	conf := &Spin360Config{
+		Pages: []*SpinPage{&SpinPage{ImageURL: "https://example.com/1.png"}},
+		HotSpot: []*PageHotSpot{
+			&PageHotSpot{Type: HOTSPOT_TYPE_IMAGE, Coordinates: coordinates,
+				Images: []*HotSpotImage{&HotSpotImage{URL: "https://example.com/detail.jpg"}}},
+			&PageHotSpot{Type: HOTSPOT_TYPE_VIDEO, Coordinates: coordinates,
+				Video: &HotSpotVideo{URL: "https://example.com/clip.mp4", Type: "video/mp4"}},
+			&PageHotSpot{Type: HOTSPOT_TYPE_PRODUCT, Coordinates: coordinates,
+				Product: &HotSpotProduct{Title: NewLocalizedText("Shoe"), Price: 99.5, Currency: "USD", SKU: "S-1", CTAURL: "https://example.com/buy"}},
+			&PageHotSpot{Type: HOTSPOT_TYPE_SCENE, Coordinates: coordinates,
+				Target: &HotSpotTarget{Type: HOTSPOT_TARGET_VR360, Hash: "abc"}},
+		},
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+		t.Fail()
+		return
+	}
+
+	conf.HotSpot[0].Images = nil
+	conf.HotSpot[1].Video.URL = "ftp://example.com/clip.mp4"
+	conf.HotSpot[2].Product.Currency = "dollar"
+	conf.HotSpot[2].Product.SKU = ""
+	conf.HotSpot[3].Target.Type = "page"
+	errs := conf.Validate()
+	for _, field := range []string{
+		"hotspot[0].images",
+		"hotspot[1].video.url",
+		"hotspot[2].product.currency",
+		"hotspot[2].product.sku",
+		"hotspot[3].target.type",
+	} {
+		if !hasFieldError(errs, field) {
+			t.Errorf("missing error for %s in %s", field, errs)
+		}
+	}
+}
+
+func TestPannellumHotSpot_MarshalJSON(t *testing.T) {
+	hotSpot := &PannellumHotSpot{Id: "a", Type: TYPE_PANNELLUM_HOTSPOT_PRODUCT}
+	data, err := json.Marshal([]*PannellumHotSpot{hotSpot})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if !strings.Contains(string(data), `"cssClass":"pnlm-hotspot-product"`) {
+		t.Errorf("missing default css class in %s", data)
+	}
+
+	hotSpot.CSSClass = "custom"
+	data, _ = json.Marshal(hotSpot)
+	if !strings.Contains(string(data), `"cssClass":"custom"`) {
+		t.Errorf("custom css class overwritten in %s", data)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VIDEO_PREFIX          = "video"
+	HLS_SEGMENT_SECONDS   = 6
+	HLS_MASTER_PLAYLIST   = "master.m3u8"
+	HLS_MIME_TYPE         = "application/x-mpegURL"
+	VIDEO_POSTER_FILE     = "poster.jpg"
+	VIDEO_POSTER_HEIGHT   = 960
+	EQUIRECT_ASPECT_RATIO = 2.0
+)
+
+// ladder for equirectangular video, 360 content needs a higher bitrate
+// than flat video at the same resolution
+var vr360VideoRenditions = []*HLSRendition{
+	&HLSRendition{Name: "3840x1920", Width: 3840, Height: 1920, VideoBitrate: 16000, AudioBitrate: 192},
+	&HLSRendition{Name: "2880x1440", Width: 2880, Height: 1440, VideoBitrate: 10000, AudioBitrate: 160},
+	&HLSRendition{Name: "1920x960", Width: 1920, Height: 960, VideoBitrate: 5000, AudioBitrate: 128},
+	&HLSRendition{Name: "1280x640", Width: 1280, Height: 640, VideoBitrate: 2500, AudioBitrate: 128},
+}
+
+type VideoSource struct {
+	// 视频地址
+	Src string `json:"src"`
+	// MIME 类型
+	Type string `json:"type"`
+}
+
+// VR360 视频播放器配置, 可直接用于 video.js + Pannellum video 插件
+//
+// swagger:model VR360VideoConfig
+type VR360VideoConfig struct {
+	// 全景数据源类型, 固定为 "video"
+	Type string `json:"type"`
+	// 投影方式, 固定为 "equirectangular"
+	Projection string `json:"projection"`
+	// video.js 数据源
+	Sources []*VideoSource `json:"sources"`
+	// 封面图URL
+	Poster string `json:"poster"`
+	// 时长（秒）
+	Duration float64 `json:"duration"`
+	// 原视频宽度
+	Width int `json:"width"`
+	// 原视频高度
+	Height int `json:"height"`
+	// 可用码率
+	Renditions []*HLSRendition `json:"renditions"`
+}
+
+// SelectRenditions keeps the ladder steps not larger than the source, the
+// smallest step is always kept so tiny videos still get one stream.
+func SelectRenditions(width int, height int) []*HLSRendition {
+	list := make([]*HLSRendition, 0)
+	for _, rendition := range vr360VideoRenditions {
+		if rendition.Width <= width && rendition.Height <= height {
+			list = append(list, rendition)
+		}
+	}
+	if len(list) <= 0 {
+		smallest := *vr360VideoRenditions[len(vr360VideoRenditions)-1]
+		smallest.Width = width / 2 * 2
+		smallest.Height = height / 2 * 2
+		smallest.Name = fmt.Sprintf("%dx%d", smallest.Width, smallest.Height)
+		list = append(list, &smallest)
+	}
+
+	return list
+}
+
+func WriteMasterPlaylist(distFileName string, renditions []*HLSRendition) error {
+	buff := bytes.NewBuffer([]byte{})
+	fmt.Fprintln(buff, "#EXTM3U")
+	fmt.Fprintln(buff, "#EXT-X-VERSION:3")
+	for _, rendition := range renditions {
+		fmt.Fprintln(buff, fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d",
+			rendition.Bandwidth(), rendition.Width, rendition.Height))
+		fmt.Fprintln(buff, fmt.Sprintf("%s/index.m3u8", rendition.Name))
+	}
+
+	file, err := os.Create(distFileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, buff)
+
+	return err
+}
+
+func (this *Worker) getVideoKey(hash string) string {
+	return fmt.Sprintf("%s/%s.json", VIDEO_PREFIX, hash)
+}
+
+// VR360Video transcodes an equirectangular video into HLS renditions,
+// uploads them and returns the viewer config URL.
+func (this *Worker) VR360Video(src io.Reader) (string, error) {
+	configURL := ""
+
+	err := this.TempDir(func(tempDir string) error {
+		videoPath := filepath.Join(tempDir, "video.tmp")
+		video, err := os.Create(videoPath)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		defer video.Close()
+
+		_, err = io.Copy(video, src)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		ffprobe := NewFFprobe(this.Conf.FFMpegConf.FFProbe)
+		info, err := ffprobe.GetMediaInfo(videoPath)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		duration, err := info.GetFormat().GetDuration()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		width, height := info.GetStream().GetResolution()
+		if height <= 0 || math.Abs(float64(width)/float64(height)-EQUIRECT_ASPECT_RATIO) > 0.1 {
+			return fmt.Errorf("video %dx%d is not equirectangular (2:1)", width, height)
+		}
+
+		outDir := filepath.Join(tempDir, "hls")
+		renditions := SelectRenditions(width, height)
+		ffmpeg := NewFFmpeg(this.Conf.FFMpegConf.FFmpeg)
+		for _, rendition := range renditions {
+			log.Infof("transcoding %s", rendition.Name)
+			err = ffmpeg.TranscodeHLS(videoPath, filepath.Join(outDir, rendition.Name), rendition, HLS_SEGMENT_SECONDS)
+			if err != nil {
+				return err
+			}
+		}
+		err = WriteMasterPlaylist(filepath.Join(outDir, HLS_MASTER_PLAYLIST), renditions)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		posterHeight := height
+		if posterHeight > VIDEO_POSTER_HEIGHT {
+			posterHeight = VIDEO_POSTER_HEIGHT
+		}
+		err = ffmpeg.SetOutputHeight(posterHeight).Snapshot(videoPath, "00:00:00.000", filepath.Join(outDir, VIDEO_POSTER_FILE))
+		if err != nil {
+			return err
+		}
+
+		s3, err := NewS3Storage(this.GetVR360S3Config())
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		hash := filepath.Base(tempDir)
+		err = this.UploadDir(s3, outDir, hash)
+		if err != nil {
+			return err
+		}
+
+		conf := &VR360VideoConfig{
+			Type:       "video",
+			Projection: VR360_MODE_EQUIRECTANGULAR,
+			Sources: []*VideoSource{
+				&VideoSource{
+					Src:  s3.URL(filepath.ToSlash(filepath.Join(hash, HLS_MASTER_PLAYLIST))),
+					Type: HLS_MIME_TYPE,
+				},
+			},
+			Poster:     s3.URL(filepath.ToSlash(filepath.Join(hash, VIDEO_POSTER_FILE))),
+			Duration:   duration,
+			Width:      width,
+			Height:     height,
+			Renditions: renditions,
+		}
+
+		buff := new(bytes.Buffer)
+		encoder := json.NewEncoder(buff)
+		err = encoder.Encode(conf)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		configKey := this.getVideoKey(hash)
+		_, _, err = s3.PutContent(buff.String(), configKey, &UploadOptions{
+			ContentType: "application/json",
+		})
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		configURL = s3.URL(configKey)
+
+		return nil
+	})
+
+	return configURL, err
+}
+
+func (this *Worker) GetVR360VideoConfig(hash string) (*VR360VideoConfig, error) {
+	s3, err := NewS3Storage(this.GetVR360S3Config())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	reader, err := s3.Get(this.getVideoKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	conf := new(VR360VideoConfig)
+	decoder := json.NewDecoder(reader)
+	err = decoder.Decode(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectRenditions(t *testing.T) {
+	list := SelectRenditions(2880, 1440)
+	if len(list) != 3 || list[0].Name != "2880x1440" {
+		t.Errorf("unexpected renditions %+v", list)
+	}
+
+	list = SelectRenditions(1000, 500)
+	if len(list) != 1 || list[0].Width != 1000 || list[0].Height != 500 {
+		t.Errorf("unexpected renditions %+v", list[0])
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "hls")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	playlist := filepath.Join(tempDir, HLS_MASTER_PLAYLIST)
+	err = WriteMasterPlaylist(playlist, SelectRenditions(1920, 960))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	content, err := ioutil.ReadFile(playlist)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	for _, expected := range []string{"#EXTM3U", "BANDWIDTH=5478000,RESOLUTION=1920x960", "1280x640/index.m3u8"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("missing %q in:\n%s", expected, content)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+)
+
+const VR360_CONFIG_FORMAT_LEGACY = "vr360"
+
+// IsLegacyVR360Config tells a VR360Config body ("src", "hotspot") apart
+// from a PannellumConfig one ("type", "hotSpots").
+func IsLegacyVR360Config(data []byte) bool {
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) != nil {
+		return false
+	}
+
+	_, hasSource := keys["src"]
+	_, hasHotSpot := keys["hotspot"]
+	_, hasType := keys["type"]
+	_, hasHotSpots := keys["hotSpots"]
+
+	return (hasSource || hasHotSpot) && !hasType && !hasHotSpots
+}
+
+// DecodeVR360Config reads either config shape into the canonical
+// PannellumConfig.
+func DecodeVR360Config(data []byte) (*PannellumConfig, error) {
+	if IsLegacyVR360Config(data) {
+		legacy := new(VR360Config)
+		err := json.Unmarshal(data, legacy)
+		if err != nil {
+			return nil, err
+		}
+		return legacy.ToPannellum(), nil
+	}
+
+	conf := new(PannellumConfig)
+	err := json.Unmarshal(data, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func (this *VRHotSpot) ToPannellum() *PannellumHotSpot {
+	return &PannellumHotSpot{
+		Id:    this.Id,
+		Type:  this.Type,
+		Text:  NewLocalizedText(this.Text),
+		Link:  this.URL,
+		Pitch: float64(this.Pitch),
+		Yaw:   float64(this.Yaw),
+	}
+}
+
+// NewVRHotSpot converts back to the legacy shape, pitch and yaw are rounded
+// to whole degrees, the text is resolved for defaultLocale and payloads the
+// legacy model has no field for are dropped.
+func NewVRHotSpot(hotSpot *PannellumHotSpot, defaultLocale string) *VRHotSpot {
+	return &VRHotSpot{
+		Id:    hotSpot.Id,
+		Type:  hotSpot.Type,
+		Text:  hotSpot.Text.Resolve(defaultLocale, defaultLocale),
+		URL:   hotSpot.Link,
+		Pitch: int(math.Round(hotSpot.Pitch)),
+		Yaw:   int(math.Round(hotSpot.Yaw)),
+	}
+}
+
+// ToPannellum treats the legacy source as a single equirectangular image.
+func (this *VR360Config) ToPannellum() *PannellumConfig {
+	conf := &PannellumConfig{
+		Type:    PANNELLUM_TYPE_EQUIRECTANGULAR,
+		URL:     this.Source,
+		HotSpot: make([]*PannellumHotSpot, 0, len(this.HotSpots)),
+	}
+	for _, hotSpot := range this.HotSpots {
+		if hotSpot != nil {
+			conf.HotSpot = append(conf.HotSpot, hotSpot.ToPannellum())
+		}
+	}
+
+	return conf
+}
+
+// NewVR360Config converts back to the legacy shape, multires panoramas
+// without a full image fall back to the equirectangular preview.
+func NewVR360Config(conf *PannellumConfig) *VR360Config {
+	legacy := &VR360Config{
+		Source:   conf.URL,
+		HotSpots: make([]*VRHotSpot, 0, len(conf.HotSpot)),
+	}
+	if len(legacy.Source) <= 0 {
+		legacy.Source = conf.Preview
+	}
+	for _, hotSpot := range conf.HotSpot {
+		if hotSpot != nil {
+			legacy.HotSpots = append(legacy.HotSpots, NewVRHotSpot(hotSpot, conf.DefaultLocale))
+		}
+	}
+
+	return legacy
+}
+
+// MergeInto applies the legacy config to the stored conf, members the
+// legacy shape cannot express are kept: the source only replaces the
+// panorama when it changed and hotspots keep their precise angles,
+// translations and payloads unless the legacy values differ.
+func (this *VR360Config) MergeInto(conf *PannellumConfig) {
+	if len(this.Source) > 0 && this.Source != NewVR360Config(conf).Source {
+		conf.Type = PANNELLUM_TYPE_EQUIRECTANGULAR
+		conf.URL = this.Source
+		conf.Config = nil
+		conf.Preview = ""
+		conf.Thumbnail = ""
+		conf.Placeholder = ""
+	}
+
+	hotSpots := make([]*PannellumHotSpot, 0, len(this.HotSpots))
+	for _, hotSpot := range this.HotSpots {
+		if hotSpot == nil {
+			continue
+		}
+		index := conf.hotSpotIndex(hotSpot.Id)
+		if len(hotSpot.Id) <= 0 || index < 0 {
+			hotSpots = append(hotSpots, hotSpot.ToPannellum())
+			continue
+		}
+		hotSpots = append(hotSpots, hotSpot.mergeInto(conf.HotSpot[index], conf.DefaultLocale))
+	}
+	conf.HotSpot = hotSpots
+}
+
+// mergeInto updates the members of stored the legacy hotspot changed.
+func (this *VRHotSpot) mergeInto(stored *PannellumHotSpot, defaultLocale string) *PannellumHotSpot {
+	hotSpot := *stored
+	previous := NewVRHotSpot(stored, defaultLocale)
+	if this.Type != previous.Type {
+		hotSpot.Type = this.Type
+	}
+	if this.URL != previous.URL {
+		hotSpot.Link = this.URL
+	}
+	if this.Pitch != previous.Pitch {
+		hotSpot.Pitch = float64(this.Pitch)
+	}
+	if this.Yaw != previous.Yaw {
+		hotSpot.Yaw = float64(this.Yaw)
+	}
+	if this.Text != previous.Text {
+		if stored.Text.IsLocalized() && len(defaultLocale) > 0 {
+			hotSpot.Text = make(LocalizedText, len(stored.Text)+1)
+			for locale, text := range stored.Text {
+				hotSpot.Text[locale] = text
+			}
+			hotSpot.Text[defaultLocale] = this.Text
+		} else {
+			hotSpot.Text = NewLocalizedText(this.Text)
+		}
+	}
+
+	return &hotSpot
+}
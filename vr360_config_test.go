@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeVR360Config_Legacy(t *testing.T) {
+	conf, err := DecodeVR360Config([]byte(`{"src":"https://example.com/pano.jpg","hotspot":[
+		{"id":"a","pitch":-10,"yaw":45,"type":"link","url":"https://example.com","text":"Shop"}]}`))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if conf.Type != PANNELLUM_TYPE_EQUIRECTANGULAR || conf.URL != "https://example.com/pano.jpg" || len(conf.HotSpot) != 1 {
+		t.Errorf("unexpected config %+v", conf)
+		t.Fail()
+		return
+	}
+	hotSpot := conf.HotSpot[0]
+	if hotSpot.Link != "https://example.com" || hotSpot.Pitch != -10 || hotSpot.Yaw != 45 || hotSpot.Text[""] != "Shop" {
+		t.Errorf("unexpected hotspot %+v", hotSpot)
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		t.Error(errs)
+	}
+
+	legacy := NewVR360Config(conf)
+	if legacy.Source != conf.URL || legacy.HotSpots[0].URL != hotSpot.Link || legacy.HotSpots[0].Yaw != 45 {
+		t.Errorf("unexpected legacy config %+v", legacy.HotSpots[0])
+	}
+}
+
+func TestDecodeVR360Config_Pannellum(t *testing.T) {
+	conf, err := DecodeVR360Config([]byte(`{"type":"equirectangular","panorama":"https://example.com/pano.jpg",
+		"hotSpots":[{"id":"a","pitch":1.5,"yaw":2.5,"type":"text","text":"Hi"}]}`))
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if len(conf.HotSpot) != 1 || conf.HotSpot[0].Pitch != 1.5 {
+		t.Errorf("unexpected config %+v", conf)
+	}
+	if NewVRHotSpot(conf.HotSpot[0], "").Pitch != 2 {
+		t.Error("pitch should be rounded for the legacy shape")
+	}
+}
+
+func TestWorker_MergeLegacyVR360Config(t *testing.T) {
+	store := NewConfigStore(newMemoryStorage())
+	_, saved, err := store.Save("abc", &PannellumConfig{
+		Type:        PANNELLUM_TYPE_MULTIRES,
+		Config:      &MultiResConfig{BasePath: "tiles", Path: "/%l/%s%x_%y", Extension: "jpg", TileResolution: 512, MaxLevel: 3, CubeResolution: 2048},
+		Preview:     "preview/preview.jpg",
+		Thumbnail:   "preview/thumbnail.jpg",
+		Placeholder: "preview/placeholder.jpg",
+		HotSpot: []*PannellumHotSpot{
+			&PannellumHotSpot{Id: "a", Type: TYPE_PANNELLUM_HOTSPOT_TEXT, Pitch: 10.4, Yaw: 20.6,
+				Text: LocalizedText{"en": "Hello", "fr": "Bonjour"}},
+			&PannellumHotSpot{Id: "b", Type: TYPE_PANNELLUM_HOTSPOT_LINK, Link: "https://example.com", Text: NewLocalizedText("Shop")},
+		},
+		DefaultLocale: "en",
+	})
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	// a legacy client reads ?format=vr360, moves one hotspot and posts it back
+	stored := new(PannellumConfig)
+	_, err = store.Load("abc", 0, stored)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	legacy := NewVR360Config(stored)
+	legacy.HotSpots[1].Yaw = 90
+
+	worker := NewWorker(&Config{})
+	_, _, err = worker.mergeLegacyVR360Config(store, "abc", legacy, saved.ETag)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+
+	conf := new(PannellumConfig)
+	_, err = store.Load("abc", 0, conf)
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	if conf.Type != PANNELLUM_TYPE_MULTIRES || conf.Config == nil || len(conf.URL) > 0 ||
+		conf.Preview != "preview/preview.jpg" || conf.Thumbnail != "preview/thumbnail.jpg" || conf.Placeholder != "preview/placeholder.jpg" {
+		t.Errorf("panorama was not kept: %+v", conf)
+	}
+	if len(conf.HotSpot) != 2 || conf.HotSpot[0].Pitch != 10.4 || conf.HotSpot[0].Text["fr"] != "Bonjour" || conf.HotSpot[1].Yaw != 90 {
+		t.Errorf("unexpected hotspots %+v %+v", conf.HotSpot[0], conf.HotSpot[1])
+	}
+
+	// a new source replaces the panorama
+	legacy.Source = "https://example.com/pano.jpg"
+	_, _, err = worker.mergeLegacyVR360Config(store, "abc", legacy, "")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+		return
+	}
+	conf = new(PannellumConfig)
+	_, err = store.Load("abc", 0, conf)
+	if err != nil || conf.Type != PANNELLUM_TYPE_EQUIRECTANGULAR || conf.URL != legacy.Source || conf.Config != nil || len(conf.Preview) > 0 {
+		t.Errorf("unexpected config %+v %v", conf, err)
+	}
+
+	_, _, err = worker.mergeLegacyVR360Config(store, "abc", legacy, saved.ETag)
+	if err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"github.com/satori/go.uuid"
-	"time"
 )
 
 type Worker struct {
 	Conf *Config
+	// bytes fetched for a throttled request count against its tenant
+	quota *requestQuota
+}
+
+// VR360Source describes the panorama input of a VR360 job, Faces are only
+// used in cubemap mode and must be ordered as faceLetters.
+type VR360Source struct {
+	Mode    string
+	Image   io.ReadSeeker
+	Faces   []io.Reader
+	Fisheye *FisheyeOptions
 }
 
 func NewWorker(conf *Config) *Worker {
@@ -220,82 +228,139 @@ func (this *Worker) S3(src io.Reader, size int) ([]string, error) {
 	return s3List, nil
 }
 
-func (this *Worker) UpdatePlayConfig(hash string, conf *Spin360Config) (string, error) {
-	remoteKey := fmt.Sprintf("%s.json", hash)
-
+func (this *Worker) getConfigStore() (*ConfigStore, error) {
 	s3, err := NewS3Storage(this.Conf.S3)
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return nil, err
 	}
 
-	buf := new(bytes.Buffer)
-	encoder := json.NewEncoder(buf)
-	err = encoder.Encode(conf)
+	return NewConfigStore(s3), nil
+}
+
+func (this *Worker) getVR360ConfigStore() (*ConfigStore, error) {
+	s3, err := NewS3Storage(this.GetVR360S3Config())
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return nil, err
 	}
 
-	_, url, err := s3.PutContent(buf.String(), remoteKey, &UploadOptions{
-		ContentType: "application/json",
-	})
+	return NewConfigStore(s3), nil
+}
+
+func (this *Worker) UpdatePlayConfig(hash string, conf *Spin360Config) (string, error) {
+	url, _, err := this.UpdatePlayConfigIfMatch(hash, conf, "")
+
+	return url, err
+}
+
+// UpdatePlayConfigIfMatch saves the config only if the stored one still has
+// the etag given in ifMatch, returning the new url and etag.
+func (this *Worker) UpdatePlayConfigIfMatch(hash string, conf *Spin360Config, ifMatch string) (string, string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return "", "", err
+	}
+
+	url, version, err := store.SaveIfMatch(hash, conf, ifMatch)
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return "", "", err
 	}
 
-	return url, nil
+	return url, version.ETag, nil
 }
 
-func (this *Worker) GetConfig(hash string) (*Spin360Config, error) {
-	remoteKey := fmt.Sprintf("%s.json", hash)
+// GetConfig loads a spin360 config, version 0 is the current one.
+func (this *Worker) GetConfig(hash string, version int) (*Spin360Config, error) {
+	conf, _, err := this.GetConfigWithETag(hash, version)
 
-	s3, err := NewS3Storage(this.Conf.S3)
+	return conf, err
+}
+
+func (this *Worker) GetConfigWithETag(hash string, version int) (*Spin360Config, string, error) {
+	store, err := this.getConfigStore()
 	if err != nil {
-		log.Error(err)
-		return nil, err
+		return nil, "", err
 	}
 
-	reader, err := s3.Get(remoteKey)
+	conf := new(Spin360Config)
+	etag, err := store.Load(hash, version, conf)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	conf.EnsureHotSpotIds()
 
-	conf := new(Spin360Config)
+	return conf, etag, nil
+}
 
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(conf)
+func (this *Worker) GetConfigVersions(hash string) ([]*ConfigVersion, error) {
+	store, err := this.getConfigStore()
 	if err != nil {
 		return nil, err
 	}
 
-	return conf, nil
+	return store.Versions(hash)
 }
 
-func (this *Worker) GetVR360Config(hash string) (*PannellumConfig, error) {
-	remoteKey := fmt.Sprintf("%s.json", hash)
+func (this *Worker) RollbackConfig(hash string, version int, ifMatch string) (string, string, error) {
+	store, err := this.getConfigStore()
+	if err != nil {
+		return "", "", err
+	}
 
-	s3, err := NewS3Storage(this.GetVR360S3Config())
+	url, saved, err := store.Rollback(hash, version, ifMatch)
 	if err != nil {
-		log.Error(err)
-		return nil, err
+		return "", "", err
 	}
 
-	reader, err := s3.Get(remoteKey)
+	return url, saved.ETag, nil
+}
+
+// GetVR360Config loads a VR360 config, version 0 is the current one.
+func (this *Worker) GetVR360Config(hash string, version int) (*PannellumConfig, error) {
+	conf, _, err := this.GetVR360ConfigWithETag(hash, version)
+
+	return conf, err
+}
+
+func (this *Worker) GetVR360ConfigWithETag(hash string, version int) (*PannellumConfig, string, error) {
+	store, err := this.getVR360ConfigStore()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	conf := new(PannellumConfig)
+	etag, err := store.Load(hash, version, conf)
+	if err != nil {
+		return nil, "", err
+	}
+	conf.EnsureHotSpotIds()
 
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(conf)
+	return conf, etag, nil
+}
+
+func (this *Worker) GetVR360ConfigVersions(hash string) ([]*ConfigVersion, error) {
+	store, err := this.getVR360ConfigStore()
 	if err != nil {
 		return nil, err
 	}
 
-	return conf, nil
+	return store.Versions(hash)
+}
+
+func (this *Worker) RollbackVR360Config(hash string, version int, ifMatch string) (string, string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return "", "", err
+	}
+
+	url, saved, err := store.Rollback(hash, version, ifMatch)
+	if err != nil {
+		return "", "", err
+	}
+
+	return url, saved.ETag, nil
 }
 
 func (this *Worker) SaveVR360Config(conf *PannellumConfig, hashArgs ...string) (string, error) {
@@ -303,31 +368,69 @@ func (this *Worker) SaveVR360Config(conf *PannellumConfig, hashArgs ...string) (
 	if len(hashArgs) > 0 {
 		hash = hashArgs[0]
 	}
-	remoteKey := fmt.Sprintf("%s.json", hash)
 
-	s3, err := NewS3Storage(this.GetVR360S3Config())
+	url, _, err := this.UpdateVR360ConfigIfMatch(hash, conf, "")
+
+	return url, err
+}
+
+// UpdateVR360ConfigIfMatch saves the config only if the stored one still has
+// the etag given in ifMatch, returning the new url and etag.
+func (this *Worker) UpdateVR360ConfigIfMatch(hash string, conf *PannellumConfig, ifMatch string) (string, string, error) {
+	store, err := this.getVR360ConfigStore()
 	if err != nil {
-		log.Error(err)
-		return "", err
+		return "", "", err
 	}
 
-	buf := new(bytes.Buffer)
-	encoder := json.NewEncoder(buf)
-	err = encoder.Encode(conf)
+	url, version, err := store.SaveIfMatch(hash, conf, ifMatch)
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return "", "", err
 	}
 
-	_, url, err := s3.PutContent(buf.String(), remoteKey, &UploadOptions{
-		ContentType: "application/json",
-	})
+	return url, version.ETag, nil
+}
+
+// UpdateLegacyVR360ConfigIfMatch saves a legacy config under hash, it is
+// merged into the stored config so fields the legacy shape has no room for
+// survive.
+func (this *Worker) UpdateLegacyVR360ConfigIfMatch(hash string, legacy *VR360Config, ifMatch string) (string, string, error) {
+	store, err := this.getVR360ConfigStore()
+	if err != nil {
+		return "", "", err
+	}
+
+	return this.mergeLegacyVR360Config(store, hash, legacy, ifMatch)
+}
+
+func (this *Worker) mergeLegacyVR360Config(store *ConfigStore, hash string, legacy *VR360Config, ifMatch string) (string, string, error) {
+	conf := new(PannellumConfig)
+	etag, err := store.Load(hash, 0, conf)
+	if err == ErrObjectNotFound {
+		conf = legacy.ToPannellum()
+	} else if err != nil {
+		return "", "", err
+	} else {
+		if len(ifMatch) > 0 && !MatchETag(ifMatch, etag) {
+			return "", "", ErrPreconditionFailed
+		}
+		conf.EnsureHotSpotIds()
+		legacy.MergeInto(conf)
+		// nobody may save between the load and the merged save
+		ifMatch = etag
+	}
+
+	if errs := conf.Validate(); len(errs) > 0 {
+		return "", "", errs
+	}
+
+	url, version, err := store.SaveIfMatch(hash, conf, ifMatch)
 	if err != nil {
 		log.Error(err)
-		return "", err
+		return "", "", err
 	}
 
-	return url, nil
+	return url, version.ETag, nil
 }
 
 func (this *Worker) GetVR360S3Config() *S3Config {
@@ -347,7 +450,7 @@ func (this *Worker) SavePlayConfig(conf *Spin360Config) (string, error) {
 func  (this *Worker) S3FromURL(URL string, size int) ([]string, error)  {
 	log.Info(`download file from `, URL)
 
-	reader, err := this.DownloadRemoteFile(URL)
+	reader, err := this.DownloadRemoteFile(URL, "video/")
 	if err != nil {
 		return nil, err
 	}
@@ -356,26 +459,48 @@ func  (this *Worker) S3FromURL(URL string, size int) ([]string, error)  {
 	return this.S3(reader, size)
 }
 
-func (this *Worker) DownloadRemoteFile(URL string) (io.ReadCloser, error) {
-	httpClient := http.Client{
-		Timeout: time.Minute * 30,
+// S3FromKey splits a video already in storage, see FetchObject.
+func (this *Worker) S3FromKey(backend string, key string, size int) ([]string, error) {
+	file, _, err := this.FetchObject(backend, key)
+	if err != nil {
+		return nil, err
 	}
-	resp, err := httpClient.Get(URL)
+	defer file.Close()
+
+	return this.S3(file, size)
+}
+
+// DownloadRemoteFile fetches a caller supplied URL within Config.Download,
+// contentTypes are the accepted type prefixes, e.g. "video/".
+func (this *Worker) DownloadRemoteFile(URL string, contentTypes ...string) (io.ReadCloser, error) {
+	policy := NewDownloadPolicy(this.Conf.Download)
+	reader, err := policy.Download(context.Background(), URL, contentTypes...)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
-	return resp.Body, nil
+	return this.quota.ReadCloser(reader), nil
 }
 
-func (this *Worker) VR360ToS3(src io.ReadSeeker) (string, error) {
+func (this *Worker) GenerateVR360(tempDir string, src *VR360Source, opts *TileOptions) (*PannellumConfig, error) {
+	nona := NewNonaWrapper(``).SetOptions(opts)
+
+	switch src.Mode {
+	case VR360_MODE_CUBEMAP:
+		return nona.GenerateFromCubeFaces(tempDir, src.Faces)
+	case VR360_MODE_DUAL_FISHEYE:
+		return nona.GenerateFromDualFisheye(tempDir, src.Image, src.Fisheye)
+	default:
+		return nona.GenerateFromReader(tempDir, src.Image)
+	}
+}
+
+func (this *Worker) VR360ToS3(src *VR360Source, opts *TileOptions) (string, error) {
 	configURL := ""
 
 	err := this.TempDir(func(tempDir string) error {
-		nona := NewNonaWrapper(``)
-
-		conf, err := nona.GenerateFromReader(tempDir, src)
+		conf, err := this.GenerateVR360(tempDir, src, opts)
 		if err != nil {
 			return err
 		}
@@ -387,82 +512,85 @@ func (this *Worker) VR360ToS3(src io.ReadSeeker) (string, error) {
 			return err
 		}
 
-		queue := make(chan bool, 0)
-		maxTask := make(chan bool, 2)
-		defer close(queue)
-		defer close(maxTask)
-		jobCount := 0
-
-		err = filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
-			}
-			remoteBase := strings.Replace(filepath.ToSlash(path), filepath.ToSlash(tempDir), "", 1)
-			remotePath := filepath.ToSlash(filepath.Join(filepath.Base(tempDir), remoteBase))
-
-			jobCount++
-			go func(path string, remotePath string, s3 IStorage) {
-				maxTask <- true
-				defer func() {
-					<-maxTask
-					queue <- true
-				}()
-				log.Infof("%s => s3:%s", path, remotePath)
-
-				_, _, err := s3.Upload(path, remotePath)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-			}(path, remotePath, s3)
-
-			return nil
-		})
-
-		for jobCount > 0 {
-			<-queue
-			jobCount--
+		err = this.UploadDir(s3, tempDir, filepath.Base(tempDir))
+		if err != nil {
+			return err
 		}
 
 		hash := filepath.Base(tempDir)
 		conf.URL = s3.URL(filepath.ToSlash(filepath.Join(hash, conf.URL)))
+		conf.Preview = s3.URL(filepath.ToSlash(filepath.Join(hash, conf.Preview)))
+		conf.Thumbnail = s3.URL(filepath.ToSlash(filepath.Join(hash, conf.Thumbnail)))
+		conf.Placeholder = s3.URL(filepath.ToSlash(filepath.Join(hash, conf.Placeholder)))
 		conf.Config.BasePath = s3.URL(filepath.ToSlash(filepath.Join(hash, conf.Config.BasePath)))
-		configKey := fmt.Sprintf(`%s.json`, hash)
-
-		buff := new(bytes.Buffer)
-		encoder := json.NewEncoder(buff)
-		err = encoder.Encode(conf)
+		_, _, err = NewConfigStore(s3).Save(hash, conf)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
+		// clients parse the task result, it stays the URL of {hash}.json
+		configURL = s3.URL(fmt.Sprintf("%s.json", hash))
 
-		_, _, err = s3.PutContent(buff.String(), configKey, &UploadOptions{
-			ContentType:"application/json",
-		})
+		return nil
+	})
+
+	return configURL, err
+}
+
+// UploadDir uploads every file under localDir to remoteDir keeping the
+// relative layout, two files at a time.
+func (this *Worker) UploadDir(s3 IStorage, localDir string, remoteDir string) error {
+	queue := make(chan error, 0)
+	maxTask := make(chan bool, 2)
+	defer close(queue)
+	defer close(maxTask)
+	jobCount := 0
+
+	err := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Error(err)
 			return err
 		}
+		if info.IsDir() {
+			return nil
+		}
+		remoteBase := strings.Replace(filepath.ToSlash(path), filepath.ToSlash(localDir), "", 1)
+		remotePath := filepath.ToSlash(filepath.Join(remoteDir, remoteBase))
 
-		configURL = s3.URL(configKey)
+		jobCount++
+		go func(path string, remotePath string, s3 IStorage) {
+			maxTask <- true
+			var err error
+			defer func() {
+				<-maxTask
+				queue <- err
+			}()
+			log.Infof("%s => s3:%s", path, remotePath)
+
+			_, _, err = s3.Upload(path, remotePath)
+			if err != nil {
+				log.Error(err)
+			}
+		}(path, remotePath, s3)
 
 		return nil
 	})
 
-	return configURL, err
+	for jobCount > 0 {
+		uploadErr := <-queue
+		if err == nil {
+			err = uploadErr
+		}
+		jobCount--
+	}
+
+	return err
 }
 
-func (this *Worker) VR360(ctx context.Context, src io.ReadSeeker) (io.Reader, error) {
+func (this *Worker) VR360(ctx context.Context, src *VR360Source, opts *TileOptions) (io.Reader, error) {
 	var zipPath string
 
 	err := this.TempDir(func(tempDir string) error {
-		nona := NewNonaWrapper(``)
-
-		conf, err := nona.GenerateFromReader(tempDir, src)
+		conf, err := this.GenerateVR360(tempDir, src, opts)
 		if err != nil {
 			return err
 		}
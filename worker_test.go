@@ -80,11 +80,11 @@ func TestWorker_SavePlayConfig(t *testing.T) {
 		HotSpot: []*PageHotSpot{
 			&PageHotSpot{
 				Type: HOTSPOT_TYPE_TEXT,
-				Text: "Hello",
+				Text: NewLocalizedText("Hello"),
 				Coordinates: []*HotSpotCoordinates{
 					&HotSpotCoordinates{
-						X: "10%",
-						Y: "10%",
+						X:         0.1,
+						Y:         0.1,
 						PageIndex: 0,
 					},
 				},
@@ -109,7 +109,7 @@ func TestWorker_GetConfig(t *testing.T) {
 	}
 	worker := NewWorker(conf)
 
-	spin360Config, err := worker.GetConfig("225c2811-c13b-4e3b-81c5-3dbb53075bd2")
+	spin360Config, err := worker.GetConfig("225c2811-c13b-4e3b-81c5-3dbb53075bd2", 0)
 	if err != nil {
 		t.Error(err)
 		t.Fail()
@@ -138,7 +138,7 @@ func TestWorker_VR360(t *testing.T) {
 
 	ctx, _ := context.WithDeadline(context.Background(), time.Now().Add(time.Minute * 30))
 
-	_, err = worker.VR360(ctx, img)
+	_, err = worker.VR360(ctx, &VR360Source{Image: img}, NewTileOptions())
 	if err != nil {
 		t.Error(err)
 		t.Fail()
@@ -165,7 +165,7 @@ func TestWorker_VR360ToS3(t *testing.T) {
 	}
 	defer img.Close()
 
-	url, err := worker.VR360ToS3(img)
+	url, err := worker.VR360ToS3(&VR360Source{Image: img}, NewTileOptions())
 	if err != nil {
 		t.Error(err)
 		t.Fail()
@@ -42,7 +42,9 @@ func (this *CommandBuilder) Run() (io.Reader, error) {
 
 	log.Debug(this.cmd)
 
+	start := time.Now()
 	err := this.cmd.Run()
+	observeProcess(this.Bin, start, this.cmd.ProcessState)
 	if err != nil {
 		log.Error(err)
 		return nil, errors.New(errorPipe.String())
@@ -61,8 +63,10 @@ func (this *CommandBuilder) Start() (chan io.Reader, error) {
 
 	log.Debug(this.cmd)
 
+	start := time.Now()
 	err := this.cmd.Start()
 	if err != nil {
+		observeProcess(this.Bin, start, nil)
 		log.Error(err)
 		log.Error(errorPipe.String())
 		return nil, err
@@ -71,6 +75,7 @@ func (this *CommandBuilder) Start() (chan io.Reader, error) {
 	done := make(chan io.Reader, 0)
 	go func() {
 		err := this.cmd.Wait()
+		observeProcess(this.Bin, start, this.cmd.ProcessState)
 		if err != nil {
 			log.Error(err)
 			done <- strings.NewReader(err.Error())
@@ -82,6 +87,28 @@ func (this *CommandBuilder) Start() (chan io.Reader, error) {
 	return done, nil
 }
 
+// Probe runs the command and returns stdout and stderr combined, exit codes
+// in accepted, e.g. of tools exiting with 1 after printing usage, are not
+// an error.
+func (this *CommandBuilder) Probe(accepted ...int) (string, error) {
+	this.cmd = exec.Command(this.Bin, this.Params...)
+
+	log.Debug(this.cmd)
+
+	start := time.Now()
+	output, err := this.cmd.CombinedOutput()
+	observeProcess(this.Bin, start, this.cmd.ProcessState)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		for _, code := range accepted {
+			if exitErr.ExitCode() == code {
+				return string(output), nil
+			}
+		}
+	}
+
+	return string(output), err
+}
+
 func (this *CommandBuilder) Process() *os.Process {
 	return this.cmd.Process
 }
@@ -257,4 +284,81 @@ func (this *FFmpeg) SplitSnap(mediaPath string, duration float64, splitSize floa
 	defer close(doneQueue)
 
 	return nil
-}
\ No newline at end of file
+}
+
+type HLSRendition struct {
+	// 渲染名称，同时作为子目录名
+	Name string `json:"name"`
+	// 输出宽度
+	Width int `json:"width"`
+	// 输出高度
+	Height int `json:"height"`
+	// 视频码率 (kbps)
+	VideoBitrate int `json:"videoBitrate"`
+	// 音频码率 (kbps)
+	AudioBitrate int `json:"audioBitrate"`
+}
+
+// Bandwidth returns the peak bandwidth in bits per second for the master playlist.
+func (this *HLSRendition) Bandwidth() int {
+	return (this.VideoBitrate*107/100 + this.AudioBitrate) * 1000
+}
+
+// TranscodeHLS encodes one rendition into outPath/index.m3u8 with segments
+// of segmentSeconds, keyframes are forced on segment boundaries so all
+// renditions can switch cleanly.
+func (this *FFmpeg) TranscodeHLS(mediaPath string, outPath string, rendition *HLSRendition, segmentSeconds int) error {
+	if _, err := os.Stat(outPath); err != nil && os.IsNotExist(err) {
+		os.MkdirAll(outPath, os.ModePerm)
+	}
+
+	this.builder = NewBuilder(this.bin).SetParams(
+		"-y",
+		"-i", filepath.ToSlash(mediaPath),
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=%d:%d", rendition.Width, rendition.Height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "high",
+		"-pix_fmt", "yuv420p",
+		"-b:v", fmt.Sprintf("%dk", rendition.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", rendition.VideoBitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", rendition.VideoBitrate*3/2),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds),
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", rendition.AudioBitrate),
+		"-ac", "2",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", segmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.ToSlash(filepath.Join(outPath, "segment_%04d.ts")),
+		filepath.ToSlash(filepath.Join(outPath, "index.m3u8")))
+
+	_, err := this.builder.Run()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func (this *FFmpeg) Snapshot(mediaPath string, position string, outFile string) error {
+	this.builder = NewBuilder(this.bin).SetParams(
+		"-ss", position,
+		"-y",
+		"-i", filepath.ToSlash(mediaPath),
+		"-filter:v", fmt.Sprintf("scale=-1:%d", this.outHeight),
+		"-vframes", "1",
+		filepath.ToSlash(outFile))
+
+	_, err := this.builder.Run()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return nil
+}